@@ -2,21 +2,37 @@ package main
 
 import (
 	"blockci-q/internal/core"
+	"blockci-q/internal/security"
+	"blockci-q/pkg/config"
 	"blockci-q/pkg/utils"
 	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 )
 
 type Agent struct {
-	ID   string `json:"id"`
-	Host string `json:"host"`
+	ID        string `json:"id"`
+	Host      string `json:"host"`
+	Nonce     string `json:"nonce,omitempty"`
+	Signature string `json:"signature,omitempty"`
+}
+
+// Session is the agent's authenticated session with the server
+type Session struct {
+	ServerURL string
+	AgentID   string
+	PrivKey   ed25519.PrivateKey
+	Token     string
 }
 
 type Job struct {
@@ -28,47 +44,186 @@ type Job struct {
 }
 
 func main() {
-	serverURL := "http://localhost:8080"
+	cfgPath := os.Getenv("BLOCKCI_AGENT_CONFIG")
+	if cfgPath == "" {
+		cfgPath = "./configs/agent.yaml"
+	}
+	cfg, err := config.LoadAgentConfig(cfgPath)
+	if err != nil {
+		fmt.Printf("⚠️ WARN: cannot load agent config %s: %v\n", cfgPath, err)
+		cfg = &config.AgentConfig{}
+	}
+
+	serverURL := cfg.ServerURL
+	if serverURL == "" {
+		serverURL = "http://localhost:8080"
+	}
 
 	agentID := os.Getenv("AGENT_ID")
+	if agentID == "" {
+		agentID = cfg.AgentID
+	}
 	if agentID == "" {
 		agentID = fmt.Sprintf("agent-%s", uuid.New().String()[:8])
 	}
 
+	priv, err := loadAgentKey(cfg)
+	if err != nil {
+		fmt.Println("❌ failed to load agent key:", err)
+		os.Exit(1)
+	}
+
 	runner := core.NewRunner()
+	runner.AgentID = agentID
+	runner.PrivKey = priv
+	runner.PubKey = priv.Public().(ed25519.PublicKey)
 
-	if err := registerAgent(serverURL, agentID); err != nil {
+	sess := &Session{ServerURL: serverURL, AgentID: agentID, PrivKey: priv}
+	if err := sess.register(); err != nil {
 		fmt.Println("❌ failed to register agent:", err)
 		os.Exit(1)
 	}
 
 	fmt.Println("✅ Agent started with ID:", agentID)
-	pollJobs(serverURL, agentID, runner)
+	pollJobs(sess, runner)
 }
 
-func registerAgent(serverURL, id string) error {
-	agent := Agent{ID: id, Host: "localhost"}
-	data, _ := json.Marshal(agent)
+// loadAgentKey returns the agent's persistent signing key: the one in the
+// agent config, or ./keys/agent.priv (generated on first start).
+func loadAgentKey(cfg *config.AgentConfig) (ed25519.PrivateKey, error) {
+	if cfg.PrivateKey != "" {
+		return security.DecodePrivateKey(cfg.PrivateKey)
+	}
+	pub, priv, created, err := security.EnsureKeyPair("./keys/agent.pub", "./keys/agent.priv")
+	if err != nil {
+		return nil, err
+	}
+	if created {
+		fmt.Println("🔑 Generated agent key; trust it on the server with pubkey:", base64.StdEncoding.EncodeToString(pub))
+	}
+	return priv, nil
+}
 
-	resp, err := http.Post(serverURL+"/agent/register", "application/json", bytes.NewBuffer(data))
+// register answers a registration challenge with the agent key and
+// stores the session token the server hands out
+func (sess *Session) register() error {
+	data, _ := json.Marshal(Agent{ID: sess.AgentID})
+	resp, err := http.Post(sess.ServerURL+"/agent/challenge", "application/json", bytes.NewBuffer(data))
+	if err != nil {
+		return fmt.Errorf("failed to request challenge: %w", err)
+	}
+	var challenge struct {
+		Nonce string `json:"nonce"`
+	}
+	if err := decodeResponse(resp, &challenge); err != nil {
+		return fmt.Errorf("challenge failed: %w", err)
+	}
+
+	agent := Agent{
+		ID:        sess.AgentID,
+		Host:      "localhost",
+		Nonce:     challenge.Nonce,
+		Signature: security.SignData(sess.PrivKey, security.RegistrationMessage(sess.AgentID, challenge.Nonce)),
+	}
+	data, _ = json.Marshal(agent)
+
+	resp, err = http.Post(sess.ServerURL+"/agent/register", "application/json", bytes.NewBuffer(data))
 	if err != nil {
 		return fmt.Errorf("failed to register agent: %w", err)
 	}
-	defer resp.Body.Close()
+	var registered struct {
+		Token string `json:"token"`
+	}
+	if err := decodeResponse(resp, &registered); err != nil {
+		return fmt.Errorf("register failed: %w", err)
+	}
+	sess.Token = registered.Token
+
+	fmt.Println("🤝 Agent registered:", sess.AgentID)
+	return nil
+}
+
+// do sends an authenticated JSON request
+func (sess *Session) do(method, url string, body []byte) (*http.Response, error) {
+	return sess.doStream(method, url, "application/json", func() (io.Reader, error) {
+		return bytes.NewReader(body), nil
+	})
+}
+
+// doStream sends an authenticated request whose body is opened by open
+// (called again for the retry). When the session expired it registers
+// again and retries once.
+func (sess *Session) doStream(method, url, contentType string, open func() (io.Reader, error)) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		body, err := open()
+		if err != nil {
+			return nil, err
+		}
+		req, err := http.NewRequest(method, url, body)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+sess.Token)
+		req.Header.Set("Content-Type", contentType)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil || resp.StatusCode != http.StatusUnauthorized || attempt > 0 {
+			return resp, err
+		}
+		resp.Body.Close()
+
+		fmt.Println("🔄 Session rejected, registering again")
+		if err := sess.register(); err != nil {
+			return nil, err
+		}
+	}
+}
 
+// statusError is a non-200 server response
+type statusError struct {
+	Code int
+	Body string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("server returned %d: %s", e.Code, strings.TrimSpace(e.Body))
+}
+
+// decodeResponse decodes a 200 JSON response or turns the body into a *statusError
+func decodeResponse(resp *http.Response, out interface{}) error {
+	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("register failed: %s", string(body))
+		return &statusError{Code: resp.StatusCode, Body: string(body)}
 	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
 
-	fmt.Println("🤝 Agent registered:", id)
-	return nil
+const (
+	uploadAttempts = 5                // log upload attempts before the log is given up
+	retryMaxDelay  = 30 * time.Second // backoff cap
+)
+
+// withRetry calls fn until it succeeds, the server rejects the request
+// (4xx) or attempts run out (0 = no limit), doubling the delay each time
+func withRetry(what string, attempts int, fn func() error) error {
+	delay := time.Second
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		var rejected *statusError
+		if err == nil || (errors.As(err, &rejected) && rejected.Code < 500) || (attempts > 0 && attempt >= attempts) {
+			return err
+		}
+		fmt.Printf("⚠️ %s failed (attempt %d): %v, retrying in %s\n", what, attempt, err, delay)
+		time.Sleep(delay)
+		delay = min(2*delay, retryMaxDelay)
+	}
 }
 
-func pollJobs(serverURL, id string, runner *core.Runner) {
+func pollJobs(sess *Session, runner *core.Runner) {
 	for {
-		url := fmt.Sprintf("%s/agents/%s/jobs/next", serverURL, id)
-		resp, err := http.Get(url)
+		url := fmt.Sprintf("%s/agents/%s/jobs/next", sess.ServerURL, sess.AgentID)
+		resp, err := sess.do(http.MethodGet, url, nil)
 		if err != nil {
 			fmt.Println("⚠️ poll error:", err)
 			time.Sleep(5 * time.Second)
@@ -100,47 +255,93 @@ func pollJobs(serverURL, id string, runner *core.Runner) {
 
 		fmt.Printf("📥 Received job: %s (cmd=%s, pipeline=%s)\n", job.ID, job.Cmd, job.PipelineID)
 
-		output, success, logPath, logHash := runJob(job, id, runner)
+		res, logHash := runJob(job, runner)
+		logErr := deliverLog(sess, job.ID, res.LogPath, logHash)
+		if logErr != nil {
+			// report anyway so the server can close the job; it records
+			// the step as failed with an empty log
+			fmt.Println("⚠️ log of job", job.ID, "not delivered:", logErr)
+			logHash = emptyLogHash
+		}
 
-		reportResult(serverURL, job, id, output, success, logPath, logHash)
+		if err := reportResult(sess, job, runner, res, logHash, logErr); err != nil {
+			fmt.Println("❌ failed to report result of job", job.ID+":", err)
+		}
 	}
 }
 
-func runJob(job Job, agentID string, runner *core.Runner) (string, bool, string, string) {
-	pipeline := &core.Pipeline{
-		Agent: agentID,
-		Stages: []core.Stage{
-			{
-				Name: job.Stage,
-				Steps: []core.Step{
-					{Name: job.Step, Run: job.Cmd},
-				},
-			},
-		},
-	}
-
-	// Assume runner.RunPipeline returns map[string]string (stepCmd->logPath) and error
-	results, err := runner.RunPipeline(pipeline)
-	if err != nil {
-		return fmt.Sprintf("job %s failed: %v", job.ID, err), false, "", ""
-	}
+// runJob executes a job and returns its result and log hash.
+// The log of a failed step is reported too, so failures are auditable.
+func runJob(job Job, runner *core.Runner) (core.StepResult, string) {
+	res := runner.RunStep(job.Stage, core.Step{Name: job.Step, Run: job.Cmd})
 
-	var logPath string
-	for _, lp := range results {
-		logPath = lp
-		break
-	}
 	logHash := ""
-	if logPath != "" {
-		if h, err := utils.HashFile(logPath); err == nil {
+	if res.LogPath != "" {
+		if h, err := utils.HashFile(res.LogPath); err == nil {
 			logHash = h
 		}
 	}
+	return res, logHash
+}
+
+// emptyLogHash is the log hash reported for a job whose log was lost
+var emptyLogHash = utils.HashString("")
+
+// deliverLog uploads the log of a job, retrying with backoff, and checks
+// that the server hashed it as the agent did
+func deliverLog(sess *Session, jobID, logPath, logHash string) error {
+	switch {
+	case logPath == "":
+		return fmt.Errorf("log was not saved")
+	case logHash == "":
+		return fmt.Errorf("log could not be hashed")
+	}
+	return withRetry("log upload", uploadAttempts, func() error {
+		serverHash, err := uploadLog(sess, jobID, logPath)
+		if err != nil {
+			return err
+		}
+		if serverHash != logHash {
+			return fmt.Errorf("server hashed log as %s, agent as %s", serverHash, logHash)
+		}
+		return nil
+	})
+}
 
-	return fmt.Sprintf("job %s completed successfully", job.ID), true, logPath, logHash
+// uploadLog streams a job log to the server and returns the hash the
+// server computed for it
+func uploadLog(sess *Session, jobID, logPath string) (string, error) {
+	url := fmt.Sprintf("%s/jobs/%s/log", sess.ServerURL, jobID)
+	resp, err := sess.doStream(http.MethodPost, url, "application/octet-stream", func() (io.Reader, error) {
+		return os.Open(logPath) // closed by the HTTP client
+	})
+	if err != nil {
+		return "", err
+	}
+	var uploaded struct {
+		LogHash string `json:"logHash"`
+	}
+	if err := decodeResponse(resp, &uploaded); err != nil {
+		return "", err
+	}
+	return uploaded.LogHash, nil
 }
 
-func reportResult(serverURL string, job Job, agentID string, output string, success bool, logPath, logHash string) {
+// reportResult sends the signed result of a job. Network and server errors
+// are retried until the server answers; a rejection (4xx) is returned.
+// logErr is why the log could not be delivered, if it could not.
+func reportResult(sess *Session, job Job, runner *core.Runner, res core.StepResult, logHash string, logErr error) error {
+	agentID := runner.AgentID
+	output := fmt.Sprintf("job %s completed successfully", job.ID)
+	if res.Err != nil {
+		output = fmt.Sprintf("job %s failed: %v", job.ID, res.Err)
+	}
+
+	env, signature, err := runner.SignResult(job.ID, logHash, res)
+	if err != nil {
+		return fmt.Errorf("sign result: %w", err)
+	}
+
 	result := map[string]interface{}{
 		"id":         job.ID,
 		"stage":      job.Stage,
@@ -149,20 +350,32 @@ func reportResult(serverURL string, job Job, agentID string, output string, succ
 		"pipelineId": job.PipelineID,
 		"agentID":    agentID,
 		"output":     output,
-		"logPath":    logPath,
 		"logHash":    logHash,
-		"success":    success,
+		"success":    res.State == core.StateSucceeded,
+		"status":     res.State,
+		"exitCode":   res.ExitCode,
+		"startedAt":  res.StartedAt.Format(time.RFC3339Nano),
+		"finishedAt": env.Timestamp,
+		"signature":  signature,
 		"time":       time.Now().Format(time.RFC3339),
 	}
 
-	data, _ := json.Marshal(result)
-	resp, err := http.Post(serverURL+"/jobs/result", "application/json", bytes.NewBuffer(data))
-	if err != nil {
-		fmt.Println("⚠️ failed to report result:", err)
-		return
+	if logErr != nil {
+		result["logError"] = logErr.Error()
 	}
-	defer resp.Body.Close()
 
-	body, _ := io.ReadAll(resp.Body)
-	fmt.Println("📤 Reported result:", string(body))
+	data, _ := json.Marshal(result)
+	return withRetry("result report", 0, func() error {
+		resp, err := sess.do(http.MethodPost, sess.ServerURL+"/jobs/result", data)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK {
+			return &statusError{Code: resp.StatusCode, Body: string(body)}
+		}
+		fmt.Println("📤 Reported result:", string(body))
+		return nil
+	})
 }
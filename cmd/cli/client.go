@@ -0,0 +1,154 @@
+package main
+
+import (
+	"blockci-q/pkg/config"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const defaultServer = "http://localhost:8080"
+
+// client talks to the server for the pipeline lifecycle commands
+type client struct {
+	server string
+	format string // "table" or "json"
+	token  string
+	http   *http.Client
+}
+
+// clientFlags registers --server, --format and --token on fs and returns a
+// constructor to call after parsing. Each setting falls back to its
+// environment variable (BLOCKCI_SERVER, BLOCKCI_FORMAT, BLOCKCI_TOKEN), then
+// to the config file (BLOCKCI_CONFIG, default ~/.blockci.yaml).
+func clientFlags(fs *flag.FlagSet) func() *client {
+	server := fs.String("server", "", "server URL (env BLOCKCI_SERVER, default "+defaultServer+")")
+	format := fs.String("format", "", "output format: table or json (env BLOCKCI_FORMAT)")
+	token := fs.String("token", "", "api token of the server (env BLOCKCI_TOKEN)")
+	return func() *client {
+		cfg := loadCLIConfig()
+		c := &client{
+			server: strings.TrimRight(firstSet(*server, os.Getenv("BLOCKCI_SERVER"), cfg.Server, defaultServer), "/"),
+			format: firstSet(*format, os.Getenv("BLOCKCI_FORMAT"), cfg.Format, "table"),
+			token:  firstSet(*token, os.Getenv("BLOCKCI_TOKEN"), cfg.Token),
+			http:   &http.Client{Timeout: 30 * time.Second},
+		}
+		if c.format != "table" && c.format != "json" {
+			fmt.Printf("❌ Unknown format %q (want table or json)\n", c.format)
+			os.Exit(1)
+		}
+		return c
+	}
+}
+
+// loadCLIConfig reads the CLI config file; a missing default file is fine
+func loadCLIConfig() *config.CLIConfig {
+	path := os.Getenv("BLOCKCI_CONFIG")
+	explicit := path != ""
+	if !explicit {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return &config.CLIConfig{}
+		}
+		path = filepath.Join(home, ".blockci.yaml")
+	}
+	cfg, err := config.LoadCLIConfig(path)
+	if err != nil {
+		if explicit || !os.IsNotExist(err) {
+			fmt.Printf("⚠️ WARN: cannot load CLI config %s: %v\n", path, err)
+		}
+		return &config.CLIConfig{}
+	}
+	return cfg
+}
+
+func firstSet(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// parseInterspersed parses flags given before or after the positional
+// arguments and returns the positional ones
+func parseInterspersed(fs *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		fs.Parse(args)
+		if fs.NArg() == 0 {
+			return positional
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+// apiError is a non-2xx server response
+type apiError struct {
+	Status int
+	Body   string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("server returned %d: %s", e.Status, strings.TrimSpace(e.Body))
+}
+
+// do sends a request and returns the response body, or an *apiError for a
+// non-2xx status
+func (c *client) do(method, path string, body io.Reader, contentType string) ([]byte, error) {
+	req, err := http.NewRequest(method, c.server+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, &apiError{Status: resp.StatusCode, Body: string(data)}
+	}
+	return data, nil
+}
+
+// getJSON decodes the JSON response of a GET into out
+func (c *client) getJSON(path string, out interface{}) error {
+	data, err := c.do(http.MethodGet, path, nil, "")
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+// postJSON decodes the JSON response of a POST into out
+func (c *client) postJSON(path string, out interface{}) error {
+	data, err := c.do(http.MethodPost, path, nil, "")
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+// fail prints a request error and exits
+func fail(what string, err error) {
+	fmt.Printf("❌ %s: %v\n", what, err)
+	os.Exit(1)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"blockci-q/internal/blockchain"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+)
+
+// ledgerCmd inspects a ledger file offline: list a range of blocks, show
+// one block, or diff two copies of a ledger
+func ledgerCmd(args []string) {
+	if len(args) < 1 {
+		usage()
+	}
+	switch args[0] {
+	case "list":
+		ledgerList(args[1:])
+	case "show":
+		ledgerShow(args[1:])
+	case "diff":
+		ledgerDiff(args[1:])
+	default:
+		usage()
+	}
+}
+
+// ledgerList prints the blocks with from <= index <= to
+func ledgerList(args []string) {
+	fs := flag.NewFlagSet("ledger list", flag.ExitOnError)
+	from := fs.Int("from", 0, "first block index")
+	to := fs.Int("to", -1, "last block index (default: the last block)")
+	asJSON := fs.Bool("json", false, "print the blocks as JSON")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		usage()
+	}
+
+	ledger := openLedgerFile(fs.Arg(0))
+	blocks := make([]*blockchain.Block, 0)
+	for _, blk := range ledger.Blocks {
+		if blk.Index >= *from && (*to < 0 || blk.Index <= *to) {
+			blocks = append(blocks, blk)
+		}
+	}
+	if *asJSON {
+		printJSON(blocks)
+		return
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "INDEX\tTYPE\tTIMESTAMP\tRUN\tSTAGE/STEP\tAGENT\tOUTCOME\tHASH")
+	for _, blk := range blocks {
+		typ := blk.Type
+		if typ == blockchain.BlockTypeStep {
+			typ = "step"
+		}
+		step := ""
+		if blk.Stage != "" || blk.Step != "" {
+			step = blk.Stage + "/" + blk.Step
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			blk.Index, typ, blk.Timestamp, blk.RunID, step, blk.AgentID, blk.Outcome, short(blk.Hash))
+	}
+	tw.Flush()
+	fmt.Printf("📦 %d of %d blocks\n", len(blocks), len(ledger.Blocks))
+}
+
+// ledgerShow prints one block, selected by index or hash
+func ledgerShow(args []string) {
+	fs := flag.NewFlagSet("ledger show", flag.ExitOnError)
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		usage()
+	}
+
+	ledger := openLedgerFile(fs.Arg(0))
+	ref := fs.Arg(1)
+	var blk *blockchain.Block
+	var err error
+	if index, convErr := strconv.Atoi(ref); convErr == nil {
+		blk, err = ledger.Block(index)
+	} else {
+		blk, err = ledger.BlockByHash(ref)
+	}
+	if err != nil {
+		fmt.Printf("❌ Block %s: %v\n", ref, err)
+		os.Exit(1)
+	}
+	printJSON(blk)
+}
+
+// ledgerDiff compares two ledger files and reports where they diverge.
+// It exits 1 when they differ.
+func ledgerDiff(args []string) {
+	fs := flag.NewFlagSet("ledger diff", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print the diff as JSON")
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		usage()
+	}
+
+	left := openLedgerFile(fs.Arg(0))
+	right := openLedgerFile(fs.Arg(1))
+	diff := blockchain.DiffLedgers(left, right)
+	if *asJSON {
+		printJSON(diff)
+	} else {
+		printLedgerDiff(fs.Arg(0), fs.Arg(1), diff)
+	}
+	if !diff.Identical() {
+		os.Exit(1)
+	}
+}
+
+func printLedgerDiff(leftPath, rightPath string, diff *blockchain.LedgerDiff) {
+	switch {
+	case diff.Identical():
+		fmt.Printf("✅ Ledgers are identical (%d blocks)\n", diff.Common)
+	case diff.Left == nil:
+		fmt.Printf("➕ %s extends %s by %d blocks from position %d\n",
+			rightPath, leftPath, diff.RightBlocks-diff.Common, diff.Divergence)
+	case diff.Right == nil:
+		fmt.Printf("➕ %s extends %s by %d blocks from position %d\n",
+			leftPath, rightPath, diff.LeftBlocks-diff.Common, diff.Divergence)
+	default:
+		fmt.Printf("❌ Ledgers diverge at position %d after %d common blocks\n", diff.Divergence, diff.Common)
+		fmt.Printf("   %s: block %d %s\n", leftPath, diff.Left.Index, short(diff.Left.Hash))
+		fmt.Printf("   %s: block %d %s\n", rightPath, diff.Right.Index, short(diff.Right.Hash))
+		fmt.Printf("   differing fields: %v\n", diff.Fields)
+	}
+}
+
+// short abbreviates a hash for tables
+func short(hash string) string {
+	if len(hash) > 12 {
+		return hash[:12]
+	}
+	return hash
+}
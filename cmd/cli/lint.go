@@ -0,0 +1,71 @@
+package main
+
+import (
+	"blockci-q/internal/core"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// lintResult is the --json report of one pipeline file
+type lintResult struct {
+	File     string                `json:"file"`
+	Valid    bool                  `json:"valid"`
+	Problems core.ValidationErrors `json:"problems,omitempty"`
+}
+
+// lint validates pipeline files offline and prints every problem as
+// file:line:column; exits 1 when any file is invalid
+func lint(args []string) {
+	fs := flag.NewFlagSet("lint", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print the problems as JSON")
+	files := parseInterspersed(fs, args)
+	if len(files) == 0 {
+		usage()
+	}
+
+	results := make([]lintResult, 0, len(files))
+	invalid := 0
+	for _, file := range files {
+		res := lintResult{File: file}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			res.Problems = core.ValidationErrors{{Message: err.Error()}}
+		} else {
+			res.Problems = core.ValidatePipeline(data)
+		}
+		res.Valid = len(res.Problems) == 0
+		if !res.Valid {
+			invalid++
+		}
+		results = append(results, res)
+	}
+
+	if *asJSON {
+		printJSON(results)
+	} else {
+		for _, res := range results {
+			if res.Valid {
+				fmt.Printf("✅ %s is valid\n", res.File)
+				continue
+			}
+			for _, p := range res.Problems {
+				switch {
+				case p.Column > 0:
+					fmt.Printf("❌ %s:%d:%d: ", res.File, p.Line, p.Column)
+				case p.Line > 0:
+					fmt.Printf("❌ %s:%d: ", res.File, p.Line)
+				default:
+					fmt.Printf("❌ %s: ", res.File)
+				}
+				if p.Path != "" {
+					fmt.Printf("%s: ", p.Path)
+				}
+				fmt.Println(p.Message)
+			}
+		}
+	}
+	if invalid > 0 {
+		os.Exit(1)
+	}
+}
@@ -1,49 +1,65 @@
 package main
 
 import (
-	"bytes"
 	"fmt"
-	"io"
-	"net/http"
 	"os"
 )
 
 func usage() {
 	fmt.Println("Usage:")
 	fmt.Println("  cli submit <pipeline.yaml>")
+	fmt.Println("  cli lint [--json] <pipeline.yaml>...")
+	fmt.Println("  cli migrate [--check] [-o <file>|-] <pipeline.yaml>")
+	fmt.Println("  cli run [--stage <stage>]... [--step <stage>/<step>]... [--dry-run [--json]] [--ledger <file>] [--logs <dir>] [--key <file>] <pipeline.yaml>")
+	fmt.Println("  cli status <id|name#N> [--watch] [--interval <duration>]")
+	fmt.Println("  cli list")
+	fmt.Println("  cli cancel <id|name#N>")
+	fmt.Println("  cli retry <id|name#N> [--from-stage <stage>]")
+	fmt.Println("  cli logs <id|name#N> [<stage>/<step>] [--follow]")
+	fmt.Println("  cli agents")
+	fmt.Println("    server commands take --server <url>, --format table|json and --token <api token>,")
+	fmt.Println("    defaulting to BLOCKCI_SERVER, BLOCKCI_FORMAT, BLOCKCI_TOKEN, then ~/.blockci.yaml (BLOCKCI_CONFIG)")
+	fmt.Println("  cli verify [--deep] [--logs <dir>] [--base <dir>] [--config <server.yaml>] [--trust <dir>] [--pubkey <key|file>]... [--json] <ledger.json>")
+	fmt.Println("  cli ledger list [--from <index>] [--to <index>] [--json] <ledger.json>")
+	fmt.Println("  cli ledger show <ledger.json> <index|hash>")
+	fmt.Println("  cli ledger diff [--json] <ledger.json> <other.json>")
 	os.Exit(1)
 }
 
 func main() {
-	if len(os.Args) < 3 {
+	if len(os.Args) < 2 {
 		usage()
 	}
 
-	command := os.Args[1]
+	command, args := os.Args[1], os.Args[2:]
 	switch command {
 	case "submit":
-		pipelinePath := os.Args[2]
-
-		// read yaml file
-		data, err := os.ReadFile(pipelinePath)
-		if err != nil {
-			fmt.Println("❌ Failed to read pipeline file:", err)
-			os.Exit(1)
-		}
-
-		// send to server
-		resp, err := http.Post("http://localhost:8080/pipelines", "application/x-yaml", bytes.NewBuffer(data))
-		if err != nil {
-			fmt.Println("❌ Failed to send request:", err)
-			os.Exit(1)
-		}
-		defer resp.Body.Close()
-
-		body, _ := io.ReadAll(resp.Body)
-		fmt.Println("✅ Server response:", string(body))
-
+		submit(args)
+	case "lint":
+		lint(args)
+	case "migrate":
+		migrate(args)
+	case "run":
+		runLocal(args)
+	case "status":
+		status(args)
+	case "list":
+		list(args)
+	case "cancel":
+		cancel(args)
+	case "retry":
+		retry(args)
+	case "logs":
+		logs(args)
+	case "agents":
+		agents(args)
+	case "verify":
+		verifyLedger(args)
+	case "verify-logs": // older name of verify --deep
+		verifyLedger(append([]string{"--deep"}, args...))
+	case "ledger":
+		ledgerCmd(args)
 	default:
 		usage()
 	}
 }
-
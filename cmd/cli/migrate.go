@@ -0,0 +1,87 @@
+package main
+
+import (
+	"blockci-q/internal/core"
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// migrate rewrites a pipeline file in the latest schema version. --check
+// only reports whether it is outdated (exit 1), for CI.
+func migrate(args []string) {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	check := fs.Bool("check", false, "exit 1 if the file is not at the latest schema version, write nothing")
+	output := fs.String("o", "", "write the migrated pipeline to this file (- for stdout) instead of in place")
+	positional := parseInterspersed(fs, args)
+	if len(positional) != 1 {
+		usage()
+	}
+	path := positional[0]
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Println("❌ Failed to read pipeline file:", err)
+		os.Exit(1)
+	}
+	migrated, from, err := core.MigratePipeline(data)
+	if err != nil {
+		fmt.Printf("❌ %s cannot be migrated:\n%v\n", path, err)
+		os.Exit(1)
+	}
+
+	if *check {
+		if from != core.PipelineVersion {
+			fmt.Printf("❌ %s is at schema version %d, latest is %d (run blockci migrate %s)\n", path, from, core.PipelineVersion, path)
+			os.Exit(1)
+		}
+		fmt.Printf("✅ %s is at the latest schema version %d\n", path, from)
+		return
+	}
+	if *output == "-" {
+		os.Stdout.Write(migrated)
+		return
+	}
+	if *output == "" && bytes.Equal(migrated, data) {
+		fmt.Printf("✅ %s is already at schema version %d\n", path, from)
+		return
+	}
+
+	target := path
+	if *output != "" {
+		target = *output
+	}
+	if err := writeFileAtomic(target, migrated); err != nil {
+		fmt.Println("❌ Failed to write pipeline:", err)
+		os.Exit(1)
+	}
+	fmt.Printf("✅ Migrated %s from schema version %d to %d\n", target, from, core.PipelineVersion)
+}
+
+// writeFileAtomic replaces path through a temp file in the same directory,
+// so an interrupted write never leaves a truncated pipeline behind
+func writeFileAtomic(path string, data []byte) error {
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"blockci-q/internal/core"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"text/tabwriter"
+	"time"
+)
+
+// runInfo is a run as returned by submit, retry, cancel and list
+type runInfo struct {
+	ID        string     `json:"id"`
+	Run       string     `json:"run"`
+	Pipeline  string     `json:"pipeline,omitempty"`
+	RunNumber int        `json:"runNumber"`
+	Status    core.State `json:"status"`
+	Sealed    bool       `json:"sealed,omitempty"`
+	RetryOf   string     `json:"retryOf,omitempty"`
+}
+
+// runStatus is GET /pipelines/{id}/status
+type runStatus struct {
+	ID             string                `json:"id"`
+	Run            string                `json:"run"`
+	PipelineStatus core.State            `json:"pipelineStatus"`
+	Stages         map[string]core.State `json:"stages"`
+	Steps          map[string]struct {
+		Status core.State `json:"status"`
+		Agent  string     `json:"agent"`
+	} `json:"steps"`
+	StepOrder []string `json:"stepOrder"`
+}
+
+// runPath is the server path of a run ID or "name#N"
+func runPath(id string, action string) string {
+	return "/pipelines/" + url.PathEscape(id) + "/" + action
+}
+
+func submit(args []string) {
+	fs := flag.NewFlagSet("submit", flag.ExitOnError)
+	newClient := clientFlags(fs)
+	positional := parseInterspersed(fs, args)
+	if len(positional) != 1 {
+		usage()
+	}
+	c := newClient()
+
+	data, err := os.ReadFile(positional[0])
+	if err != nil {
+		fmt.Println("❌ Failed to read pipeline file:", err)
+		os.Exit(1)
+	}
+	body, err := c.do(http.MethodPost, "/pipelines", bytes.NewReader(data), "application/x-yaml")
+	if err != nil {
+		fail("Failed to submit pipeline", err)
+	}
+	var run runInfo
+	if err := json.Unmarshal(body, &run); err != nil {
+		fail("Unexpected server response", err)
+	}
+	printRun(c, "✅ Submitted", run)
+}
+
+// printRun prints the run returned by submit, retry or cancel
+func printRun(c *client, verb string, run runInfo) {
+	if c.format == "json" {
+		printJSON(run)
+		return
+	}
+	fmt.Printf("%s %s (%s): %s\n", verb, run.Run, run.ID, run.Status)
+}
+
+// status prints the step states of a run; --watch polls until it finishes
+// and exits 1 unless it succeeded
+func status(args []string) {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	newClient := clientFlags(fs)
+	watch := fs.Bool("watch", false, "poll until the run finishes")
+	interval := fs.Duration("interval", 2*time.Second, "--watch: poll interval")
+	positional := parseInterspersed(fs, args)
+	if len(positional) != 1 {
+		usage()
+	}
+	c := newClient()
+
+	var last *runStatus
+	for {
+		var st runStatus
+		if err := c.getJSON(runPath(positional[0], "status"), &st); err != nil {
+			fail("Failed to get status", err)
+		}
+		if last == nil || !reflect.DeepEqual(*last, st) {
+			printStatus(c, &st)
+			last = &st
+		}
+		if !*watch {
+			return
+		}
+		if st.PipelineStatus.Terminal() && !anyRunning(&st) {
+			if st.PipelineStatus != core.StateSucceeded {
+				os.Exit(1)
+			}
+			return
+		}
+		time.Sleep(*interval)
+	}
+}
+
+func anyRunning(st *runStatus) bool {
+	for _, step := range st.Steps {
+		if step.Status == core.StateRunning {
+			return true
+		}
+	}
+	return false
+}
+
+func printStatus(c *client, st *runStatus) {
+	if c.format == "json" {
+		json.NewEncoder(os.Stdout).Encode(st)
+		return
+	}
+	fmt.Printf("📋 %s (%s): %s\n", st.Run, st.ID, st.PipelineStatus)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "STEP\tSTATUS\tAGENT")
+	for _, key := range st.StepOrder {
+		step := st.Steps[key]
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", key, step.Status, step.Agent)
+	}
+	tw.Flush()
+}
+
+// list prints every run known to the server
+func list(args []string) {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	newClient := clientFlags(fs)
+	if len(parseInterspersed(fs, args)) != 0 {
+		usage()
+	}
+	c := newClient()
+
+	var runs []runInfo
+	if err := c.getJSON("/pipelines", &runs); err != nil {
+		fail("Failed to list runs", err)
+	}
+	if c.format == "json" {
+		printJSON(runs)
+		return
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "RUN\tID\tSTATUS\tSEALED")
+	for _, run := range runs {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%v\n", run.Run, run.ID, run.Status, run.Sealed)
+	}
+	tw.Flush()
+}
+
+func cancel(args []string) {
+	fs := flag.NewFlagSet("cancel", flag.ExitOnError)
+	newClient := clientFlags(fs)
+	positional := parseInterspersed(fs, args)
+	if len(positional) != 1 {
+		usage()
+	}
+	c := newClient()
+
+	var run runInfo
+	if err := c.postJSON(runPath(positional[0], "cancel"), &run); err != nil {
+		fail("Failed to cancel run", err)
+	}
+	printRun(c, "🛑 Cancelled", run)
+}
+
+func retry(args []string) {
+	fs := flag.NewFlagSet("retry", flag.ExitOnError)
+	newClient := clientFlags(fs)
+	fromStage := fs.String("from-stage", "", "stage to rerun from; earlier stages are taken over from the run")
+	positional := parseInterspersed(fs, args)
+	if len(positional) != 1 {
+		usage()
+	}
+	c := newClient()
+
+	path := runPath(positional[0], "retry")
+	if *fromStage != "" {
+		path += "?from_stage=" + url.QueryEscape(*fromStage)
+	}
+	var run runInfo
+	if err := c.postJSON(path, &run); err != nil {
+		fail("Failed to retry run", err)
+	}
+	printRun(c, "🔁 Retrying "+run.RetryOf+" as", run)
+}
+
+// stepLog is one step in GET /pipelines/{id}/logs
+type stepLog struct {
+	Stage   string     `json:"stage"`
+	Step    string     `json:"step"`
+	Status  core.State `json:"status"`
+	LogHash string     `json:"logHash"`
+}
+
+// logs prints the logs of a run, or of one step. With --follow it waits
+// for steps still running and prints each log once it is recorded.
+func logs(args []string) {
+	fs := flag.NewFlagSet("logs", flag.ExitOnError)
+	newClient := clientFlags(fs)
+	follow := fs.Bool("follow", false, "wait for running steps and print their logs as they finish")
+	interval := fs.Duration("interval", 2*time.Second, "--follow: poll interval")
+	positional := parseInterspersed(fs, args)
+	if len(positional) < 1 || len(positional) > 2 {
+		usage()
+	}
+	c := newClient()
+	id := positional[0]
+
+	printed := make(map[string]bool)
+	for {
+		var steps []stepLog
+		if err := c.getJSON(runPath(id, "logs"), &steps); err != nil {
+			fail("Failed to list logs", err)
+		}
+
+		done, matched := true, false
+		for _, step := range steps {
+			key := step.Stage + "/" + step.Step
+			if len(positional) == 2 && key != positional[1] {
+				continue
+			}
+			matched = true
+			if !step.Status.Terminal() {
+				done = false
+			}
+			if step.LogHash == "" || printed[key] {
+				continue
+			}
+			data, err := c.do(http.MethodGet, runPath(id, "logs/"+url.PathEscape(step.Stage)+"/"+url.PathEscape(step.Step)), nil, "")
+			var apiErr *apiError
+			if errors.As(err, &apiErr) && apiErr.Status == http.StatusNotFound {
+				done = false // recorded but not readable yet
+				continue
+			}
+			if err != nil {
+				fail("Failed to fetch log of "+key, err)
+			}
+			if c.format == "json" {
+				json.NewEncoder(os.Stdout).Encode(map[string]interface{}{
+					"stage": step.Stage, "step": step.Step, "status": step.Status, "logHash": step.LogHash, "log": string(data),
+				})
+			} else {
+				fmt.Printf("==> %s (%s) <==\n%s", key, step.Status, data)
+				if len(data) > 0 && data[len(data)-1] != '\n' {
+					fmt.Println()
+				}
+			}
+			printed[key] = true
+		}
+		if len(positional) == 2 && !matched {
+			fail("Failed to list logs", fmt.Errorf("run %s has no step %s (want <stage>/<step>)", id, positional[1]))
+		}
+		if !*follow || done {
+			return
+		}
+		time.Sleep(*interval)
+	}
+}
+
+// agentInfo is one agent in GET /agents
+type agentInfo struct {
+	ID   string `json:"id"`
+	Host string `json:"host"`
+	Busy bool   `json:"busy"`
+	Job  string `json:"job"`
+}
+
+func agents(args []string) {
+	fs := flag.NewFlagSet("agents", flag.ExitOnError)
+	newClient := clientFlags(fs)
+	if len(parseInterspersed(fs, args)) != 0 {
+		usage()
+	}
+	c := newClient()
+
+	var list []agentInfo
+	if err := c.getJSON("/agents", &list); err != nil {
+		fail("Failed to list agents", err)
+	}
+	if c.format == "json" {
+		printJSON(list)
+		return
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "AGENT\tHOST\tBUSY\tJOB")
+	for _, a := range list {
+		fmt.Fprintf(tw, "%s\t%s\t%v\t%s\n", a.ID, a.Host, a.Busy, a.Job)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"blockci-q/internal/blockchain"
+	"blockci-q/internal/core"
+	"blockci-q/internal/security"
+	"blockci-q/pkg/utils"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// runLocal executes a pipeline on this machine with the same parser,
+// scheduler and executor the agents use, and records every step in a local
+// ledger signed with a local key. --dry-run prints the plan instead.
+func runLocal(args []string) {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	var stages, steps []string
+	fs.Func("stage", "only run this stage (repeatable)", func(v string) error {
+		stages = append(stages, v)
+		return nil
+	})
+	fs.Func("step", "only run this step, as <stage>/<step> or <step> (repeatable)", func(v string) error {
+		steps = append(steps, v)
+		return nil
+	})
+	dryRun := fs.Bool("dry-run", false, "print the resolved execution plan without running anything")
+	asJSON := fs.Bool("json", false, "--dry-run: print the plan as JSON")
+	ledgerPath := fs.String("ledger", "./local-ledger.json", "local ledger file the steps are recorded in")
+	logDir := fs.String("logs", "./logs", "directory step logs are saved in")
+	keyPath := fs.String("key", "./keys/local.priv", "key signing the local ledger (generated if missing, public key next to it as .pub)")
+	positional := parseInterspersed(fs, args)
+	if len(positional) != 1 {
+		usage()
+	}
+
+	data, err := os.ReadFile(positional[0])
+	if err != nil {
+		fmt.Println("❌ Failed to read pipeline file:", err)
+		os.Exit(1)
+	}
+	pipeline, err := core.ParsePipeline(data)
+	if err != nil {
+		fmt.Println("❌ Invalid pipeline:", err)
+		os.Exit(1)
+	}
+	scheduler := core.NewScheduler()
+	pipeline, err = scheduler.Filter(pipeline, stages, steps)
+	if err != nil {
+		fmt.Println("❌ Invalid filter:", err)
+		os.Exit(1)
+	}
+	plan, err := scheduler.Plan(pipeline)
+	if err != nil {
+		fmt.Println("❌ Invalid pipeline:", err)
+		os.Exit(1)
+	}
+
+	if *dryRun {
+		if *asJSON {
+			printJSON(plan)
+		} else {
+			printPlan(pipeline, plan)
+		}
+		return
+	}
+
+	pubPath := strings.TrimSuffix(*keyPath, ".priv") + ".pub"
+	pub, priv, created, err := security.EnsureKeyPair(pubPath, *keyPath)
+	if err != nil {
+		fmt.Println("❌ Failed to load local key:", err)
+		os.Exit(1)
+	}
+	if created {
+		fmt.Println("🔑 Generated local key", *keyPath)
+	}
+	ledger, err := blockchain.OpenLedger(*ledgerPath)
+	if err != nil {
+		fmt.Println("❌ Failed to open ledger:", err)
+		os.Exit(1)
+	}
+	defer ledger.Store().Close()
+
+	runID := "local-" + uuid.New().String()
+	pipelineHash := utils.HashString(string(data))
+	fmt.Printf("🚀 Running %s locally as %s\n", runName(pipeline), runID)
+
+	runner := core.NewRunner()
+	runner.AgentID = "local"
+	runner.PrivKey = priv
+	runner.PubKey = pub
+	runner.LogStorage.BaseDir = *logDir
+
+	var mu sync.Mutex
+	var recordErr error
+	runner.OnStep = func(stage string, step core.Step, res core.StepResult) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err := recordStep(ledger, runner, runID, pipelineHash, stage, step, res); err != nil && recordErr == nil {
+			recordErr = err
+		}
+	}
+
+	_, runErr := runner.RunPipeline(pipeline)
+	outcome := core.StateSucceeded
+	if runErr != nil {
+		outcome = core.StateFailed
+	}
+	if recordErr == nil {
+		_, recordErr = ledger.AppendRunSummary(runID, pipelineHash, string(outcome), priv, pub)
+	}
+	if recordErr != nil {
+		fmt.Println("❌ Failed to record run in ledger:", recordErr)
+		os.Exit(1)
+	}
+
+	fmt.Printf("⛓️ Recorded in %s; check it with: blockci verify --pubkey %s %s\n", *ledgerPath, pubPath, *ledgerPath)
+	if runErr != nil {
+		fmt.Println("❌ Pipeline failed:", runErr)
+		os.Exit(1)
+	}
+	fmt.Println("✅ Pipeline succeeded")
+}
+
+// recordStep appends the step block of a finished step, built like the
+// server builds it from an agent result
+func recordStep(ledger *blockchain.Ledger, runner *core.Runner, runID, pipelineHash, stage string, step core.Step, res core.StepResult) error {
+	if res.LogPath == "" {
+		return fmt.Errorf("step %s/%s: log was not saved", stage, step.Name)
+	}
+	logHash, err := core.ComputeLogHash(res.LogPath)
+	if err != nil {
+		return err
+	}
+	jobID := uuid.New().String()
+	env, agentSig, err := runner.SignResult(jobID, logHash, res)
+	if err != nil {
+		return err
+	}
+
+	blk, err := blockchain.NewBlock(ledger.NextIndex(), stage, step.Name, res.LogPath, logHash, ledger.LastHash(), runner.AgentID)
+	if err != nil {
+		return err
+	}
+	blk.Outcome = string(res.State)
+	blk.RunID = runID
+	blk.JobID = jobID
+	blk.CmdHash = utils.HashString(step.Run)
+	blk.ExitCode = res.ExitCode
+	blk.StartedAt = res.StartedAt.Format(time.RFC3339Nano)
+	blk.FinishedAt = env.Timestamp
+	blk.PipelineHash = pipelineHash
+	blk.AgentSig = agentSig
+	blk.AgentPubKey = hex.EncodeToString(runner.PubKey)
+	return ledger.AppendBlocks(blk, runner.PrivKey, runner.PubKey)
+}
+
+// runName is the pipeline name, or "pipeline" when it has none
+func runName(pipeline *core.Pipeline) string {
+	if pipeline.Name == "" {
+		return "pipeline"
+	}
+	return pipeline.Name
+}
+
+// printPlan prints the stages in execution order with their steps
+func printPlan(pipeline *core.Pipeline, plan []core.PlanStage) {
+	count := 0
+	for _, stage := range plan {
+		count += len(stage.Steps)
+	}
+	fmt.Printf("📋 Plan for %s: %d stages, %d steps (dry run, nothing is executed)\n", runName(pipeline), len(plan), count)
+	for _, stage := range plan {
+		fmt.Printf("\n==> Stage: %s", stage.Name)
+		if len(stage.Needs) > 0 {
+			fmt.Printf("  (needs %s)", strings.Join(stage.Needs, ", "))
+		}
+		if stage.Parallel {
+			if stage.MaxParallel > 0 {
+				fmt.Printf("  [parallel, max %d]", stage.MaxParallel)
+			} else {
+				fmt.Printf("  [parallel]")
+			}
+		}
+		fmt.Println()
+		for _, step := range stage.Steps {
+			fmt.Printf("  - %s: %s", step.Name, step.Run)
+			if len(step.Needs) > 0 {
+				fmt.Printf("  (needs %s)", strings.Join(step.Needs, ", "))
+			}
+			fmt.Println()
+		}
+	}
+}
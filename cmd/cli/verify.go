@@ -0,0 +1,161 @@
+package main
+
+import (
+	"blockci-q/internal/blockchain"
+	"blockci-q/internal/security"
+	"blockci-q/pkg/config"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// verifyLedger verifies a ledger file offline: every block's hash, link
+// and signature, and with --deep a rehash of every log file it references
+func verifyLedger(args []string) {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	deep := fs.Bool("deep", false, "also rehash every log file the blocks reference")
+	logDir := fs.String("logs", "", "--deep: log directory to scan for orphaned files")
+	baseDir := fs.String("base", "", "--deep: directory relative log paths are resolved against (default: current dir)")
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	cfgPath := fs.String("config", "", "server config whose signing_keys pin the accepted block signers")
+	trustDir := fs.String("trust", "", "directory of *.pub block signing keys to pin")
+	var pubkeys []string
+	fs.Func("pubkey", "block signing key to pin, hex/base64 or a key file (repeatable)", func(v string) error {
+		pubkeys = append(pubkeys, v)
+		return nil
+	})
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		usage()
+	}
+
+	trust, err := loadTrust(*cfgPath, *trustDir, pubkeys)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "❌ Failed to load signing keys:", err)
+		os.Exit(1)
+	}
+	if trust == nil {
+		fmt.Fprintln(os.Stderr, "⚠️ No --config, --trust or --pubkey given: any block signer is accepted")
+	}
+
+	ledger := openLedgerFile(fs.Arg(0))
+	opts := blockchain.VerifyOptions{Trust: trust}
+
+	if !*deep {
+		report := ledger.Verify(opts)
+		if *asJSON {
+			printJSON(report)
+		} else {
+			printVerifyReport(report)
+		}
+		if !report.OK {
+			os.Exit(1)
+		}
+		return
+	}
+
+	report := ledger.DeepVerify(blockchain.DeepOptions{
+		BaseDir: *baseDir,
+		LogDir:  *logDir,
+		Verify:  opts,
+	})
+	if *asJSON {
+		printJSON(report)
+	} else {
+		printDeepReport(report)
+	}
+
+	if !report.OK {
+		os.Exit(1)
+	}
+}
+
+// openLedgerFile loads a whole ledger file read-only or exits. Audits must
+// not change what they audit, so a torn tail is reported, not repaired.
+// Errors and warnings go to stderr to keep --json output parseable.
+func openLedgerFile(path string) *blockchain.Ledger {
+	ledger, err := blockchain.OpenLedgerReadOnly(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "❌ Failed to open ledger:", err)
+		os.Exit(1)
+	}
+	if torn := ledger.Torn; torn != nil {
+		fmt.Fprintf(os.Stderr, "⚠️ %s: torn tail of %d bytes at offset %d (%s), left in place; blocks up to it are read\n",
+			path, torn.Bytes, torn.Offset, torn.Reason)
+	}
+	return ledger
+}
+
+func printJSON(v interface{}) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+func printVerifyReport(report *blockchain.VerifyReport) {
+	for _, f := range report.Faults {
+		fmt.Printf("❌ block %d (position %d): %s: %s\n", f.Index, f.Position, f.Kind, f.Message)
+	}
+	if report.OK {
+		fmt.Printf("✅ %d blocks from %d verified, ledger is valid\n", report.Blocks, report.From)
+	} else {
+		fmt.Printf("❌ ledger is invalid: %d faults, trusted up to position %d\n", len(report.Faults), report.FirstDivergence)
+	}
+}
+
+// loadTrust builds the signer trust store from a server config, a keys
+// directory and individual keys; nil when none is given
+func loadTrust(cfgPath, dir string, pubkeys []string) (*security.TrustStore, error) {
+	if cfgPath == "" && dir == "" && len(pubkeys) == 0 {
+		return nil, nil
+	}
+	cfg := &config.ServerConfig{}
+	if cfgPath != "" {
+		var err error
+		if cfg, err = config.LoadServerConfig(cfgPath); err != nil {
+			return nil, err
+		}
+	}
+	if dir != "" {
+		cfg.TrustedKeysDir = dir
+	}
+	trust, err := cfg.TrustStore()
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range pubkeys {
+		if data, err := os.ReadFile(key); err == nil {
+			key = strings.TrimSpace(string(data))
+		}
+		pub, err := security.DecodePublicKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("pubkey %q: %w", key, err)
+		}
+		trust.Add(security.TrustedKey{Name: "cli", PubKey: pub})
+	}
+	return trust, nil
+}
+
+func printDeepReport(report *blockchain.DeepReport) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "INDEX\tSTAGE/STEP\tSTATUS\tLOG")
+	for _, c := range report.Blocks {
+		fmt.Fprintf(tw, "%d\t%s/%s\t%s\t%s\n", c.Index, c.Stage, c.Step, c.Status, c.LogPath)
+	}
+	tw.Flush()
+
+	for _, o := range report.Orphans {
+		fmt.Println("⚠️ orphaned log:", o)
+	}
+	for _, f := range report.Chain.Faults {
+		fmt.Printf("❌ chain fault at position %d: %s\n", f.Position, f.Message)
+	}
+	if report.OK {
+		fmt.Printf("✅ %d blocks, all logs match\n", len(report.Blocks))
+	} else {
+		fmt.Printf("❌ %d missing, %d modified logs\n", report.Missing, report.Modified)
+	}
+}
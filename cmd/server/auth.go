@@ -0,0 +1,171 @@
+package main
+
+import (
+	"blockci-q/internal/security"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Agents register with a challenge–response handshake:
+//
+//	POST /agent/challenge {id}                          -> {nonce, expiresAt}
+//	POST /agent/register  {id, host, nonce, signature}  -> {token, expiresAt}
+//
+// The signature is the agent's Ed25519 signature over
+// security.RegistrationMessage(id, nonce), checked against the trusted key
+// from configs/server.yaml. Every later poll and result call must send
+// "Authorization: Bearer <token>". Registering again replaces the agent's
+// previous token; expired sessions are swept on every registration.
+
+const (
+	challengeTTL      = time.Minute
+	defaultSessionTTL = 15 * time.Minute
+)
+
+type challenge struct {
+	Nonce     string
+	ExpiresAt time.Time
+}
+
+type session struct {
+	AgentID   string
+	ExpiresAt time.Time
+}
+
+type registerRequest struct {
+	ID        string `json:"id"`
+	Host      string `json:"host"`
+	Nonce     string `json:"nonce"`
+	Signature string `json:"signature"`
+}
+
+// POST /agent/challenge
+func (s *Server) handleAgentChallenge(w http.ResponseWriter, r *http.Request) {
+	var req registerRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ID == "" {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if _, ok := s.trustedAgents[req.ID]; !ok {
+		http.Error(w, "agent not trusted: "+req.ID, http.StatusForbidden)
+		return
+	}
+
+	nonce, err := security.RandomToken(32)
+	if err != nil {
+		http.Error(w, "cannot create challenge", http.StatusInternalServerError)
+		return
+	}
+	c := challenge{Nonce: nonce, ExpiresAt: time.Now().Add(challengeTTL)}
+
+	s.mu.Lock()
+	s.challenges[req.ID] = c
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"nonce":     c.Nonce,
+		"expiresAt": c.ExpiresAt.UTC().Format(time.RFC3339),
+	})
+}
+
+// POST /agent/register
+func (s *Server) handleRegisterAgent(w http.ResponseWriter, r *http.Request) {
+	var req registerRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ID == "" {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	agentKey, ok := s.trustedAgents[req.ID]
+	if !ok {
+		http.Error(w, "agent not trusted: "+req.ID, http.StatusForbidden)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// challenges are single use
+	c, ok := s.challenges[req.ID]
+	delete(s.challenges, req.ID)
+	if !ok || time.Now().After(c.ExpiresAt) || c.Nonce != req.Nonce {
+		http.Error(w, "unknown or expired challenge", http.StatusUnauthorized)
+		return
+	}
+	if ok, err := security.VerifySignature(agentKey, security.RegistrationMessage(req.ID, req.Nonce), req.Signature); err != nil || !ok {
+		http.Error(w, "invalid challenge signature", http.StatusUnauthorized)
+		return
+	}
+
+	token, err := security.RandomToken(32)
+	if err != nil {
+		http.Error(w, "cannot create session", http.StatusInternalServerError)
+		return
+	}
+	sess := session{AgentID: req.ID, ExpiresAt: time.Now().Add(s.sessionTTL)}
+	s.sweepSessions(req.ID)
+	s.sessions[token] = sess
+
+	agent := Agent{ID: req.ID, Host: req.Host}
+	s.agents[agent.ID] = agent
+	s.persist(kindAgent, agent.ID, agent)
+
+	fmt.Println("🤝 Agent registered:", agent.ID)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"id":        agent.ID,
+		"host":      agent.Host,
+		"token":     token,
+		"expiresAt": sess.ExpiresAt.UTC().Format(time.RFC3339),
+	})
+}
+
+// sweepSessions drops the expired sessions and those of agentID, which a
+// new registration replaces, so there is at most one session per agent.
+// Caller must hold s.mu.
+func (s *Server) sweepSessions(agentID string) {
+	now := time.Now()
+	for token, sess := range s.sessions {
+		if sess.AgentID == agentID || now.After(sess.ExpiresAt) {
+			delete(s.sessions, token)
+		}
+	}
+}
+
+// authenticateAgent checks the bearer token of a request and that it belongs
+// to agentID. Expired sessions are dropped. Caller must hold s.mu.
+func (s *Server) authenticateAgent(r *http.Request, agentID string) error {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	sess, ok := s.sessions[token]
+	if token == "" || !ok {
+		return fmt.Errorf("missing or unknown session token")
+	}
+	if time.Now().After(sess.ExpiresAt) {
+		delete(s.sessions, token)
+		return fmt.Errorf("session expired")
+	}
+	if sess.AgentID != agentID {
+		return fmt.Errorf("session does not belong to agent %s", agentID)
+	}
+	return nil
+}
+
+// requireClient guards client endpoints with the api_token from the server
+// config: requests must send "Authorization: Bearer <api_token>". Without a
+// configured token the endpoints stay open.
+func (s *Server) requireClient(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.apiToken != "" {
+			token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(token), []byte(s.apiToken)) != 1 {
+				http.Error(w, "missing or invalid api token", http.StatusUnauthorized)
+				return
+			}
+		}
+		h(w, r)
+	}
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"blockci-q/internal/blockchain"
+	"blockci-q/internal/security"
+	"blockci-q/pkg/config"
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/term"
+)
+
+const (
+	ledgerPath     = "./ledger.json" // jsonl backend
+	ledgerDir      = "./ledger"      // segment backend
+	serverPubPath  = "./keys/server.pub"
+	serverPrivPath = "./keys/server.priv"
+)
+
+// loadServerConfig reads BLOCKCI_SERVER_CONFIG (default ./configs/server.yaml)
+func loadServerConfig() (string, *config.ServerConfig) {
+	cfgPath := os.Getenv("BLOCKCI_SERVER_CONFIG")
+	if cfgPath == "" {
+		cfgPath = "./configs/server.yaml"
+	}
+	cfg, err := config.LoadServerConfig(cfgPath)
+	if err != nil {
+		fmt.Printf("⚠️ WARN: cannot load server config %s: %v (no agents trusted)\n", cfgPath, err)
+		cfg = &config.ServerConfig{}
+	}
+	if cfg.TrustedKeysDir == "" {
+		cfg.TrustedKeysDir = "./keys/trusted"
+	}
+	return cfgPath, cfg
+}
+
+// openLedger locks the configured ledger backend and opens it from its last
+// checkpoint. It fails at once when another process holds the lock; the
+// caller releases it when done with the ledger.
+func openLedger(cfg *config.ServerConfig) (*blockchain.Ledger, *ledgerLock, error) {
+	path := cfg.LedgerPath
+	open := func() (blockchain.LedgerStore, error) { return blockchain.OpenFileStore(path) }
+	switch cfg.LedgerBackend {
+	case "", "jsonl":
+		if path == "" {
+			path = ledgerPath
+		}
+	case "segment":
+		if path == "" {
+			path = ledgerDir
+		}
+		open = func() (blockchain.LedgerStore, error) { return blockchain.OpenSegmentStore(path) }
+	default:
+		return nil, nil, fmt.Errorf("unknown ledger_backend %q (want jsonl or segment)", cfg.LedgerBackend)
+	}
+
+	// locked before opening: opening may repair a torn tail
+	lock, err := lockLedger(filepath.Clean(path))
+	if err != nil {
+		return nil, nil, err
+	}
+	store, err := open()
+	if err != nil {
+		lock.Release()
+		return nil, nil, err
+	}
+	ledger, err := blockchain.NewLedgerFromCheckpoint(store)
+	if err != nil {
+		store.Close()
+		lock.Release()
+		return nil, nil, err
+	}
+	return ledger, lock, nil
+}
+
+// passphraseEnv holds the server key passphrase; without it the server
+// prompts when stdin is a terminal
+const passphraseEnv = "BLOCKCI_KEY_PASSPHRASE"
+
+// readPassphrase returns the server key passphrase from the environment or
+// a terminal prompt (asked twice when confirm is set), or nil when neither
+// is available
+func readPassphrase(confirm bool) ([]byte, error) {
+	if pass := os.Getenv(passphraseEnv); pass != "" {
+		return []byte(pass), nil
+	}
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return nil, nil
+	}
+	fmt.Print("🔐 Server key passphrase: ")
+	pass, err := term.ReadPassword(fd)
+	fmt.Println()
+	if err != nil {
+		return nil, err
+	}
+	if confirm {
+		fmt.Print("🔐 Repeat passphrase: ")
+		again, err := term.ReadPassword(fd)
+		fmt.Println()
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(pass, again) {
+			return nil, fmt.Errorf("passphrases do not match")
+		}
+	}
+	return pass, nil
+}
+
+// ensureServerKey loads the server key pair, generating it on first start.
+// It also returns the passphrase protecting the private key (nil when the
+// key file is plaintext).
+func ensureServerKey(pubPath, privPath string) (ed25519.PublicKey, ed25519.PrivateKey, []byte, error) {
+	if _, err := os.Stat(privPath); os.IsNotExist(err) {
+		pub, priv, err := security.GenerateKeyPair()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		pass, err := readPassphrase(true)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if err := os.MkdirAll(filepath.Dir(privPath), 0700); err != nil {
+			return nil, nil, nil, err
+		}
+		if err := saveServerKey(pub, priv, pubPath, privPath, pass); err != nil {
+			return nil, nil, nil, err
+		}
+		fmt.Println("🔑 Generated new server keys")
+		return pub, priv, pass, nil
+	}
+
+	data, err := os.ReadFile(privPath)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var pass []byte
+	priv, err := security.LoadPrivateKeyFile(privPath, func() ([]byte, error) {
+		pass, err = readPassphrase(false)
+		return pass, err
+	})
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("load %s: %w", privPath, err)
+	}
+	pub := priv.Public().(ed25519.PublicKey)
+
+	// the pub file must belong to the private key
+	stored, err := security.LoadPublicKey(pubPath)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("load %s: %w", pubPath, err)
+	}
+	if !stored.Equal(pub) {
+		return nil, nil, nil, fmt.Errorf("%s does not match %s", pubPath, privPath)
+	}
+	if !security.IsEncryptedKey(data) {
+		fmt.Println("⚠️ WARN: server private key is stored in plaintext; encrypt it with --encrypt-key")
+	}
+	fmt.Println("🔑 Loaded existing server keys")
+	return pub, priv, pass, nil
+}
+
+// saveServerKey writes the key pair, encrypting the private key when a
+// passphrase is given
+func saveServerKey(pub ed25519.PublicKey, priv ed25519.PrivateKey, pubPath, privPath string, pass []byte) error {
+	if len(pass) == 0 {
+		fmt.Printf("⚠️ WARN: no passphrase (%s), writing the server key in plaintext\n", passphraseEnv)
+		return security.SaveKeyPair(pub, priv, pubPath, privPath)
+	}
+	if err := os.WriteFile(pubPath, []byte(hex.EncodeToString(pub)), 0600); err != nil {
+		return err
+	}
+	return security.SaveEncryptedPrivateKey(priv, privPath, pass)
+}
+
+// encryptServerKey migrates a plaintext server key file to an encrypted one
+func encryptServerKey() error {
+	data, err := os.ReadFile(serverPrivPath)
+	if err != nil {
+		return err
+	}
+	if security.IsEncryptedKey(data) {
+		return fmt.Errorf("%s is already encrypted", serverPrivPath)
+	}
+	priv, err := security.LoadPrivateKey(serverPrivPath)
+	if err != nil {
+		return err
+	}
+	pass, err := readPassphrase(true)
+	if err != nil {
+		return err
+	}
+	if len(pass) == 0 {
+		return fmt.Errorf("no passphrase: set %s or run in a terminal", passphraseEnv)
+	}
+
+	// write next to the old file, check it opens, then replace
+	tmp := serverPrivPath + ".new"
+	if err := security.SaveEncryptedPrivateKey(priv, tmp, pass); err != nil {
+		return err
+	}
+	if _, err := security.LoadPrivateKeyFile(tmp, func() ([]byte, error) { return pass, nil }); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, serverPrivPath); err != nil {
+		return err
+	}
+	fmt.Println("🔒 Encrypted", serverPrivPath)
+	return nil
+}
+
+// rotateServerKey replaces the server key: it appends a key_rotation block
+// signed by the current key, pins the current public key in the trusted
+// keys directory until that block so the blocks it signed keep verifying,
+// and installs the new key pair. The old private key is no longer needed
+// afterwards.
+func rotateServerKey() error {
+	if _, err := os.Stat(serverPrivPath); err != nil {
+		return fmt.Errorf("no server key to rotate: %w", err)
+	}
+	// lock first: a running server must make the rotation fail before any
+	// key file is touched
+	_, cfg := loadServerConfig()
+	ledger, lock, err := openLedger(cfg)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+	defer ledger.Store().Close()
+
+	if err := recoverRotation(cfg, ledger); err != nil {
+		return err
+	}
+	pub, priv, pass, err := ensureServerKey(serverPubPath, serverPrivPath)
+	if err != nil {
+		return err
+	}
+
+	// stage the new pair first so a failed append leaves the old key in place;
+	// it is protected by the same passphrase as the old one
+	newPub, newPriv, err := security.GenerateKeyPair()
+	if err != nil {
+		return err
+	}
+	if err := saveServerKey(newPub, newPriv, serverPubPath+".new", serverPrivPath+".new", pass); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(cfg.TrustedKeysDir, 0700); err != nil {
+		return err
+	}
+
+	blk, err := ledger.RotateKey(priv, pub, newPub)
+	if err != nil {
+		os.Remove(serverPubPath + ".new")
+		os.Remove(serverPrivPath + ".new")
+		return err
+	}
+	// from here on the ledger names the new key; a crash before the key
+	// files are in place is finished by recoverRotation on the next start
+	pinned, err := finishRotation(cfg, blk)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("🔄 Rotated server key at block %d: %s -> %s\n", blk.Index, blk.PubKey, blk.NewPubKey)
+	fmt.Println("📌 Old key pinned in", pinned, "until", blk.Timestamp)
+	return nil
+}
+
+// finishRotation pins the key the rotation block retired, valid until the
+// block, and moves the staged key pair into place. The private key goes
+// first: a leftover .new public key marks a rotation still to finish.
+func finishRotation(cfg *config.ServerConfig, blk *blockchain.Block) (string, error) {
+	pinned := filepath.Join(cfg.TrustedKeysDir, "server-"+blk.PubKey[:16]+".pub")
+	pin := fmt.Sprintf("%s\nnot_after: %s\n", blk.PubKey, blk.Timestamp)
+	if err := os.WriteFile(pinned+".tmp", []byte(pin), 0600); err != nil {
+		return "", err
+	}
+	if err := os.Rename(pinned+".tmp", pinned); err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(serverPrivPath + ".new"); err == nil {
+		if err := os.Rename(serverPrivPath+".new", serverPrivPath); err != nil {
+			return "", err
+		}
+	}
+	if err := os.Rename(serverPubPath+".new", serverPubPath); err != nil {
+		return "", err
+	}
+	return pinned, nil
+}
+
+// recoverRotation deals with the key pair a crashed rotation left staged.
+// When the ledger already names it, the rotation block is on disk and the
+// rotation is finished; otherwise the block never made it and the staged
+// pair is dropped.
+func recoverRotation(cfg *config.ServerConfig, ledger *blockchain.Ledger) error {
+	staged := serverPubPath + ".new"
+	newPub, err := security.LoadPublicKey(staged)
+	if os.IsNotExist(err) {
+		// a staged private key without its public key is a half-written stage
+		if err := os.Remove(serverPrivPath + ".new"); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	signer := ledger.RotatedSigner()
+	if err != nil || signer != hex.EncodeToString(newPub) {
+		fmt.Println("🧹 Dropping the key pair staged by an unfinished rotation")
+		os.Remove(serverPrivPath + ".new")
+		return os.Remove(staged)
+	}
+
+	// the rotation block is the last one, or followed by its checkpoint
+	for index := ledger.NextIndex() - 1; index >= 0; index-- {
+		blk, err := ledger.Block(index)
+		if err != nil {
+			return err
+		}
+		if blk.Type == blockchain.BlockTypeKeyRotation && blk.NewPubKey == signer {
+			if err := os.MkdirAll(cfg.TrustedKeysDir, 0700); err != nil {
+				return err
+			}
+			pinned, err := finishRotation(cfg, blk)
+			if err != nil {
+				return fmt.Errorf("finish key rotation at block %d: %w", blk.Index, err)
+			}
+			fmt.Printf("🩹 Finished the key rotation at block %d, old key pinned in %s\n", blk.Index, pinned)
+			return nil
+		}
+		if blk.Type != blockchain.BlockTypeCheckpoint {
+			break
+		}
+	}
+	return fmt.Errorf("%s is named by the ledger but its key_rotation block was not found", staged)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// errLedgerLocked is returned when another process holds the ledger lock
+var errLedgerLocked = errors.New("ledger is locked by another process")
+
+// ledgerLock is an exclusive lock on a ledger, held while it is open so a
+// second server or a key rotation cannot append to it at the same time.
+// It is taken on a <ledger>.lock file next to the ledger file or segment
+// directory, which holds the PID of the owner.
+type ledgerLock struct {
+	f    *os.File
+	path string
+}
+
+// lockLedger takes the lock of the ledger at path or fails at once
+func lockLedger(path string) (*ledgerLock, error) {
+	lockPath := path + ".lock"
+	f, err := acquireLock(lockPath)
+	if errors.Is(err, errLedgerLocked) {
+		owner := "another process"
+		if data, _ := os.ReadFile(lockPath); len(data) > 0 {
+			owner = "process " + strings.TrimSpace(string(data))
+		}
+		return nil, fmt.Errorf("%s is in use by %s (is a server running?): %w", path, owner, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("lock %s: %w", lockPath, err)
+	}
+	f.Truncate(0)
+	f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	return &ledgerLock{f: f, path: lockPath}, nil
+}
+
+// Release gives the lock up
+func (l *ledgerLock) Release() error {
+	return releaseLock(l.f, l.path)
+}
@@ -0,0 +1,23 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// acquireLock creates path exclusively. Without flock a crash leaves the
+// file behind; it has to be removed by hand once no server is running.
+func acquireLock(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_RDWR, 0644)
+	if errors.Is(err, os.ErrExist) {
+		return nil, errLedgerLocked
+	}
+	return f, err
+}
+
+func releaseLock(f *os.File, path string) error {
+	f.Close()
+	return os.Remove(path)
+}
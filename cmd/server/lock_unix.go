@@ -0,0 +1,30 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// acquireLock takes a non-blocking flock on path. The kernel drops it when
+// the process exits, so a crash never leaves the ledger locked.
+func acquireLock(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, errLedgerLocked
+		}
+		return nil, err
+	}
+	return f, nil
+}
+
+func releaseLock(f *os.File, _ string) error {
+	return f.Close()
+}
@@ -4,8 +4,14 @@ import (
 	"blockci-q/internal/blockchain"
 	"blockci-q/internal/core"
 	"blockci-q/internal/security"
+	"blockci-q/internal/storage"
+	"blockci-q/pkg/config"
+	"blockci-q/pkg/utils"
 	"crypto/ed25519"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"net/http"
@@ -13,6 +19,8 @@ import (
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/google/uuid"
 )
 
 type Agent struct {
@@ -28,78 +36,208 @@ type Job struct {
 	PipelineID string `json:"pipelineId"`
 }
 
+// matchResult checks that a job result names this job's run, stage, step
+// and command
+func (j Job) matchResult(result map[string]interface{}) error {
+	for field, want := range map[string]string{
+		"pipelineId": j.PipelineID,
+		"stage":      j.Stage,
+		"step":       j.Step,
+		"cmd":        j.Cmd,
+	} {
+		if got, _ := result[field].(string); got != want {
+			return fmt.Errorf("result %s %q does not match job %s (%q)", field, got, j.ID, want)
+		}
+	}
+	return nil
+}
+
 type StepStatus struct {
-	Status string `json:"status"`
-	Agent  string `json:"agent"`
+	Status core.State `json:"status"`
+	Agent  string     `json:"agent"`
 }
 
 type Server struct {
 	mu             sync.Mutex
 	ledger         *blockchain.Ledger
+	ledgerLock     *ledgerLock // held for the life of the server
+	store          storage.StateStore
+	runNumbers     map[string]int             // pipeline name -> last run number handed out
+	runNumber      map[string]int             // run ID -> run number
+	runIndex       map[string]string          // "name#N" -> run ID
+	pipelineHash   map[string]string          // run ID -> SHA-256 of the submitted definition
+	sealed         map[string]bool            // runs with a run_summary block
+	carried        map[string]map[string]bool // run ID -> stages taken over from the retried run
 	pipelines      map[string]*core.Pipeline
 	status         map[string]map[string]StepStatus // pipelineID -> stepKey -> StepStatus
-	pipelineGlobal map[string]string                // pipelineID -> overall status
+	pipelineGlobal map[string]core.State            // pipelineID -> overall status
+	progress       map[string]*core.Progress        // pipelineID -> stage/step dependency tracking
+	jobSeq         map[string]int                   // pipelineID -> jobs released so far
 	agents         map[string]Agent
 	agentBusy      map[string]bool
 	assignedJobs   map[string]string // jobID -> agentID
+	runningJobs    map[string]Job    // jobID -> job as handed to its agent
+	uploadedLogs   map[string]string // jobID -> SHA-256 of the log the server received
+	logs           *storage.ContentStore
 	jobs           []Job
 	roundRobinIdx  int
 
-	privKey ed25519.PrivateKey
-	pubKey  ed25519.PublicKey
+	privKey       ed25519.PrivateKey
+	pubKey        ed25519.PublicKey
+	signers       *security.TrustStore         // server keys accepted on ledger blocks
+	trustedAgents map[string]ed25519.PublicKey // agentID -> key its results must be signed with
+	challenges    map[string]challenge         // agentID -> pending registration challenge
+	sessions      map[string]session           // token -> agent session
+	sessionTTL    time.Duration
+	apiToken      string // bearer token clients must send, "" = open
 }
 
 //========================= INIT ===============================//
 
 func NewServer() *Server {
-	ledger, err := blockchain.OpenLedger("./ledger.json")
+	cfgPath, cfg := loadServerConfig()
+	ledger, lock, err := openLedger(cfg)
 	if err != nil {
-		fmt.Printf("⚠️ WARN: cannot open ledger: %v\n", err)
+		panic(fmt.Sprintf("❌ cannot open ledger: %v", err))
+	}
+	if rec := ledger.Recovered; rec != nil {
+		fmt.Printf("🩹 Recovered ledger: torn tail of %d bytes at offset %d (%s) moved to %s\n",
+			rec.Bytes, rec.Offset, rec.Reason, rec.Quarantine)
 	}
 
-	pub, priv, err := ensureServerKey("./keys/server.pub", "./keys/server.priv")
+	if err := recoverRotation(cfg, ledger); err != nil {
+		panic(fmt.Sprintf("❌ cannot recover the interrupted key rotation: %v", err))
+	}
+	pub, priv, _, err := ensureServerKey(serverPubPath, serverPrivPath)
 	if err != nil {
 		panic(fmt.Sprintf("❌ failed to init server keys: %v", err))
 	}
+	if signer := ledger.RotatedSigner(); signer != "" && signer != hex.EncodeToString(pub) {
+		panic(fmt.Sprintf("❌ ledger key was rotated to %s but the server key is %s", signer, hex.EncodeToString(pub)))
+	}
+
+	ledger.CheckpointEvery = blockchain.DefaultCheckpointEvery
+	if cfg.CheckpointEvery != 0 {
+		ledger.CheckpointEvery = cfg.CheckpointEvery
+	}
+	ledger.OnCheckpointError = func(index int, err error) {
+		fmt.Printf("⚠️ checkpoint at block %d failed, retrying after the next block: %v\n", index, err)
+	}
+	if ledger.Partial() {
+		fmt.Printf("📍 Ledger opened from checkpoint at block %d\n", ledger.Blocks[0].Index)
+	}
+	trusted, err := loadTrustedAgents(cfg)
+	if err != nil {
+		panic(fmt.Sprintf("❌ invalid trusted agents in %s: %v", cfgPath, err))
+	}
+	signers, err := loadSigners(cfg, pub)
+	if err != nil {
+		panic(fmt.Sprintf("❌ invalid signing keys in %s: %v", cfgPath, err))
+	}
+	sessionTTL := defaultSessionTTL
+	if cfg.SessionTTL != "" {
+		if sessionTTL, err = time.ParseDuration(cfg.SessionTTL); err != nil {
+			panic(fmt.Sprintf("❌ invalid session_ttl in %s: %v", cfgPath, err))
+		}
+	}
+
+	logDir := os.Getenv("BLOCKCI_LOG_DIR")
+	if logDir == "" {
+		logDir = "./logstore"
+	}
 
-	return &Server{
+	stateDir := os.Getenv("BLOCKCI_STATE_DIR")
+	if stateDir == "" {
+		stateDir = "./state"
+	}
+	store, err := storage.OpenFileStateStore(stateDir)
+	if err != nil {
+		panic(fmt.Sprintf("❌ failed to open state store: %v", err))
+	}
+
+	s := &Server{
 		ledger:         ledger,
+		ledgerLock:     lock,
+		store:          store,
+		runNumbers:     make(map[string]int),
+		runNumber:      make(map[string]int),
+		runIndex:       make(map[string]string),
+		pipelineHash:   make(map[string]string),
+		sealed:         make(map[string]bool),
+		carried:        make(map[string]map[string]bool),
 		pipelines:      make(map[string]*core.Pipeline),
 		status:         make(map[string]map[string]StepStatus),
-		pipelineGlobal: make(map[string]string),
+		pipelineGlobal: make(map[string]core.State),
+		progress:       make(map[string]*core.Progress),
+		jobSeq:         make(map[string]int),
 		agents:         make(map[string]Agent),
 		agentBusy:      make(map[string]bool),
 		assignedJobs:   make(map[string]string),
+		runningJobs:    make(map[string]Job),
+		uploadedLogs:   make(map[string]string),
+		logs:           storage.NewContentStore(logDir),
 		jobs:           make([]Job, 0),
 		roundRobinIdx:  0,
 		privKey:        priv,
 		pubKey:         pub,
+		signers:        signers,
+		trustedAgents:  trusted,
+		challenges:     make(map[string]challenge),
+		sessions:       make(map[string]session),
+		sessionTTL:     sessionTTL,
+		apiToken:       cfg.APIToken,
+	}
+	if err := s.restoreState(); err != nil {
+		panic(fmt.Sprintf("❌ failed to restore server state: %v", err))
 	}
+	return s
 }
 
-func ensureServerKey(pubPath, privPath string) (ed25519.PublicKey, ed25519.PrivateKey, error) {
-	if _, err := os.Stat(pubPath); os.IsNotExist(err) {
-		pub, priv, err := security.GenerateKeyPair()
+// loadTrustedAgents decodes the agent keys listed in the server config
+func loadTrustedAgents(cfg *config.ServerConfig) (map[string]ed25519.PublicKey, error) {
+	trusted := make(map[string]ed25519.PublicKey, len(cfg.Agents))
+	for id, entry := range cfg.Agents {
+		pub, err := security.DecodePublicKey(entry.PubKey)
 		if err != nil {
-			return nil, nil, err
-		}
-		if err := os.MkdirAll("./keys", 0700); err != nil {
-			return nil, nil, err
-		}
-		if err := security.SaveKeyPair(pub, priv, pubPath, privPath); err != nil {
-			return nil, nil, err
+			return nil, fmt.Errorf("agent %s: %w", id, err)
 		}
-		fmt.Println("🔑 Generated new server keys")
-		return pub, priv, nil
+		trusted[id] = pub
 	}
-	pub, _ := security.LoadPublicKey(pubPath)
-	priv, _ := security.LoadPrivateKey(privPath)
-	fmt.Println("🔑 Loaded existing server keys")
-	return pub, priv, nil
+	fmt.Printf("🔐 Trusting %d agent key(s)\n", len(trusted))
+	return trusted, nil
+}
+
+// loadSigners builds the ledger signer trust store. The server's own key is
+// pinned without a window unless the config already pins it.
+func loadSigners(cfg *config.ServerConfig, pub ed25519.PublicKey) (*security.TrustStore, error) {
+	signers, err := cfg.TrustStore()
+	if err != nil {
+		return nil, err
+	}
+	own := hex.EncodeToString(pub)
+	if !signers.Contains(own) {
+		signers.Add(security.TrustedKey{Name: "server", PubKey: pub})
+	} else if err := signers.Check(own, time.Now()); err != nil {
+		fmt.Println("⚠️ WARN: server signing key:", err)
+	}
+	fmt.Printf("🔐 Trusting %d ledger signing key(s)\n", signers.Len())
+	return signers, nil
 }
 
 //========================= PIPELINE ===============================//
 
+// /pipelines: POST submits a new pipeline YAML, GET lists the runs
+func (s *Server) handlePipelines(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.handleListRuns(w, r)
+	case http.MethodPost:
+		s.handleSubmitPipeline(w, r)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
 // POST /pipelines -> submit a new pipeline YAML
 func (s *Server) handleSubmitPipeline(w http.ResponseWriter, r *http.Request) {
 	data, err := io.ReadAll(r.Body)
@@ -109,90 +247,382 @@ func (s *Server) handleSubmitPipeline(w http.ResponseWriter, r *http.Request) {
 	}
 
 	pipeline, err := core.ParsePipeline(data)
+	var problems core.ValidationErrors
+	if errors.As(err, &problems) {
+		// one problem per line, each with its line and column
+		http.Error(w, fmt.Sprintf("invalid pipeline: %d problem(s)\n%s", len(problems), problems), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
-		http.Error(w, "invalid pipeline", http.StatusBadRequest)
+		http.Error(w, "invalid pipeline: "+err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	id := fmt.Sprintf("p-%d", len(s.pipelines)+1)
+	progress, err := core.NewScheduler().Track(pipeline)
+	if err != nil {
+		http.Error(w, "invalid pipeline: "+err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	s.mu.Lock()
+	id := s.startRun(pipeline, progress, utils.HashString(string(data)), nil)
+	resp := s.runResponse(id)
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// startRun registers a new run of pipeline and releases its first jobs.
+// Steps of the carried stages are not run again: they count as done and
+// are recorded as skipped. Run ID and run number are allocated together
+// so concurrent submissions never share either. Caller must hold s.mu.
+func (s *Server) startRun(pipeline *core.Pipeline, progress *core.Progress, hash string, carried map[string]bool) string {
+	id := "run-" + uuid.New().String()
+	s.runNumbers[pipelineName(pipeline)]++
+	number := s.runNumbers[pipelineName(pipeline)]
+	s.runNumber[id] = number
+	s.runIndex[runName(pipeline, number)] = id
+	s.pipelineHash[id] = hash
 	s.pipelines[id] = pipeline
 	s.status[id] = make(map[string]StepStatus)
-	s.pipelineGlobal[id] = "pending"
+	s.pipelineGlobal[id] = core.StatePending
+	s.progress[id] = progress
+	if len(carried) > 0 {
+		s.carried[id] = carried
+	}
 
-	jobCount := 0
 	for _, stage := range pipeline.Stages {
 		for _, step := range stage.Steps {
-			jobID := fmt.Sprintf("%s-job-%d", id, jobCount+1)
-			job := Job{
-				ID:         jobID,
-				Stage:      stage.Name,
-				Step:       step.Name,
-				Cmd:        step.Run,
-				PipelineID: id,
-			}
-			s.jobs = append(s.jobs, job)
-			stepKey := fmt.Sprintf("%s:%s", stage.Name, step.Name)
-			s.status[id][stepKey] = StepStatus{Status: "pending", Agent: ""}
-			jobCount++
+			ref := core.StepRef{Stage: stage.Name, Step: step.Name}
+			s.status[id][ref.Key()] = StepStatus{Status: core.StatePending, Agent: ""}
 		}
 	}
-	s.mu.Unlock()
+	// carried stages count as done for scheduling but did not run here
+	for _, ref := range progress.Carry(carried) {
+		s.setStepState(id, ref.Key(), core.StateSkipped, "")
+	}
+	s.releaseJobs(id)
+	if progress.Done() {
+		// nothing to run
+		s.setPipelineState(id, core.StateSucceeded)
+		s.sealRun(id)
+	} else {
+		s.setPipelineState(id, core.StateQueued)
+	}
+	s.persistCounters()
+	s.persistPipeline(id)
+	s.persistQueue()
+	return id
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
-		"id":     id,
-		"status": "pending",
+// runResponse describes a run in submit, retry and cancel responses.
+// Caller must hold s.mu.
+func (s *Server) runResponse(id string) map[string]interface{} {
+	return map[string]interface{}{
+		"id":        id,
+		"run":       runName(s.pipelines[id], s.runNumber[id]),
+		"runNumber": s.runNumber[id],
+		"status":    string(s.pipelineGlobal[id]),
+	}
+}
+
+// pipelineName is the name runs are numbered under
+func pipelineName(pipeline *core.Pipeline) string {
+	if pipeline.Name == "" {
+		return "pipeline"
+	}
+	return pipeline.Name
+}
+
+// runName formats a run as "name#N"
+func runName(pipeline *core.Pipeline, number int) string {
+	return fmt.Sprintf("%s#%d", pipelineName(pipeline), number)
+}
+
+// releaseJobs enqueues the steps of a pipeline whose stage and step
+// dependencies are satisfied. Caller must hold s.mu.
+func (s *Server) releaseJobs(pipelineID string) {
+	progress, ok := s.progress[pipelineID]
+	if !ok {
+		return
+	}
+	pipeline := s.pipelines[pipelineID]
+
+	for _, ref := range progress.Next() {
+		step, ok := findStep(pipeline, ref)
+		if !ok {
+			continue
+		}
+		s.jobSeq[pipelineID]++
+		job := Job{
+			ID:         fmt.Sprintf("%s-job-%d", pipelineID, s.jobSeq[pipelineID]),
+			Stage:      ref.Stage,
+			Step:       ref.Step,
+			Cmd:        step.Run,
+			PipelineID: pipelineID,
+		}
+		s.jobs = append(s.jobs, job)
+		if err := s.setStepState(pipelineID, ref.Key(), core.StateQueued, ""); err != nil {
+			fmt.Printf("⚠️ pipeline %s: %v\n", pipelineID, err)
+		}
+	}
+}
+
+// setStepState moves a step to a new state, enforcing the step state machine.
+// An empty agent keeps the agent already recorded. Caller must hold s.mu.
+func (s *Server) setStepState(pipelineID, stepKey string, to core.State, agent string) error {
+	steps, ok := s.status[pipelineID]
+	if !ok {
+		return fmt.Errorf("pipeline %s not found", pipelineID)
+	}
+	cur, ok := steps[stepKey]
+	if !ok {
+		return fmt.Errorf("step %s not found", stepKey)
+	}
+	if err := core.StepStates.Transition(cur.Status, to); err != nil {
+		return fmt.Errorf("step %s: %w", stepKey, err)
+	}
+	if agent == "" {
+		agent = cur.Agent
+	}
+	steps[stepKey] = StepStatus{Status: to, Agent: agent}
+	return nil
+}
+
+// setPipelineState moves a pipeline to a new state, enforcing the pipeline
+// state machine. Invalid transitions are logged and ignored. Caller must hold s.mu.
+func (s *Server) setPipelineState(pipelineID string, to core.State) {
+	from := s.pipelineGlobal[pipelineID]
+	if from == to {
+		return
+	}
+	if err := core.PipelineStates.Transition(from, to); err != nil {
+		fmt.Printf("⚠️ pipeline %s: %v\n", pipelineID, err)
+		return
+	}
+	s.pipelineGlobal[pipelineID] = to
+}
+
+// failPipeline moves a pipeline to a failed terminal state (failed or
+// timed_out), drops its queued jobs as cancelled and skips every step still
+// waiting on dependencies. Steps already running on other agents still report
+// back but no further jobs are released. Caller must hold s.mu.
+func (s *Server) failPipeline(pipelineID string, state core.State) {
+	s.stopRun(pipelineID, state, core.StateSkipped)
+	fmt.Printf("🛑 Pipeline %s failed, remaining jobs cancelled\n", pipelineID)
+}
+
+// stopRun moves a run to a terminal state, drops its queued jobs as
+// cancelled and moves the steps waiting on dependencies to pendingTo.
+// Caller must hold s.mu.
+func (s *Server) stopRun(pipelineID string, state, pendingTo core.State) {
+	s.setPipelineState(pipelineID, state)
+	delete(s.progress, pipelineID)
+
+	remaining := s.jobs[:0]
+	for _, job := range s.jobs {
+		if job.PipelineID != pipelineID {
+			remaining = append(remaining, job)
+		}
+	}
+	s.jobs = remaining
+
+	for key, st := range s.status[pipelineID] {
+		s.status[pipelineID][key] = StepStatus{Status: core.StoppedState(st.Status, pendingTo), Agent: st.Agent}
+	}
+}
+
+// sealRun appends the run_summary block with the Merkle root over the run's
+// logs once the run is terminal and no step is still running. Caller must
+// hold s.mu.
+func (s *Server) sealRun(pipelineID string) {
+	state := s.pipelineGlobal[pipelineID]
+	if !core.Settled(state, s.stepStates(pipelineID)) || s.sealed[pipelineID] {
+		return
+	}
+	// runs persisted before the sealed flag existed may be sealed already
+	if summary, err := s.ledger.RunSummary(pipelineID); err != nil || summary != nil {
+		s.sealed[pipelineID] = summary != nil
+		return
+	}
+	blk, err := s.ledger.AppendRunSummary(pipelineID, s.pipelineHash[pipelineID], string(state), s.privKey, s.pubKey)
+	if err != nil {
+		fmt.Printf("⚠️ failed to seal run %s: %v\n", pipelineID, err)
+		return
+	}
+	s.sealed[pipelineID] = true
+	s.persistPipeline(pipelineID)
+	fmt.Printf("🌳 Sealed run %s at block %d (merkle root %s over %d logs)\n", pipelineID, blk.Index, blk.MerkleRoot, blk.LeafCount)
+}
+
+// stageStatus summarises the step states of every stage of a pipeline.
+// Caller must hold s.mu.
+func (s *Server) stageStatus(pipelineID string) map[string]core.State {
+	pipeline, ok := s.pipelines[pipelineID]
+	if !ok {
+		return make(map[string]core.State)
+	}
+	return core.StageStates(pipeline, func(ref core.StepRef) core.State {
+		return s.status[pipelineID][ref.Key()].Status
 	})
 }
 
-// GET /pipelines/{id}/status
-func (s *Server) handleGetPipelineStatus(w http.ResponseWriter, r *http.Request) {
-	id := strings.TrimPrefix(r.URL.Path, "/pipelines/")
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// stepStates returns the state of every step of a pipeline by step key.
+// Caller must hold s.mu.
+func (s *Server) stepStates(pipelineID string) map[string]core.State {
+	states := make(map[string]core.State, len(s.status[pipelineID]))
+	for key, st := range s.status[pipelineID] {
+		states[key] = st.Status
+	}
+	return states
+}
+
+// findStep looks up the step definition a StepRef points to
+func findStep(pipeline *core.Pipeline, ref core.StepRef) (core.Step, bool) {
+	for _, stage := range pipeline.Stages {
+		if stage.Name != ref.Stage {
+			continue
+		}
+		for _, step := range stage.Steps {
+			if step.Name == ref.Step {
+				return step, true
+			}
+		}
+	}
+	return core.Step{}, false
+}
+
+// /pipelines/{id}[/{action}], where id is a run ID or "name#N" (URL-escaped):
+//
+//	GET  /pipelines/{id}/status            -> step and stage states
+//	POST /pipelines/{id}/cancel            -> cancel the run
+//	POST /pipelines/{id}/retry             -> start a new run of the same definition
+//	GET  /pipelines/{id}/logs[/stage/step] -> step logs, see handleRunLogs
+func (s *Server) handlePipeline(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, "/pipelines/")
+	id, action, _ := strings.Cut(rest, "/")
 
-	status, ok := s.status[id]
+	s.mu.Lock()
+	id, ok := s.resolveRun(id)
+	s.mu.Unlock()
 	if !ok {
 		http.Error(w, "pipeline not found", http.StatusNotFound)
 		return
 	}
 
+	switch {
+	case action == "" || action == "status":
+		s.handleGetPipelineStatus(w, id)
+	case action == "cancel" && r.Method == http.MethodPost:
+		s.handleCancelRun(w, id)
+	case action == "retry" && r.Method == http.MethodPost:
+		s.handleRetryRun(w, r, id)
+	case action == "logs" || strings.HasPrefix(action, "logs/"):
+		s.handleRunLogs(w, id, strings.TrimPrefix(strings.TrimPrefix(action, "logs"), "/"))
+	default:
+		http.Error(w, "invalid request path", http.StatusNotFound)
+	}
+}
+
+// resolveRun maps a run ID or "name#N" to a known run ID. Caller must hold s.mu.
+func (s *Server) resolveRun(id string) (string, bool) {
+	if runID, ok := s.runIndex[id]; ok {
+		id = runID
+	}
+	_, ok := s.status[id]
+	return id, ok
+}
+
+// GET /pipelines/{id}/status
+func (s *Server) handleGetPipelineStatus(w http.ResponseWriter, id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var order []string
+	for _, stage := range s.pipelines[id].Stages {
+		for _, step := range stage.Steps {
+			order = append(order, core.StepRef{Stage: stage.Name, Step: step.Name}.Key())
+		}
+	}
 	resp := map[string]interface{}{
+		"id":             id,
+		"run":            runName(s.pipelines[id], s.runNumber[id]),
 		"pipelineStatus": s.pipelineGlobal[id],
-		"steps":          status,
+		"stages":         s.stageStatus(id),
+		"steps":          s.status[id],
+		"stepOrder":      order,
 	}
+	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
 }
 
 //========================= LEDGER ===============================//
 
+// GET /ledger/verify -> VerifyReport listing every fault, with an overall verdict.
+// Verification is incremental from the last checkpoint; ?full=1 audits the
+// whole ledger from block 0.
 func (s *Server) handleVerifyLedger(w http.ResponseWriter, r *http.Request) {
-	if err := s.ledger.VerifyChain(); err != nil {
-		http.Error(w, "ledger verification failed: "+err.Error(), http.StatusInternalServerError)
-		return
+	opts := s.verifyOptions()
+	var report *blockchain.VerifyReport
+	if r.URL.Query().Get("full") != "" {
+		var err error
+		if report, err = s.ledger.Audit(opts); err != nil {
+			http.Error(w, "cannot audit ledger: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	} else {
+		opts.Incremental = true
+		report = s.ledger.Verify(opts)
 	}
-	w.Write([]byte("✅ ledger verification OK"))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
 }
 
-//========================= AGENT ===============================//
-
-func (s *Server) handleRegisterAgent(w http.ResponseWriter, r *http.Request) {
-	var agent Agent
-	if err := json.NewDecoder(r.Body).Decode(&agent); err != nil {
-		http.Error(w, "bad request", http.StatusBadRequest)
+// GET /ledger/proof?run=<id|name#N>&log=<hash> -> Merkle inclusion proof
+// of one log in a sealed run
+func (s *Server) handleLogProof(w http.ResponseWriter, r *http.Request) {
+	run := r.URL.Query().Get("run")
+	logHash := r.URL.Query().Get("log")
+	if run == "" || logHash == "" {
+		http.Error(w, "run and log are required", http.StatusBadRequest)
 		return
 	}
 	s.mu.Lock()
-	s.agents[agent.ID] = agent
+	if runID, ok := s.runIndex[run]; ok {
+		run = runID
+	}
 	s.mu.Unlock()
 
-	fmt.Println("🤝 Agent registered:", agent.ID)
+	proof, err := s.ledger.ProveLog(run, logHash)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(agent)
+	json.NewEncoder(w).Encode(proof)
 }
 
+// verifyOptions pins ledger verification to the signer trust store
+func (s *Server) verifyOptions() blockchain.VerifyOptions {
+	return blockchain.VerifyOptions{Trust: s.signers}
+}
+
+// GET /ledger/verify/deep -> chain verification plus rehash of every referenced log
+func (s *Server) handleDeepVerifyLedger(w http.ResponseWriter, r *http.Request) {
+	// deep verification covers every block, not just those after the last checkpoint
+	ledger, err := s.ledger.Full()
+	if err != nil {
+		http.Error(w, "cannot read ledger: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	report := ledger.DeepVerify(blockchain.DeepOptions{LogDir: s.logs.BaseDir, Verify: s.verifyOptions()})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+//========================= AGENT ===============================//
+
 // GET /agents/{id}/jobs/next
 func (s *Server) handleNextJob(w http.ResponseWriter, r *http.Request) {
 	parts := strings.Split(r.URL.Path, "/")
@@ -205,6 +635,10 @@ func (s *Server) handleNextJob(w http.ResponseWriter, r *http.Request) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	if err := s.authenticateAgent(r, agentID); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
 	if _, ok := s.agents[agentID]; !ok {
 		http.Error(w, "agent not registered", http.StatusNotFound)
 		return
@@ -236,13 +670,17 @@ func (s *Server) handleNextJob(w http.ResponseWriter, r *http.Request) {
 	s.jobs = s.jobs[1:]
 
 	s.assignedJobs[job.ID] = agentID
+	s.runningJobs[job.ID] = job
 	s.agentBusy[agentID] = true
 
 	stepKey := fmt.Sprintf("%s:%s", job.Stage, job.Step)
-	if _, ok := s.status[job.PipelineID]; ok {
-		s.status[job.PipelineID][stepKey] = StepStatus{Status: "running", Agent: agentID}
-		s.pipelineGlobal[job.PipelineID] = "running"
+	if err := s.setStepState(job.PipelineID, stepKey, core.StateRunning, agentID); err != nil {
+		fmt.Printf("⚠️ pipeline %s: %v\n", job.PipelineID, err)
 	}
+	s.setPipelineState(job.PipelineID, core.StateRunning)
+	s.persistPipeline(job.PipelineID)
+	s.persistQueue()
+	s.persistAssigned(job.ID)
 
 	fmt.Printf("📤 RoundRobin → Job %s (pipeline %s) → agent %s\n", job.ID, job.PipelineID, agentID)
 
@@ -254,6 +692,48 @@ func (s *Server) handleNextJob(w http.ResponseWriter, r *http.Request) {
 
 //========================= JOB RESULTS ===============================//
 
+// maxLogSize caps a single uploaded job log
+const maxLogSize = 64 << 20
+
+// POST /jobs/{id}/log -> raw log body of a job, streamed by the agent running it.
+// The server hashes it itself and stores it content-addressed.
+func (s *Server) handleUploadLog(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if r.Method != http.MethodPost || len(parts) != 3 || parts[2] != "log" {
+		http.Error(w, "invalid request path", http.StatusBadRequest)
+		return
+	}
+	jobID := parts[1]
+
+	s.mu.Lock()
+	agentID, assigned := s.assignedJobs[jobID]
+	authErr := s.authenticateAgent(r, agentID)
+	s.mu.Unlock()
+	if !assigned {
+		http.Error(w, "job not assigned: "+jobID, http.StatusNotFound)
+		return
+	}
+	if authErr != nil {
+		http.Error(w, authErr.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	hash, path, size, err := s.logs.Put(http.MaxBytesReader(w, r.Body, maxLogSize))
+	if err != nil {
+		http.Error(w, "failed to store log: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	s.uploadedLogs[jobID] = hash
+	s.persist(kindLog, jobID, hash)
+	s.mu.Unlock()
+
+	fmt.Printf("📝 Stored log of job %s (%d bytes) at %s\n", jobID, size, path)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"logHash": hash})
+}
+
 func (s *Server) handleJobResult(w http.ResponseWriter, r *http.Request) {
 	var result map[string]interface{}
 	if err := json.NewDecoder(r.Body).Decode(&result); err != nil {
@@ -264,47 +744,192 @@ func (s *Server) handleJobResult(w http.ResponseWriter, r *http.Request) {
 
 	fmt.Println("📩 Job result received:", result)
 
-	idx := s.ledger.NextIndex()
-	prev := s.ledger.LastHash()
-
-	stage := fmt.Sprintf("%v", result["stage"])
-	step := fmt.Sprintf("%v", result["step"])
-	logPath := fmt.Sprintf("%v", result["logPath"])
 	logHash := fmt.Sprintf("%v", result["logHash"])
 	agent := fmt.Sprintf("%v", result["agentID"])
-	pipelineID := fmt.Sprintf("%v", result["pipelineId"])
+	jobID := fmt.Sprintf("%v", result["id"])
+	exitCode := -1
+	if code, ok := result["exitCode"].(float64); ok {
+		exitCode = int(code)
+	}
+
+	// success is authoritative; status only refines a failure (e.g. timed_out).
+	// A missing success flag counts as failure.
+	outcome := core.StateFailed
+	if success, _ := result["success"].(bool); success {
+		outcome = core.StateSucceeded
+	} else if st, _ := result["status"].(string); core.State(st) == core.StateTimedOut {
+		outcome = core.StateTimedOut
+	}
 
-	blk, err := blockchain.NewBlock(idx, stage, step, logPath, logHash, prev, agent)
+	s.mu.Lock()
+	authErr := s.authenticateAgent(r, agent)
+	assignedTo, assigned := s.assignedJobs[jobID]
+	job, known := s.runningJobs[jobID]
+	serverLogHash, uploaded := s.uploadedLogs[jobID]
+	s.mu.Unlock()
+	if authErr != nil {
+		http.Error(w, authErr.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	// the result must answer a job this agent was handed; stage, step and
+	// run are taken from the server's job, never from the request
+	if !assigned || !known {
+		http.Error(w, "job not assigned: "+jobID, http.StatusNotFound)
+		return
+	}
+	if assignedTo != agent {
+		http.Error(w, fmt.Sprintf("job %s is not assigned to %s", jobID, agent), http.StatusForbidden)
+		return
+	}
+	if err := job.matchResult(result); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	stage, step, pipelineID := job.Stage, job.Step, job.PipelineID
+
+	// the ledger records the hash the server computed, never the agent's claim.
+	// An agent that could not deliver the log says why; the step is then
+	// recorded as failed with an empty log so the run does not hang.
+	// An upload the agent could not confirm is dropped along with it.
+	logError, _ := result["logError"].(string)
+	serverLogHash, lostLog, err := core.ResultLog(serverLogHash, uploaded, logError)
 	if err != nil {
-		http.Error(w, "failed to create block: "+err.Error(), 500)
+		http.Error(w, fmt.Sprintf("job %s: %v", jobID, err), http.StatusBadRequest)
+		return
+	}
+	if logHash != serverLogHash {
+		http.Error(w, "log hash does not match the uploaded log", http.StatusBadRequest)
 		return
 	}
 
-	if err := s.ledger.AppendBlocks(blk, s.privKey, s.pubKey); err != nil {
-		http.Error(w, "failed to append block: "+err.Error(), 500)
+	// the result must be signed by the agent's trusted key
+	agentKey, ok := s.trustedAgents[agent]
+	if !ok {
+		http.Error(w, "agent not trusted: "+agent, http.StatusForbidden)
+		return
+	}
+	// finishedAt is part of the signed envelope and must be the string the
+	// agent signed; startedAt is informational and dropped when malformed
+	finishedAt, ok := result["finishedAt"].(string)
+	if !ok || finishedAt == "" {
+		http.Error(w, "finishedAt must be a timestamp string", http.StatusBadRequest)
+		return
+	}
+	startedAt, _ := result["startedAt"].(string)
+	env := security.ResultEnvelope{
+		JobID:     jobID,
+		LogHash:   logHash,
+		ExitCode:  exitCode,
+		Timestamp: finishedAt,
+	}
+	agentSig, _ := result["signature"].(string)
+	if !security.VerifyResult(agentKey, env, agentSig) {
+		http.Error(w, "invalid result signature", http.StatusForbidden)
 		return
 	}
 
-	// update step + free agent
+	if lostLog {
+		if _, _, _, err := s.logs.Put(strings.NewReader("")); err != nil {
+			http.Error(w, "failed to store empty log: "+err.Error(), 500)
+			return
+		}
+		fmt.Printf("⚠️ job %s: agent %s could not deliver the log (%s), recording the step as failed\n", jobID, agent, logError)
+		outcome = core.StateFailed
+	}
+	logPath := s.logs.Path(serverLogHash)
+
 	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// a concurrent report for the same job may have won the race
+	if s.assignedJobs[jobID] != agent {
+		http.Error(w, "job not assigned: "+jobID, http.StatusConflict)
+		return
+	}
+
+	// a block appended before a crash may have lost the state saved after
+	// it; the agent's retried report then finishes the bookkeeping instead
+	// of recording the job twice
+	blk, err := s.ledger.JobBlock(jobID)
+	switch {
+	case err == nil:
+		fmt.Printf("♻️ job %s already recorded in block %d, finishing its state\n", jobID, blk.Index)
+		outcome = core.State(blk.Outcome)
+	case errors.Is(err, blockchain.ErrBlockNotFound):
+		blk = nil
+	default:
+		http.Error(w, "failed to look up the job block: "+err.Error(), 500)
+		return
+	}
+
+	// reject results that do not follow the step state machine
+	// (e.g. a second report for a step that already finished)
 	stepKey := fmt.Sprintf("%s:%s", stage, step)
-	if _, ok := s.status[pipelineID]; ok {
-		s.status[pipelineID][stepKey] = StepStatus{Status: "done", Agent: agent}
+	if steps, ok := s.status[pipelineID]; ok {
+		if err := core.StepStates.Transition(steps[stepKey].Status, outcome); err != nil {
+			http.Error(w, fmt.Sprintf("step %s: %v", stepKey, err), http.StatusConflict)
+			return
+		}
 	}
-	s.agentBusy[agent] = false
 
-	// update global pipeline status
-	allDone := true
-	for _, st := range s.status[pipelineID] {
-		if st.Status != "done" {
-			allDone = false
-			break
+	if blk == nil {
+		idx := s.ledger.NextIndex()
+		prev := s.ledger.LastHash()
+
+		blk, err = blockchain.NewBlock(idx, stage, step, logPath, logHash, prev, agent)
+		if err != nil {
+			http.Error(w, "failed to create block: "+err.Error(), 500)
+			return
+		}
+		blk.Outcome = string(outcome)
+		blk.JobID = jobID
+		blk.ExitCode = exitCode
+		blk.StartedAt = startedAt
+		blk.FinishedAt = env.Timestamp
+		blk.AgentSig = agentSig
+		blk.AgentPubKey = hex.EncodeToString(agentKey)
+		// the command hash comes from the server's own copy of the pipeline
+		// when it knows the run, so agents cannot misreport what they ran
+		blk.CmdHash = utils.HashString(job.Cmd)
+		if pipeline, ok := s.pipelines[pipelineID]; ok {
+			blk.RunID = pipelineID
+			blk.PipelineHash = s.pipelineHash[pipelineID]
+			if def, ok := findStep(pipeline, core.StepRef{Stage: stage, Step: step}); ok {
+				blk.CmdHash = utils.HashString(def.Run)
+			}
+		}
+
+		if err := s.ledger.AppendBlocks(blk, s.privKey, s.pubKey); err != nil {
+			http.Error(w, "failed to append block: "+err.Error(), 500)
+			return
 		}
 	}
-	if allDone {
-		s.pipelineGlobal[pipelineID] = "done"
+
+	// update step + free agent
+	if err := s.setStepState(pipelineID, stepKey, outcome, agent); err != nil {
+		fmt.Printf("⚠️ pipeline %s: %v\n", pipelineID, err)
 	}
-	s.mu.Unlock()
+	s.agentBusy[agent] = false
+	delete(s.assignedJobs, jobID)
+	delete(s.runningJobs, jobID)
+	delete(s.uploadedLogs, jobID)
+
+	if outcome != core.StateSucceeded {
+		s.failPipeline(pipelineID, outcome)
+	} else if progress, ok := s.progress[pipelineID]; ok {
+		// release the steps that were waiting on this one
+		progress.Complete(core.StepRef{Stage: stage, Step: step})
+		s.releaseJobs(pipelineID)
+		if progress.Done() {
+			s.setPipelineState(pipelineID, core.StateSucceeded)
+		}
+	}
+	s.sealRun(pipelineID)
+	s.persistPipeline(pipelineID)
+	s.persistQueue()
+	s.persistAssigned(jobID)
+	s.unpersist(kindLog, jobID)
 
 	resp := map[string]string{
 		"status": "recorded",
@@ -317,15 +942,41 @@ func (s *Server) handleJobResult(w http.ResponseWriter, r *http.Request) {
 //========================= BOOTSTRAP ===============================//
 
 func main() {
-	s := NewServer()
+	rotate := flag.Bool("rotate-key", false, "rotate the server signing key and exit")
+	encrypt := flag.Bool("encrypt-key", false, "encrypt a plaintext server key file with a passphrase and exit")
+	flag.Parse()
+	if *rotate {
+		if err := rotateServerKey(); err != nil {
+			fmt.Println("❌ key rotation failed:", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if *encrypt {
+		if err := encryptServerKey(); err != nil {
+			fmt.Println("❌ key encryption failed:", err)
+			os.Exit(1)
+		}
+		return
+	}
 
-	http.HandleFunc("/pipelines", s.handleSubmitPipeline)
-	http.HandleFunc("/pipelines/", s.handleGetPipelineStatus)
-	http.HandleFunc("/ledger/verify", s.handleVerifyLedger)
+	s := NewServer()
 
+	// client endpoints, guarded by api_token when configured
+	http.HandleFunc("/pipelines", s.requireClient(s.handlePipelines))
+	http.HandleFunc("/pipelines/", s.requireClient(s.handlePipeline))
+	http.HandleFunc("/agents", s.requireClient(s.handleListAgents))
+	http.HandleFunc("/ledger/verify", s.requireClient(s.handleVerifyLedger))
+	http.HandleFunc("/ledger/verify/deep", s.requireClient(s.handleDeepVerifyLedger))
+	http.HandleFunc("/ledger/proof", s.requireClient(s.handleLogProof))
+	http.HandleFunc("/ledger/blocks", s.requireClient(s.handleLedgerBlocks))
+	http.HandleFunc("/ledger/blocks/", s.requireClient(s.handleLedgerBlocks))
+
+	http.HandleFunc("/agent/challenge", s.handleAgentChallenge)
 	http.HandleFunc("/agent/register", s.handleRegisterAgent)
 	http.HandleFunc("/agents/", s.handleNextJob)
 	http.HandleFunc("/jobs/result", s.handleJobResult)
+	http.HandleFunc("/jobs/", s.handleUploadLog)
 
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"blockci-q/internal/blockchain"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//========================= LEDGER QUERIES ===============================//
+
+// GET /ledger/blocks?from=&to=&agent=&stage=&step=&run=&pipeline=&since=&until=&offset=&limit=
+// -> one page of matching blocks. run is a run ID or "name#N", pipeline a
+// pipeline name (all its runs), since/until RFC3339 timestamps.
+// GET /ledger/blocks/{index} and /ledger/blocks/by-hash/{hash} -> one block
+func (s *Server) handleLedgerBlocks(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/ledger/blocks"), "/")
+	switch {
+	case rest == "":
+		s.queryBlocks(w, r)
+	case strings.HasPrefix(rest, "by-hash/"):
+		s.writeBlock(w, func() (*blockchain.Block, error) {
+			return s.ledger.BlockByHash(strings.TrimPrefix(rest, "by-hash/"))
+		})
+	default:
+		index, err := strconv.Atoi(rest)
+		if err != nil {
+			http.Error(w, "invalid block index", http.StatusBadRequest)
+			return
+		}
+		s.writeBlock(w, func() (*blockchain.Block, error) { return s.ledger.Block(index) })
+	}
+}
+
+func (s *Server) writeBlock(w http.ResponseWriter, get func() (*blockchain.Block, error)) {
+	blk, err := get()
+	if errors.Is(err, blockchain.ErrBlockNotFound) {
+		http.Error(w, "block not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "cannot read block: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(blk)
+}
+
+func (s *Server) queryBlocks(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	q, err := parseBlockQuery(params)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	runs, ok := s.queryRuns(params.Get("run"), params.Get("pipeline"))
+	if !ok {
+		// the pipeline has no runs, or the run is not one of them
+		limit := blockchain.DefaultQueryLimit
+		if q.Limit > 0 {
+			limit = min(q.Limit, blockchain.MaxQueryLimit)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(&blockchain.BlockPage{Blocks: []*blockchain.Block{}, Offset: q.Offset, Limit: limit})
+		return
+	}
+	q.RunIDs = runs
+
+	page, err := s.ledger.Query(q)
+	if err != nil {
+		http.Error(w, "cannot query ledger: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+// parseBlockQuery reads the index, time and page parameters of a query
+func parseBlockQuery(params url.Values) (blockchain.BlockQuery, error) {
+	q := blockchain.BlockQuery{
+		AgentID: params.Get("agent"),
+		Stage:   params.Get("stage"),
+		Step:    params.Get("step"),
+	}
+	ints := map[string]*int{"from": &q.From, "to": &q.To, "offset": &q.Offset, "limit": &q.Limit}
+	for name, dst := range ints {
+		if v := params.Get(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return q, fmt.Errorf("invalid %s: %q", name, v)
+			}
+			*dst = n
+		}
+	}
+	times := map[string]*time.Time{"since": &q.Since, "until": &q.Until}
+	for name, dst := range times {
+		if v := params.Get(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return q, fmt.Errorf("invalid %s: want RFC3339, got %q", name, v)
+			}
+			*dst = t
+		}
+	}
+	return q, nil
+}
+
+// queryRuns resolves the run and pipeline filters into run IDs. ok is false
+// when the filters match no run.
+func (s *Server) queryRuns(run, pipeline string) (runs []string, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if runID, found := s.runIndex[run]; found {
+		run = runID
+	}
+	if pipeline == "" {
+		if run == "" {
+			return nil, true
+		}
+		return []string{run}, true
+	}
+	for id, p := range s.pipelines {
+		if p.Name == pipeline && (run == "" || run == id) {
+			runs = append(runs, id)
+		}
+	}
+	return runs, len(runs) > 0
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"blockci-q/internal/blockchain"
+	"blockci-q/internal/core"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+//========================= RUN LIFECYCLE ===============================//
+
+// runListEntry is one run in GET /pipelines
+type runListEntry struct {
+	ID        string     `json:"id"`
+	Run       string     `json:"run"`
+	Pipeline  string     `json:"pipeline"`
+	RunNumber int        `json:"runNumber"`
+	Status    core.State `json:"status"`
+	Sealed    bool       `json:"sealed"`
+}
+
+// GET /pipelines -> every run, by pipeline name and run number
+func (s *Server) handleListRuns(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	runs := make([]runListEntry, 0, len(s.pipelines))
+	for id, pipeline := range s.pipelines {
+		runs = append(runs, runListEntry{
+			ID:        id,
+			Run:       runName(pipeline, s.runNumber[id]),
+			Pipeline:  pipelineName(pipeline),
+			RunNumber: s.runNumber[id],
+			Status:    s.pipelineGlobal[id],
+			Sealed:    s.sealed[id],
+		})
+	}
+	s.mu.Unlock()
+
+	sort.Slice(runs, func(i, j int) bool {
+		if runs[i].Pipeline != runs[j].Pipeline {
+			return runs[i].Pipeline < runs[j].Pipeline
+		}
+		return runs[i].RunNumber < runs[j].RunNumber
+	})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(runs)
+}
+
+// POST /pipelines/{id}/cancel -> drops the queued jobs and skips the rest.
+// Steps already running still report and are recorded; the run is sealed
+// once they finish.
+func (s *Server) handleCancelRun(w http.ResponseWriter, id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if state := s.pipelineGlobal[id]; state.Terminal() {
+		http.Error(w, fmt.Sprintf("run already %s", state), http.StatusConflict)
+		return
+	}
+	s.stopRun(id, core.StateCancelled, core.StateCancelled)
+	s.sealRun(id)
+	s.persistPipeline(id)
+	s.persistQueue()
+	fmt.Printf("🛑 Pipeline %s cancelled\n", id)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.runResponse(id))
+}
+
+// POST /pipelines/{id}/retry[?from_stage=<stage>] -> starts a new run of a
+// finished run's definition. With from_stage, the stages before it are not
+// run again; each must have succeeded in (or been carried into) the
+// original run.
+func (s *Server) handleRetryRun(w http.ResponseWriter, r *http.Request, id string) {
+	fromStage := r.URL.Query().Get("from_stage")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if state := s.pipelineGlobal[id]; !state.Terminal() {
+		http.Error(w, fmt.Sprintf("run is still %s", state), http.StatusConflict)
+		return
+	}
+	pipeline := s.pipelines[id]
+
+	carried, err := core.CarriedStages(pipeline, s.stageStatus(id), s.carried[id], fromStage)
+	if errors.Is(err, core.ErrUnknownStage) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("%s: %v", id, err), http.StatusConflict)
+		return
+	}
+
+	progress, err := core.NewScheduler().Track(pipeline)
+	if err != nil {
+		http.Error(w, "invalid pipeline: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	newID := s.startRun(pipeline, progress, s.pipelineHash[id], carried)
+	fmt.Printf("🔁 Retrying %s as %s\n", id, newID)
+
+	resp := s.runResponse(newID)
+	resp["retryOf"] = id
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// runLog is one step in GET /pipelines/{id}/logs
+type runLog struct {
+	Stage   string     `json:"stage"`
+	Step    string     `json:"step"`
+	Status  core.State `json:"status"`
+	LogHash string     `json:"logHash,omitempty"` // empty until the step is recorded
+}
+
+// GET /pipelines/{id}/logs -> the steps of a run with the hash of each
+// recorded log. GET /pipelines/{id}/logs/{stage}/{step} -> the raw log.
+func (s *Server) handleRunLogs(w http.ResponseWriter, id, stepPath string) {
+	hashes := make(map[string]string)
+	q := blockchain.BlockQuery{RunIDs: []string{id}, Limit: blockchain.MaxQueryLimit}
+	for {
+		page, err := s.ledger.Query(q)
+		if err != nil {
+			http.Error(w, "cannot query ledger: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for _, blk := range page.Blocks {
+			if blk.Type == blockchain.BlockTypeStep {
+				hashes[core.StepRef{Stage: blk.Stage, Step: blk.Step}.Key()] = blk.LogHash
+			}
+		}
+		if page.NextOffset == 0 {
+			break
+		}
+		q.Offset = page.NextOffset
+	}
+
+	if stepPath != "" {
+		stage, step, _ := strings.Cut(stepPath, "/")
+		hash, ok := hashes[core.StepRef{Stage: stage, Step: step}.Key()]
+		if !ok {
+			http.Error(w, "no log recorded for "+stepPath, http.StatusNotFound)
+			return
+		}
+		f, err := s.logs.Open(hash)
+		if err != nil {
+			http.Error(w, "cannot open log: "+err.Error(), http.StatusNotFound)
+			return
+		}
+		defer f.Close()
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		io.Copy(w, f)
+		return
+	}
+
+	s.mu.Lock()
+	logs := make([]runLog, 0)
+	for _, stage := range s.pipelines[id].Stages {
+		for _, step := range stage.Steps {
+			key := core.StepRef{Stage: stage.Name, Step: step.Name}.Key()
+			logs = append(logs, runLog{Stage: stage.Name, Step: step.Name, Status: s.status[id][key].Status, LogHash: hashes[key]})
+		}
+	}
+	s.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(logs)
+}
+
+// agentListEntry is one agent in GET /agents
+type agentListEntry struct {
+	ID   string `json:"id"`
+	Host string `json:"host"`
+	Busy bool   `json:"busy"`
+	Job  string `json:"job,omitempty"` // job the agent is running
+}
+
+// GET /agents -> registered agents and what they are running
+func (s *Server) handleListAgents(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	jobs := make(map[string]string)
+	for jobID, agentID := range s.assignedJobs {
+		jobs[agentID] = jobID
+	}
+	agents := make([]agentListEntry, 0, len(s.agents))
+	for id, agent := range s.agents {
+		agents = append(agents, agentListEntry{ID: id, Host: agent.Host, Busy: s.agentBusy[id], Job: jobs[id]})
+	}
+	s.mu.Unlock()
+
+	sort.Slice(agents, func(i, j int) bool { return agents[i].ID < agents[j].ID })
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(agents)
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"blockci-q/internal/core"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Record kinds kept in the state store
+const (
+	kindPipeline = "pipeline" // pipelineID -> pipelineRecord
+	kindAgent    = "agent"    // agentID -> Agent
+	kindQueue    = "queue"    // "jobs" -> []Job, in dispatch order
+	kindAssigned = "assigned" // jobID -> assignment of jobs being run
+	kindLog      = "log"      // jobID -> hash of the uploaded log, until the result arrives
+	kindMeta     = "meta"     // "counters" -> counters
+)
+
+// assignment is the persisted form of a job handed to an agent. Older
+// records hold only the agent ID; their job is rebuilt on restore.
+type assignment struct {
+	Agent string `json:"agent"`
+	Job   Job    `json:"job"`
+}
+
+// pipelineRecord is the persisted form of one pipeline and its step states
+type pipelineRecord struct {
+	ID        string                `json:"id"`
+	RunNumber int                   `json:"runNumber"`
+	Hash      string                `json:"hash"` // SHA-256 of the submitted definition
+	Pipeline  *core.Pipeline        `json:"pipeline"`
+	Status    map[string]StepStatus `json:"status"`
+	State     core.State            `json:"state"`
+	JobSeq    int                   `json:"jobSeq"`
+	Sealed    bool                  `json:"sealed,omitempty"`  // run_summary block written
+	Carried   map[string]bool       `json:"carried,omitempty"` // stages taken over from the retried run
+}
+
+// counters keeps run numbering across restarts
+type counters struct {
+	RunNumbers map[string]int `json:"runNumbers"` // pipeline name -> last run number
+}
+
+// persist writes one record and logs failures; the in-memory state stays
+// authoritative for the running process. Caller must hold s.mu.
+func (s *Server) persist(kind, key string, value interface{}) {
+	if s.store == nil {
+		return
+	}
+	if err := s.store.Put(kind, key, value); err != nil {
+		fmt.Printf("⚠️ WARN: cannot persist %s %s: %v\n", kind, key, err)
+	}
+}
+
+// unpersist deletes one record, logging failures. Caller must hold s.mu.
+func (s *Server) unpersist(kind, key string) {
+	if s.store == nil {
+		return
+	}
+	if err := s.store.Delete(kind, key); err != nil {
+		fmt.Printf("⚠️ WARN: cannot persist %s %s: %v\n", kind, key, err)
+	}
+}
+
+func (s *Server) persistPipeline(id string) {
+	if _, ok := s.pipelines[id]; !ok {
+		return
+	}
+	s.persist(kindPipeline, id, pipelineRecord{
+		ID:        id,
+		RunNumber: s.runNumber[id],
+		Hash:      s.pipelineHash[id],
+		Pipeline:  s.pipelines[id],
+		Status:    s.status[id],
+		State:     s.pipelineGlobal[id],
+		JobSeq:    s.jobSeq[id],
+		Sealed:    s.sealed[id],
+		Carried:   s.carried[id],
+	})
+}
+
+func (s *Server) persistQueue() {
+	s.persist(kindQueue, "jobs", s.jobs)
+}
+
+func (s *Server) persistCounters() {
+	s.persist(kindMeta, "counters", counters{RunNumbers: s.runNumbers})
+}
+
+func (s *Server) persistAssigned(jobID string) {
+	if agentID, ok := s.assignedJobs[jobID]; ok {
+		s.persist(kindAssigned, jobID, assignment{Agent: agentID, Job: s.runningJobs[jobID]})
+		return
+	}
+	s.unpersist(kindAssigned, jobID)
+}
+
+// restoreState reloads pipelines, agents, queued work and running jobs
+// from the store and rebuilds the dependency tracking of active pipelines.
+func (s *Server) restoreState() error {
+	records, err := s.store.Load()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if raw, ok := records[kindMeta]["counters"]; ok {
+		var c counters
+		if err := json.Unmarshal(raw, &c); err != nil {
+			return fmt.Errorf("decode counters: %w", err)
+		}
+		for name, n := range c.RunNumbers {
+			s.runNumbers[name] = n
+		}
+	}
+
+	for id, raw := range records[kindAgent] {
+		var agent Agent
+		if err := json.Unmarshal(raw, &agent); err != nil {
+			return fmt.Errorf("decode agent %s: %w", id, err)
+		}
+		s.agents[id] = agent
+	}
+
+	if raw, ok := records[kindQueue]["jobs"]; ok {
+		if err := json.Unmarshal(raw, &s.jobs); err != nil {
+			return fmt.Errorf("decode job queue: %w", err)
+		}
+	}
+
+	// jobs that were running re-attach to their agent, which reports the result later
+	var legacy []string
+	for jobID, raw := range records[kindAssigned] {
+		var a assignment
+		if err := json.Unmarshal(raw, &a); err != nil {
+			if err := json.Unmarshal(raw, &a.Agent); err != nil {
+				return fmt.Errorf("decode assignment %s: %w", jobID, err)
+			}
+			legacy = append(legacy, jobID)
+		}
+		s.assignedJobs[jobID] = a.Agent
+		s.runningJobs[jobID] = a.Job
+		s.agentBusy[a.Agent] = true
+	}
+
+	for jobID, raw := range records[kindLog] {
+		var hash string
+		if err := json.Unmarshal(raw, &hash); err != nil {
+			return fmt.Errorf("decode uploaded log %s: %w", jobID, err)
+		}
+		s.uploadedLogs[jobID] = hash
+	}
+
+	for id, raw := range records[kindPipeline] {
+		var rec pipelineRecord
+		if err := json.Unmarshal(raw, &rec); err != nil {
+			return fmt.Errorf("decode pipeline %s: %w", id, err)
+		}
+		s.pipelines[id] = rec.Pipeline
+		s.status[id] = rec.Status
+		s.pipelineGlobal[id] = rec.State
+		s.jobSeq[id] = rec.JobSeq
+		s.runNumber[id] = rec.RunNumber
+		s.pipelineHash[id] = rec.Hash
+		s.sealed[id] = rec.Sealed
+		if len(rec.Carried) > 0 {
+			s.carried[id] = rec.Carried
+		}
+		s.runIndex[runName(rec.Pipeline, rec.RunNumber)] = id
+
+		if rec.State.Terminal() {
+			// seal runs that finished right before the restart
+			s.sealRun(id)
+			continue
+		}
+		progress, err := core.NewScheduler().Track(rec.Pipeline)
+		if err != nil {
+			return fmt.Errorf("rebuild pipeline %s: %w", id, err)
+		}
+		for key, st := range rec.Status {
+			progress.Restore(stepRefFromKey(rec.Pipeline, key), st.Status)
+		}
+		s.progress[id] = progress
+		// release anything that became ready right before the restart
+		s.releaseJobs(id)
+		s.persistPipeline(id)
+	}
+	s.persistQueue()
+
+	for _, jobID := range legacy {
+		if job, ok := s.legacyJob(jobID); ok {
+			s.runningJobs[jobID] = job
+			s.persistAssigned(jobID)
+		} else {
+			fmt.Printf("⚠️ cannot rebuild running job %s, its result will be rejected\n", jobID)
+		}
+	}
+
+	fmt.Printf("♻️ Restored %d pipelines, %d agents, %d queued and %d running jobs\n",
+		len(s.pipelines), len(s.agents), len(s.jobs), len(s.assignedJobs))
+	return nil
+}
+
+// legacyJob rebuilds a running job from an assignment record that only
+// held the agent ID: the step of its run that agent is running. Caller must
+// hold s.mu.
+func (s *Server) legacyJob(jobID string) (Job, bool) {
+	agentID := s.assignedJobs[jobID]
+	pipelineID, _, ok := strings.Cut(jobID, "-job-")
+	if !ok {
+		return Job{}, false
+	}
+	pipeline, ok := s.pipelines[pipelineID]
+	if !ok {
+		return Job{}, false
+	}
+	for key, st := range s.status[pipelineID] {
+		if st.Status != core.StateRunning || st.Agent != agentID {
+			continue
+		}
+		ref := stepRefFromKey(pipeline, key)
+		step, ok := findStep(pipeline, ref)
+		if !ok {
+			continue
+		}
+		return Job{ID: jobID, Stage: ref.Stage, Step: ref.Step, Cmd: step.Run, PipelineID: pipelineID}, true
+	}
+	return Job{}, false
+}
+
+// stepRefFromKey maps a "stage:step" status key back to its StepRef
+func stepRefFromKey(pipeline *core.Pipeline, key string) core.StepRef {
+	for _, stage := range pipeline.Stages {
+		for _, step := range stage.Steps {
+			ref := core.StepRef{Stage: stage.Name, Step: step.Name}
+			if ref.Key() == key {
+				return ref
+			}
+		}
+	}
+	return core.StepRef{}
+}
@@ -4,5 +4,9 @@ go 1.23.5
 
 require (
 	github.com/google/uuid v1.6.0
+	golang.org/x/crypto v0.36.0
+	golang.org/x/term v0.30.0
 	gopkg.in/yaml.v3 v3.0.1
 )
+
+require golang.org/x/sys v0.31.0 // indirect
@@ -1,6 +1,8 @@
 package blockchain
 
 import (
+	"blockci-q/internal/security"
+	"crypto/ed25519"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
@@ -8,34 +10,92 @@ import (
 	"time"
 )
 
+// Canonical form versions. Version 1 is the original schema (blocks written
+// before the field existed decode as 0 and are treated as 1); version 2 binds
+// the block to its run, job, command and pipeline definition.
+const (
+	BlockVersionLegacy = 1
+	BlockVersion       = 2 // current
+)
+
+// Block types. Step blocks carry no type.
+const (
+	BlockTypeStep        = ""
+	BlockTypeKeyRotation = "key_rotation" // announces NewPubKey, signed by the outgoing key
+	BlockTypeRunSummary  = "run_summary"  // seals a run with the Merkle root over its log hashes
+	BlockTypeCheckpoint  = "checkpoint"   // commits to the chain head and the state needed to verify on
+)
+
 // Block is a tamper-evident record for one pipeline step
 type Block struct {
-	Index     int    `json:"index"`
-	Timestamp string `json:"timestamp"`
-	Stage     string `json:"stage"`
-	Step      string `json:"step"`
-	LogPath   string `json:"logPath"`
-	LogHash   string `json:"logHash"`
-	PrevHash  string `json:"prevHash"`
-	Hash      string `json:"hash"`
-	AgentID   string `json:"agentId"`
-	Signature string `json:"signature"`
-	PubKey    string `json:"pubKey"`
+	Version      int         `json:"version,omitempty"` // canonical form version, see BlockVersion
+	Type         string      `json:"type,omitempty"`    // see BlockTypeStep / BlockTypeKeyRotation
+	Index        int         `json:"index"`
+	Timestamp    string      `json:"timestamp"`
+	Stage        string      `json:"stage"`
+	Step         string      `json:"step"`
+	LogPath      string      `json:"logPath"`
+	LogHash      string      `json:"logHash"`
+	PrevHash     string      `json:"prevHash"`
+	Hash         string      `json:"hash"`
+	AgentID      string      `json:"agentId"`
+	Outcome      string      `json:"outcome,omitempty"`        // terminal step state, e.g. succeeded, failed
+	RunID        string      `json:"runId,omitempty"`          // pipeline run the step belongs to
+	JobID        string      `json:"jobId,omitempty"`          // job that executed the step
+	CmdHash      string      `json:"cmdHash,omitempty"`        // SHA-256 of the step command
+	ExitCode     int         `json:"exitCode,omitempty"`       // exit code of the step command
+	StartedAt    string      `json:"startedAt,omitempty"`      // RFC3339 start of the step
+	FinishedAt   string      `json:"finishedAt,omitempty"`     // RFC3339 end of the step
+	PipelineHash string      `json:"pipelineHash,omitempty"`   // SHA-256 of the submitted pipeline definition
+	AgentSig     string      `json:"agentSignature,omitempty"` // agent signature over the result envelope
+	AgentPubKey  string      `json:"agentPubKey,omitempty"`    // trusted agent key that verified AgentSig
+	NewPubKey    string      `json:"newPubKey,omitempty"`      // key_rotation: hex key signing every later block
+	MerkleRoot   string      `json:"merkleRoot,omitempty"`     // run_summary: root over the run's log hashes
+	LeafCount    int         `json:"leafCount,omitempty"`      // run_summary: number of step blocks in the tree
+	Checkpoint   *ChainState `json:"checkpoint,omitempty"`     // checkpoint: chain state after the previous block
+	Signature    string      `json:"signature"`
+	PubKey       string      `json:"pubKey"`
+}
+
+// ResultEnvelope rebuilds the envelope the agent signed from the block fields
+func (b *Block) ResultEnvelope() security.ResultEnvelope {
+	return security.ResultEnvelope{
+		JobID:     b.JobID,
+		LogHash:   b.LogHash,
+		ExitCode:  b.ExitCode,
+		Timestamp: b.FinishedAt,
+	}
 }
 
 // canonicalData returns the JSON bytes used to compute the block hash.
 // It intentionally excludes Hash, Signature and PubKey.
+// The layout depends on the block version so old blocks keep verifying.
 func (b *Block) canonicalData() ([]byte, error) {
+	switch b.Version {
+	case 0, BlockVersionLegacy:
+		return b.canonicalV1()
+	case BlockVersion:
+		return b.canonicalV2()
+	default:
+		return nil, fmt.Errorf("unsupported block version %d", b.Version)
+	}
+}
+
+// canonicalV1 is the original view. Outcome and RunID are omitted when empty
+// so blocks written before they existed still verify.
+func (b *Block) canonicalV1() ([]byte, error) {
 	// Use a stable view for hashing
 	view := struct {
-		Index    int    `json:"index"`
+		Index     int    `json:"index"`
 		Timestamp string `json:"timestamp"`
-		Stage    string `json:"stage"`
-		Step     string `json:"step"`
-		LogPath  string `json:"logPath"`
-		LogHash  string `json:"logHash"`
-		PrevHash string `json:"prevHash"`
-		AgentID  string `json:"agentId"`
+		Stage     string `json:"stage"`
+		Step      string `json:"step"`
+		LogPath   string `json:"logPath"`
+		LogHash   string `json:"logHash"`
+		PrevHash  string `json:"prevHash"`
+		AgentID   string `json:"agentId"`
+		Outcome   string `json:"outcome,omitempty"`
+		RunID     string `json:"runId,omitempty"`
 	}{
 		Index:     b.Index,
 		Timestamp: b.Timestamp,
@@ -45,6 +105,66 @@ func (b *Block) canonicalData() ([]byte, error) {
 		LogHash:   b.LogHash,
 		PrevHash:  b.PrevHash,
 		AgentID:   b.AgentID,
+		Outcome:   b.Outcome,
+		RunID:     b.RunID,
+	}
+	return json.Marshal(view)
+}
+
+// canonicalV2 commits to every field, including zero values such as exit code 0.
+// The agent signature fields are omitted when empty (blocks written before agents signed),
+// and so are the key rotation, run summary and checkpoint fields, which step blocks never carry.
+func (b *Block) canonicalV2() ([]byte, error) {
+	view := struct {
+		Version      int         `json:"version"`
+		Type         string      `json:"type,omitempty"`
+		Index        int         `json:"index"`
+		Timestamp    string      `json:"timestamp"`
+		RunID        string      `json:"runId"`
+		JobID        string      `json:"jobId"`
+		PipelineHash string      `json:"pipelineHash"`
+		Stage        string      `json:"stage"`
+		Step         string      `json:"step"`
+		CmdHash      string      `json:"cmdHash"`
+		AgentID      string      `json:"agentId"`
+		Outcome      string      `json:"outcome"`
+		ExitCode     int         `json:"exitCode"`
+		StartedAt    string      `json:"startedAt"`
+		FinishedAt   string      `json:"finishedAt"`
+		LogPath      string      `json:"logPath"`
+		LogHash      string      `json:"logHash"`
+		PrevHash     string      `json:"prevHash"`
+		AgentSig     string      `json:"agentSignature,omitempty"`
+		AgentPubKey  string      `json:"agentPubKey,omitempty"`
+		NewPubKey    string      `json:"newPubKey,omitempty"`
+		MerkleRoot   string      `json:"merkleRoot,omitempty"`
+		LeafCount    int         `json:"leafCount,omitempty"`
+		Checkpoint   *ChainState `json:"checkpoint,omitempty"`
+	}{
+		Version:      b.Version,
+		Type:         b.Type,
+		Index:        b.Index,
+		Timestamp:    b.Timestamp,
+		RunID:        b.RunID,
+		JobID:        b.JobID,
+		PipelineHash: b.PipelineHash,
+		Stage:        b.Stage,
+		Step:         b.Step,
+		CmdHash:      b.CmdHash,
+		AgentID:      b.AgentID,
+		Outcome:      b.Outcome,
+		ExitCode:     b.ExitCode,
+		StartedAt:    b.StartedAt,
+		FinishedAt:   b.FinishedAt,
+		LogPath:      b.LogPath,
+		LogHash:      b.LogHash,
+		PrevHash:     b.PrevHash,
+		AgentSig:     b.AgentSig,
+		AgentPubKey:  b.AgentPubKey,
+		NewPubKey:    b.NewPubKey,
+		MerkleRoot:   b.MerkleRoot,
+		LeafCount:    b.LeafCount,
+		Checkpoint:   b.Checkpoint,
 	}
 	return json.Marshal(view)
 }
@@ -59,9 +179,11 @@ func (b *Block) ComputeHash() (string, error) {
 	return hex.EncodeToString(sum[:]), nil
 }
 
-// NewBlock constructs a block and computes its hash (no signature yet)
+// NewBlock constructs a current-version block and computes its hash (no signature yet).
+// Run/job fields are set by the caller; AppendBlocks recomputes the hash.
 func NewBlock(index int, stage, step, logPath, logHash, prevHash, agentID string) (*Block, error) {
 	blk := &Block{
+		Version:   BlockVersion,
 		Index:     index,
 		Timestamp: time.Now().UTC().Format(time.RFC3339),
 		Stage:     stage,
@@ -79,3 +201,65 @@ func NewBlock(index int, stage, step, logPath, logHash, prevHash, agentID string
 	blk.Hash = h
 	return blk, nil
 }
+
+// NewKeyRotationBlock constructs a block announcing newPub as the key for
+// every later block. It must be appended signed by the outgoing key.
+func NewKeyRotationBlock(index int, prevHash string, newPub ed25519.PublicKey) (*Block, error) {
+	blk := &Block{
+		Version:   BlockVersion,
+		Type:      BlockTypeKeyRotation,
+		Index:     index,
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		PrevHash:  prevHash,
+		NewPubKey: hex.EncodeToString(newPub),
+	}
+
+	h, err := blk.ComputeHash()
+	if err != nil {
+		return nil, fmt.Errorf("compute block hash: %w", err)
+	}
+	blk.Hash = h
+	return blk, nil
+}
+
+// NewRunSummaryBlock constructs a block sealing a run with the Merkle root
+// over the log hashes of its step blocks, in ledger order
+func NewRunSummaryBlock(index int, prevHash, runID string, logHashes []string) (*Block, error) {
+	blk := &Block{
+		Version:    BlockVersion,
+		Type:       BlockTypeRunSummary,
+		Index:      index,
+		Timestamp:  time.Now().UTC().Format(time.RFC3339),
+		PrevHash:   prevHash,
+		RunID:      runID,
+		MerkleRoot: MerkleRoot(logHashes),
+		LeafCount:  len(logHashes),
+	}
+
+	h, err := blk.ComputeHash()
+	if err != nil {
+		return nil, fmt.Errorf("compute block hash: %w", err)
+	}
+	blk.Hash = h
+	return blk, nil
+}
+
+// NewCheckpointBlock constructs a checkpoint committing to the chain head
+// (through prevHash) and to the chain state verification resumes from
+func NewCheckpointBlock(index int, prevHash string, state *ChainState) (*Block, error) {
+	blk := &Block{
+		Version:    BlockVersion,
+		Type:       BlockTypeCheckpoint,
+		Index:      index,
+		Timestamp:  time.Now().UTC().Format(time.RFC3339),
+		PrevHash:   prevHash,
+		Checkpoint: state.clone(),
+	}
+
+	h, err := blk.ComputeHash()
+	if err != nil {
+		return nil, fmt.Errorf("compute block hash: %w", err)
+	}
+	blk.Hash = h
+	return blk, nil
+}
@@ -0,0 +1,133 @@
+package blockchain
+
+import "reflect"
+
+// DefaultCheckpointEvery is the number of blocks between checkpoints
+const DefaultCheckpointEvery = 1000
+
+// ChainState is what verifying a block needs to know about the blocks
+// before it. Checkpoints record it so verification can resume there.
+type ChainState struct {
+	Signer   string              `json:"signer,omitempty"`   // key announced by the last key rotation
+	OpenRuns map[string][]string `json:"openRuns,omitempty"` // unsealed runID -> log hashes of its step blocks
+}
+
+func newChainState() *ChainState {
+	return &ChainState{OpenRuns: make(map[string][]string)}
+}
+
+func (cs *ChainState) clone() *ChainState {
+	c := &ChainState{Signer: cs.Signer, OpenRuns: make(map[string][]string, len(cs.OpenRuns))}
+	for run, logs := range cs.OpenRuns {
+		c.OpenRuns[run] = append([]string(nil), logs...)
+	}
+	return c
+}
+
+// equal compares two states, treating nil and empty run maps alike
+func (cs *ChainState) equal(other *ChainState) bool {
+	if other == nil || cs.Signer != other.Signer || len(cs.OpenRuns) != len(other.OpenRuns) {
+		return false
+	}
+	return len(cs.OpenRuns) == 0 || reflect.DeepEqual(cs.OpenRuns, other.OpenRuns)
+}
+
+// apply advances the state past a block written by this ledger
+func (cs *ChainState) apply(blk *Block) {
+	switch blk.Type {
+	case BlockTypeStep:
+		if blk.RunID != "" {
+			cs.OpenRuns[blk.RunID] = append(cs.OpenRuns[blk.RunID], blk.LogHash)
+		}
+	case BlockTypeRunSummary:
+		delete(cs.OpenRuns, blk.RunID)
+	case BlockTypeKeyRotation:
+		cs.Signer = blk.NewPubKey
+	}
+}
+
+// OpenLedgerFromCheckpoint opens a ledger file from its last checkpoint,
+// see NewLedgerFromCheckpoint
+func OpenLedgerFromCheckpoint(path string) (*Ledger, error) {
+	fs, err := OpenFileStore(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewLedgerFromCheckpoint(fs)
+}
+
+// NewLedgerFromCheckpoint reads the store from its last checkpoint instead
+// of decoding every block: Blocks starts with that checkpoint. Without a
+// usable checkpoint the whole ledger is loaded.
+func NewLedgerFromCheckpoint(store LedgerStore) (*Ledger, error) {
+	cp, err := store.LastCheckpoint()
+	if err != nil || cp == nil || cp.Checkpoint == nil {
+		return NewLedger(store)
+	}
+
+	l := &Ledger{Blocks: make([]*Block, 0), store: store, partial: true}
+	l.trackRecovery(store)
+	if err := l.load(cp.Index); err != nil {
+		return nil, err
+	}
+	if len(l.Blocks) == 0 || l.Blocks[0].Hash != cp.Hash {
+		return NewLedger(store)
+	}
+	return l, nil
+}
+
+// Partial reports whether the ledger was opened from a checkpoint and
+// holds only the blocks from it onward
+func (l *Ledger) Partial() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.partial
+}
+
+// eachBlock calls fn for every block of the ledger, reading the store
+// when only a tail is loaded, until fn returns false. Caller must hold l.mu.
+func (l *Ledger) eachBlock(fn func(*Block) bool) error {
+	if !l.partial {
+		for _, blk := range l.Blocks {
+			if !fn(blk) {
+				return nil
+			}
+		}
+		return nil
+	}
+
+	return l.store.Iterate(0, fn)
+}
+
+// Full returns a ledger holding every block of the store: l itself unless
+// it was opened from a checkpoint. The copy is meant for reading only.
+func (l *Ledger) Full() (*Ledger, error) {
+	l.mu.Lock()
+	partial := l.partial
+	l.mu.Unlock()
+	if !partial {
+		return l, nil
+	}
+	return NewLedger(l.store)
+}
+
+// Audit verifies the whole ledger from block 0, whatever checkpoints it holds
+func (l *Ledger) Audit(opts VerifyOptions) (*VerifyReport, error) {
+	full, err := l.Full()
+	if err != nil {
+		return nil, err
+	}
+	opts.Incremental = false
+	return full.Verify(opts), nil
+}
+
+// AuditLedger verifies a ledger file in full from block 0, whatever
+// checkpoints it holds. The file is opened read-only.
+func AuditLedger(path string, opts VerifyOptions) (*VerifyReport, error) {
+	l, err := OpenLedgerReadOnly(path)
+	if err != nil {
+		return nil, err
+	}
+	opts.Incremental = false
+	return l.Verify(opts), nil
+}
@@ -0,0 +1,128 @@
+package blockchain
+
+import (
+	"blockci-q/pkg/utils"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LogStatus is the outcome of rehashing the log a block references
+type LogStatus string
+
+const (
+	LogOK       LogStatus = "ok"       // file exists and matches LogHash
+	LogMissing  LogStatus = "missing"  // file does not exist
+	LogModified LogStatus = "modified" // file exists but its hash differs
+	LogNone     LogStatus = "no_log"   // block references no log
+)
+
+// LogCheck is the per-block result of a deep verification
+type LogCheck struct {
+	Index      int       `json:"index"`
+	Stage      string    `json:"stage"`
+	Step       string    `json:"step"`
+	LogPath    string    `json:"logPath"`
+	LogHash    string    `json:"logHash"`
+	ActualHash string    `json:"actualHash,omitempty"`
+	Status     LogStatus `json:"status"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// DeepReport is the result of DeepVerify
+type DeepReport struct {
+	OK       bool          `json:"ok"`
+	Chain    *VerifyReport `json:"chain"` // chain verification of the same ledger
+	Blocks   []LogCheck    `json:"blocks"`
+	Missing  int           `json:"missing"`
+	Modified int           `json:"modified"`
+	Orphans  []string      `json:"orphans"` // files under LogDir no block references
+}
+
+// DeepOptions configures DeepVerify
+type DeepOptions struct {
+	BaseDir string        // relative log paths are resolved against it (default: working dir)
+	LogDir  string        // when set, scanned for orphaned log files
+	Verify  VerifyOptions // options of the chain verification
+}
+
+// DeepVerify verifies the chain and then rehashes every referenced log file
+// with utils.HashFile, reporting missing, modified and orphaned logs.
+func (l *Ledger) DeepVerify(opts DeepOptions) *DeepReport {
+	report := &DeepReport{Chain: l.Verify(opts.Verify), Blocks: []LogCheck{}, Orphans: []string{}}
+
+	l.mu.Lock()
+	blocks := append([]*Block(nil), l.Blocks...)
+	l.mu.Unlock()
+
+	referenced := make(map[string]bool)
+	for _, blk := range blocks {
+		check := LogCheck{
+			Index:   blk.Index,
+			Stage:   blk.Stage,
+			Step:    blk.Step,
+			LogPath: blk.LogPath,
+			LogHash: blk.LogHash,
+		}
+		if blk.LogPath == "" {
+			check.Status = LogNone
+			report.Blocks = append(report.Blocks, check)
+			continue
+		}
+
+		path := resolvePath(opts.BaseDir, blk.LogPath)
+		referenced[path] = true
+
+		actual, err := utils.HashFile(path)
+		switch {
+		case os.IsNotExist(err):
+			check.Status = LogMissing
+			report.Missing++
+		case err != nil:
+			check.Status = LogMissing
+			check.Error = err.Error()
+			report.Missing++
+		case actual != blk.LogHash:
+			check.Status = LogModified
+			check.ActualHash = actual
+			report.Modified++
+		default:
+			check.Status = LogOK
+		}
+		report.Blocks = append(report.Blocks, check)
+	}
+
+	if opts.LogDir != "" {
+		root := resolvePath(opts.BaseDir, opts.LogDir)
+		_ = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return nil
+			}
+			// in-flight uploads of the content store are not logs yet
+			if strings.HasPrefix(d.Name(), "upload-") {
+				return nil
+			}
+			if !referenced[path] {
+				report.Orphans = append(report.Orphans, path)
+			}
+			return nil
+		})
+	}
+
+	// orphans are reported but do not fail the report: logs of rejected
+	// results are legitimately stored without a block
+	report.OK = report.Chain.OK && report.Missing == 0 && report.Modified == 0
+	return report
+}
+
+// resolvePath makes a log path absolute so the same file always compares equal
+func resolvePath(baseDir, path string) string {
+	if !filepath.IsAbs(path) && baseDir != "" {
+		path = filepath.Join(baseDir, path)
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return filepath.Clean(path)
+}
@@ -0,0 +1,89 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"sort"
+)
+
+// LedgerDiff describes where two copies of a ledger diverge
+type LedgerDiff struct {
+	LeftBlocks  int `json:"leftBlocks"`
+	RightBlocks int `json:"rightBlocks"`
+	Common      int `json:"common"` // leading blocks equal in every field
+	// Divergence is the position of the first block that differs or exists
+	// on one side only; -1 when both ledgers hold the same blocks
+	Divergence int      `json:"divergence"`
+	Left       *Block   `json:"left,omitempty"`   // left block at Divergence
+	Right      *Block   `json:"right,omitempty"`  // right block at Divergence
+	Fields     []string `json:"fields,omitempty"` // JSON fields that differ between Left and Right
+}
+
+// Identical reports whether both ledgers hold the same blocks
+func (d *LedgerDiff) Identical() bool {
+	return d.Divergence < 0
+}
+
+// DiffLedgers compares two ledgers block by block and reports the first
+// position where they diverge. A ledger that only extends the other
+// diverges where the shorter one ends.
+func DiffLedgers(left, right *Ledger) *LedgerDiff {
+	left.mu.Lock()
+	defer left.mu.Unlock()
+	if right != left {
+		right.mu.Lock()
+		defer right.mu.Unlock()
+	}
+
+	d := &LedgerDiff{LeftBlocks: len(left.Blocks), RightBlocks: len(right.Blocks), Divergence: -1}
+	// compare whole blocks: a rewritten block may keep its stored hash
+	for d.Common < len(left.Blocks) && d.Common < len(right.Blocks) &&
+		sameBlock(left.Blocks[d.Common], right.Blocks[d.Common]) {
+		d.Common++
+	}
+	if d.Common == len(left.Blocks) && d.Common == len(right.Blocks) {
+		return d
+	}
+
+	d.Divergence = d.Common
+	if d.Common < len(left.Blocks) {
+		d.Left = left.Blocks[d.Common]
+	}
+	if d.Common < len(right.Blocks) {
+		d.Right = right.Blocks[d.Common]
+	}
+	if d.Left != nil && d.Right != nil {
+		d.Fields = diffFields(d.Left, d.Right)
+	}
+	return d
+}
+
+func sameBlock(a, b *Block) bool {
+	da, errA := json.Marshal(a)
+	db, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(da, db)
+}
+
+// diffFields lists the JSON fields whose values differ between two blocks
+func diffFields(a, b *Block) []string {
+	var ma, mb map[string]interface{}
+	da, _ := json.Marshal(a)
+	db, _ := json.Marshal(b)
+	json.Unmarshal(da, &ma)
+	json.Unmarshal(db, &mb)
+
+	var fields []string
+	for k, va := range ma {
+		if !reflect.DeepEqual(va, mb[k]) {
+			fields = append(fields, k)
+		}
+	}
+	for k := range mb {
+		if _, ok := ma[k]; !ok {
+			fields = append(fields, k)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
@@ -0,0 +1,325 @@
+package blockchain
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// FileStore is the JSON-lines LedgerStore: one framed record per line in a
+// single file (see record.go), plus a sidecar listing checkpoint offsets so
+// reads can start at a checkpoint instead of the beginning of the file
+type FileStore struct {
+	mu          sync.Mutex
+	path        string
+	head        *Block
+	checkpoints []checkpointMark // from the sidecar, oldest first
+	readOnly    bool             // see OpenFileStoreReadOnly
+
+	// Recovered is set when opening repaired a torn tail left by a crash
+	Recovered *Recovery
+	// Torn is set when a read-only open found a torn tail and left it in place
+	Torn *Recovery
+}
+
+// checkpointMark locates a checkpoint record in the ledger file
+type checkpointMark struct {
+	Offset int64
+	Index  int
+	Hash   string
+}
+
+// OpenFileStore opens (or creates) a JSON-lines ledger file. The file is
+// scanned from its last checkpoint to find the head and repair a torn tail.
+func OpenFileStore(path string) (*FileStore, error) {
+	return openFileStore(path, false)
+}
+
+// OpenFileStoreReadOnly opens an existing ledger file without writing to
+// it: a torn tail is reported in Torn instead of repaired, and Append fails.
+// Blocks are read up to the torn tail.
+func OpenFileStoreReadOnly(path string) (*FileStore, error) {
+	return openFileStore(path, true)
+}
+
+func openFileStore(path string, readOnly bool) (*FileStore, error) {
+	fs := &FileStore{path: path, readOnly: readOnly}
+	if _, err := os.Stat(path); os.IsNotExist(err) && !readOnly {
+		if err := writeFileSync(path, nil); err != nil {
+			return nil, err
+		}
+		return fs, syncDir(path)
+	}
+	fs.checkpoints = readCheckpointMarks(checkpointIndexPath(path))
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	offset := int64(0)
+	if mark, ok := fs.lastValidMark(f); ok {
+		offset = mark.Offset
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	torn, err := readRecords(f, offset, func(blk *Block) bool {
+		fs.head = blk
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case torn != nil && readOnly:
+		if len(torn.Data) > 0 {
+			fs.Torn = &Recovery{Offset: torn.Offset, Bytes: len(torn.Data), Reason: torn.Err.Error()}
+		}
+	case torn != nil:
+		if fs.Recovered, err = repairTail(path, torn); err != nil {
+			return nil, fmt.Errorf("repair torn ledger tail: %w", err)
+		}
+	}
+	return fs, nil
+}
+
+// checkpointIndexPath is the sidecar file listing "offset index hash" of
+// every checkpoint written to the ledger file. It only speeds up reads;
+// a mark is checked against the record it points at before use.
+func checkpointIndexPath(ledgerPath string) string {
+	return ledgerPath + ".checkpoints"
+}
+
+func readCheckpointMarks(indexPath string) []checkpointMark {
+	f, err := os.Open(indexPath)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var marks []checkpointMark
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 3 {
+			continue
+		}
+		off, err1 := strconv.ParseInt(fields[0], 10, 64)
+		idx, err2 := strconv.Atoi(fields[1])
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		marks = append(marks, checkpointMark{Offset: off, Index: idx, Hash: fields[2]})
+	}
+	return marks
+}
+
+// checkMark reports whether the record at mark is the checkpoint it names
+func checkMark(f *os.File, mark checkpointMark) (*Block, bool) {
+	if _, err := f.Seek(mark.Offset, io.SeekStart); err != nil {
+		return nil, false
+	}
+	line, err := bufio.NewReader(f).ReadBytes('\n')
+	if err != nil {
+		return nil, false
+	}
+	blk, err := decodeRecord(line)
+	if err != nil || blk.Type != BlockTypeCheckpoint || blk.Index != mark.Index || blk.Hash != mark.Hash {
+		return nil, false
+	}
+	return blk, true
+}
+
+// lastValidMark returns the last mark that still points at its checkpoint
+func (fs *FileStore) lastValidMark(f *os.File) (checkpointMark, bool) {
+	return fs.markBefore(f, int(^uint(0)>>1))
+}
+
+// markBefore returns the last valid mark with Index <= index
+func (fs *FileStore) markBefore(f *os.File, index int) (checkpointMark, bool) {
+	for i := len(fs.checkpoints) - 1; i >= 0; i-- {
+		mark := fs.checkpoints[i]
+		if mark.Index > index {
+			continue
+		}
+		if _, ok := checkMark(f, mark); ok {
+			return mark, true
+		}
+	}
+	return checkpointMark{}, false
+}
+
+// Append writes one framed record with a single write and fsyncs it. A
+// failed write is truncated away so no partial record stays behind.
+func (fs *FileStore) Append(b *Block) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.readOnly {
+		return fmt.Errorf("ledger file %s is open read-only", fs.path)
+	}
+
+	line, err := encodeRecord(b)
+	if err != nil {
+		return fmt.Errorf("encode block: %w", err)
+	}
+
+	f, err := os.OpenFile(fs.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("open ledger file: %w", err)
+	}
+	defer f.Close()
+
+	offset, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return fmt.Errorf("seek ledger file: %w", err)
+	}
+	if _, err := f.Write(line); err != nil {
+		f.Truncate(offset)
+		return fmt.Errorf("write ledger file: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Truncate(offset)
+		return fmt.Errorf("sync ledger file: %w", err)
+	}
+	fs.head = b
+
+	// the record is durable; the sidecar only speeds up reads, so a lost
+	// mark costs a longer scan on the next open but must not fail the append
+	if b.Type == BlockTypeCheckpoint {
+		mark := checkpointMark{Offset: offset, Index: b.Index, Hash: b.Hash}
+		fs.checkpoints = append(fs.checkpoints, mark)
+		if err := appendCheckpointMark(checkpointIndexPath(fs.path), mark); err != nil {
+			fmt.Printf("⚠️ checkpoint %d not added to the ledger checkpoint index: %v\n", b.Index, err)
+		}
+	}
+	return nil
+}
+
+func appendCheckpointMark(indexPath string, mark checkpointMark) error {
+	f, err := os.OpenFile(indexPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("open checkpoint index: %w", err)
+	}
+	defer f.Close()
+	_, err = fmt.Fprintf(f, "%d %d %s\n", mark.Offset, mark.Index, mark.Hash)
+	return err
+}
+
+// Iterate reads from the last checkpoint at or before from, skipping
+// earlier blocks. A record torn by a concurrent append ends the iteration.
+func (fs *FileStore) Iterate(from int, fn func(*Block) bool) error {
+	f, err := os.Open(fs.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fs.mu.Lock()
+	mark, _ := fs.markBefore(f, from)
+	fs.mu.Unlock()
+
+	if _, err := f.Seek(mark.Offset, io.SeekStart); err != nil {
+		return err
+	}
+	_, err = readRecords(f, mark.Offset, func(blk *Block) bool {
+		if blk.Index < from {
+			return true
+		}
+		return fn(blk)
+	})
+	return err
+}
+
+// Get scans from the nearest checkpoint for the block with index
+func (fs *FileStore) Get(index int) (*Block, error) {
+	var found *Block
+	err := fs.Iterate(index, func(blk *Block) bool {
+		if blk.Index == index {
+			found = blk
+		}
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+	if found == nil {
+		return nil, ErrBlockNotFound
+	}
+	return found, nil
+}
+
+// GetByHash scans the whole file for the block with hash
+func (fs *FileStore) GetByHash(hash string) (*Block, error) {
+	var found *Block
+	err := fs.Iterate(0, func(blk *Block) bool {
+		if blk.Hash == hash {
+			found = blk
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if found == nil {
+		return nil, ErrBlockNotFound
+	}
+	return found, nil
+}
+
+// Head returns the last block
+func (fs *FileStore) Head() (*Block, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.head, nil
+}
+
+// LastCheckpoint returns the checkpoint of the last valid sidecar mark
+func (fs *FileStore) LastCheckpoint() (*Block, error) {
+	f, err := os.Open(fs.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	for i := len(fs.checkpoints) - 1; i >= 0; i-- {
+		if blk, ok := checkMark(f, fs.checkpoints[i]); ok {
+			return blk, nil
+		}
+	}
+	return nil, nil
+}
+
+// Recovery returns the torn tail repaired when opening, if any
+func (fs *FileStore) Recovery() *Recovery {
+	return fs.Recovered
+}
+
+// TornTail returns the torn tail a read-only open left in place, if any
+func (fs *FileStore) TornTail() *Recovery {
+	return fs.Torn
+}
+
+// Close releases nothing: the file is opened per operation
+func (fs *FileStore) Close() error {
+	return nil
+}
+
+// syncDir makes a new file's directory entry durable
+func syncDir(path string) error {
+	d, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -4,113 +4,244 @@ import (
 	"bytes"
 	"crypto/ed25519"
 	"encoding/hex"
-	"encoding/json"
 	"fmt"
-	"os"
 	"sync"
 )
 
 type Ledger struct {
 	mu     sync.Mutex
 	Blocks []*Block
-	path   string
+	store  LedgerStore
+
+	// Recovered is set when opening repaired a torn tail left by a crash
+	Recovered *Recovery
+	// Torn is set when a read-only open found a torn tail, see OpenLedgerReadOnly
+	Torn *Recovery
+
+	// CheckpointEvery appends a checkpoint after that many blocks (0 = never).
+	// A failed checkpoint append is retried after the next block.
+	CheckpointEvery int
+	// OnCheckpointError, when set, is told why an automatic checkpoint
+	// failed; the block that triggered it is stored regardless. It is
+	// called with the ledger locked and must not use the ledger.
+	OnCheckpointError func(index int, err error)
+
+	partial         bool        // Blocks start at a checkpoint, see NewLedgerFromCheckpoint
+	state           *ChainState // chain state after the last block
+	sinceCheckpoint int         // blocks appended since the last checkpoint
+	index           *queryIndex // every block, built by the first query, see indexLocked
 }
 
 // OpenLedger loads an existing ledger file or creates a new in-memory ledger.
-// Ledger file format: JSON lines (one JSON block per line).
+// Ledger file format: one checksummed JSON block per line, see record.go.
+// A torn last record is quarantined, see Recovered.
 func OpenLedger(path string) (*Ledger, error) {
-	l := &Ledger{
-		Blocks: make([]*Block, 0),
-		path:   path,
+	fs, err := OpenFileStore(path)
+	if err != nil {
+		return nil, err
 	}
+	return NewLedger(fs)
+}
 
-	// If file missing, create empty file
-	if _, err := os.Stat(path); os.IsNotExist(err) {
-		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0644)
-		if err != nil {
-			return nil, err
-		}
-		_ = f.Close()
-		return l, nil
+// OpenLedgerReadOnly loads an existing ledger file without writing to it,
+// for audits: a torn tail is left in place and reported in Torn, and every
+// append fails.
+func OpenLedgerReadOnly(path string) (*Ledger, error) {
+	fs, err := OpenFileStoreReadOnly(path)
+	if err != nil {
+		return nil, err
 	}
+	return NewLedger(fs)
+}
 
-	// Read file and decode JSON lines
-	data, err := os.ReadFile(path)
-	if err != nil {
+// NewLedger loads every block of store into memory
+func NewLedger(store LedgerStore) (*Ledger, error) {
+	l := &Ledger{
+		Blocks: make([]*Block, 0),
+		store:  store,
+		state:  newChainState(),
+	}
+	l.trackRecovery(store)
+	if err := l.load(0); err != nil {
 		return nil, err
 	}
-	if len(data) == 0 {
-		return l, nil
+	return l, nil
+}
+
+// trackRecovery copies the torn tail the store repaired or found
+func (l *Ledger) trackRecovery(store LedgerStore) {
+	if r, ok := store.(interface{ Recovery() *Recovery }); ok {
+		l.Recovered = r.Recovery()
+	}
+	if r, ok := store.(interface{ TornTail() *Recovery }); ok {
+		l.Torn = r.TornTail()
 	}
+}
+
+// load reads the blocks of the store from index into memory and rebuilds
+// the chain state (a checkpoint first block seeds it)
+func (l *Ledger) load(from int) error {
+	l.state = newChainState()
+	return l.store.Iterate(from, func(blk *Block) bool {
+		l.track(blk)
+		l.Blocks = append(l.Blocks, blk)
+		return true
+	})
+}
+
+// Store returns the backend the ledger persists to
+func (l *Ledger) Store() LedgerStore {
+	return l.store
+}
 
-	dec := json.NewDecoder(bytes.NewReader(data))
-	for dec.More() {
-		var blk Block
-		if err := dec.Decode(&blk); err != nil {
-			return nil, fmt.Errorf("failed to decode ledger entry: %w", err)
+// track advances the chain state and checkpoint counter past a block
+func (l *Ledger) track(blk *Block) {
+	if blk.Type == BlockTypeCheckpoint {
+		if len(l.Blocks) == 0 && blk.Checkpoint != nil {
+			l.state = blk.Checkpoint.clone()
 		}
-		l.Blocks = append(l.Blocks, &blk)
+		l.sinceCheckpoint = 0
+		return
 	}
-	return l, nil
+	l.state.apply(blk)
+	l.sinceCheckpoint++
 }
 
 // AppendBlocks appends a block into the ledger, signs it with server's priv key,
-// stores hex pubkey, persists to disk (JSONL), and keeps it in memory.
+// stores hex pubkey, persists it to the store, and keeps it in memory.
+// After a key rotation only the announced key may sign.
 func (l *Ledger) AppendBlocks(b *Block, priv ed25519.PrivateKey, pub ed25519.PublicKey) error {
 	l.mu.Lock()
 	defer l.mu.Unlock()
+	return l.appendLocked(b, priv, pub)
+}
+
+func (l *Ledger) appendLocked(b *Block, priv ed25519.PrivateKey, pub ed25519.PublicKey) error {
+	if signer := l.rotatedSigner(); signer != "" && signer != hex.EncodeToString(pub) {
+		return fmt.Errorf("ledger key was rotated to %s, cannot sign with %s", signer, hex.EncodeToString(pub))
+	}
+	if len(priv) == 0 {
+		return fmt.Errorf("private key is empty, cannot sign block")
+	}
+
+	// work on a copy: the caller's block only changes once the record is durable
+	rec := *b
 
 	// recompute and set hash to be sure block canonical fields match
-	h, err := b.ComputeHash()
+	h, err := rec.ComputeHash()
 	if err != nil {
 		return fmt.Errorf("cannot recompute block hash: %w", err)
 	}
-	b.Hash = h
+	rec.Hash = h
 
 	// prevHash check
 	if len(l.Blocks) > 0 {
 		last := l.Blocks[len(l.Blocks)-1]
-		if b.PrevHash != last.Hash {
-			return fmt.Errorf("prevHash mismatch: expected %s, got %s", last.Hash, b.PrevHash)
+		if rec.PrevHash != last.Hash {
+			return fmt.Errorf("prevHash mismatch: expected %s, got %s", last.Hash, rec.PrevHash)
 		}
 	}
 
 	// Sign the block hash with server private key and set pubkey
-	if len(priv) == 0 {
-		return fmt.Errorf("private key is empty, cannot sign block")
+	rec.Signature = hex.EncodeToString(ed25519.Sign(priv, []byte(rec.Hash)))
+	rec.PubKey = hex.EncodeToString(pub)
+
+	if err := l.store.Append(&rec); err != nil {
+		return err
 	}
-	sig := ed25519.Sign(priv, []byte(b.Hash))
-	b.Signature = hex.EncodeToString(sig)
-	b.PubKey = hex.EncodeToString(pub)
 
-	// Append to file (create if missing)
-	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	// Push into memory
+	*b = rec
+	l.Blocks = append(l.Blocks, b)
+	l.track(b)
+	if l.index != nil {
+		l.index.add(b)
+	}
+
+	if b.Type != BlockTypeCheckpoint && l.CheckpointEvery > 0 && l.sinceCheckpoint >= l.CheckpointEvery {
+		cp, err := NewCheckpointBlock(b.Index+1, b.Hash, l.state)
+		if err == nil {
+			err = l.appendLocked(cp, priv, pub)
+		}
+		if err != nil && l.OnCheckpointError != nil {
+			l.OnCheckpointError(b.Index+1, err)
+		}
+	}
+	return nil
+}
+
+// RotateKey appends a key_rotation block announcing newPub, signed by the
+// current key. Every later block must be signed with newPub.
+func (l *Ledger) RotateKey(priv ed25519.PrivateKey, pub ed25519.PublicKey, newPub ed25519.PublicKey) (*Block, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if bytes.Equal(pub, newPub) {
+		return nil, fmt.Errorf("new key equals the current key")
+	}
+	blk, err := NewKeyRotationBlock(l.nextIndex(), l.lastHash(), newPub)
 	if err != nil {
-		return fmt.Errorf("open ledger file: %w", err)
+		return nil, err
+	}
+	if err := l.appendLocked(blk, priv, pub); err != nil {
+		return nil, err
 	}
-	defer f.Close()
+	return blk, nil
+}
+
+// Checkpoint appends a checkpoint block now, signed with the current key
+func (l *Ledger) Checkpoint(priv ed25519.PrivateKey, pub ed25519.PublicKey) (*Block, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
 
-	enc := json.NewEncoder(f)
-	if err := enc.Encode(b); err != nil {
-		return fmt.Errorf("write ledger file: %w", err)
+	cp, err := NewCheckpointBlock(l.nextIndex(), l.lastHash(), l.state)
+	if err != nil {
+		return nil, err
 	}
+	if err := l.appendLocked(cp, priv, pub); err != nil {
+		return nil, err
+	}
+	return cp, nil
+}
 
-	// Push into memory
-	l.Blocks = append(l.Blocks, b)
-	return nil
+// RotatedSigner returns the hex key every new block must be signed with, or
+// "" when the key was never rotated
+func (l *Ledger) RotatedSigner() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rotatedSigner()
+}
+
+// rotatedSigner returns the hex key announced by the last key_rotation
+// block, or "" when the key was never rotated
+func (l *Ledger) rotatedSigner() string {
+	return l.state.Signer
 }
 
 // NextIndex returns the next block index (not locking for heavy concurrency)
 func (l *Ledger) NextIndex() int {
 	l.mu.Lock()
 	defer l.mu.Unlock()
-	return len(l.Blocks)
+	return l.nextIndex()
 }
 
 // LastHash returns the last block hash (or empty if none)
 func (l *Ledger) LastHash() string {
 	l.mu.Lock()
 	defer l.mu.Unlock()
+	return l.lastHash()
+}
+
+// nextIndex continues the last block's index, which also holds for a ledger
+// opened from a checkpoint. Caller must hold l.mu.
+func (l *Ledger) nextIndex() int {
+	if len(l.Blocks) == 0 {
+		return 0
+	}
+	return l.Blocks[len(l.Blocks)-1].Index + 1
+}
+
+func (l *Ledger) lastHash() string {
 	if len(l.Blocks) == 0 {
 		return ""
 	}
@@ -0,0 +1,261 @@
+package blockchain
+
+import (
+	"blockci-q/internal/security"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// The Merkle tree over a run's log hashes follows RFC 6962: leaves and
+// interior nodes are hashed with distinct prefixes, and an unbalanced tree
+// splits at the largest power of two, so no two leaf lists share a root.
+
+func merkleLeaf(logHash string) []byte {
+	sum := sha256.Sum256(append([]byte{0x00}, logHash...))
+	return sum[:]
+}
+
+func merkleNode(left, right []byte) []byte {
+	data := make([]byte, 0, 1+len(left)+len(right))
+	data = append(append(append(data, 0x01), left...), right...)
+	sum := sha256.Sum256(data)
+	return sum[:]
+}
+
+// splitPoint returns the largest power of two smaller than n (n > 1)
+func splitPoint(n int) int {
+	k := 1
+	for k<<1 < n {
+		k <<= 1
+	}
+	return k
+}
+
+func merkleRoot(leaves [][]byte) []byte {
+	switch len(leaves) {
+	case 0:
+		sum := sha256.Sum256(nil)
+		return sum[:]
+	case 1:
+		return leaves[0]
+	}
+	k := splitPoint(len(leaves))
+	return merkleNode(merkleRoot(leaves[:k]), merkleRoot(leaves[k:]))
+}
+
+func merklePath(leaves [][]byte, m int) [][]byte {
+	if len(leaves) <= 1 {
+		return nil
+	}
+	k := splitPoint(len(leaves))
+	if m < k {
+		return append(merklePath(leaves[:k], m), merkleRoot(leaves[k:]))
+	}
+	return append(merklePath(leaves[k:], m-k), merkleRoot(leaves[:k]))
+}
+
+func merkleLeaves(logHashes []string) [][]byte {
+	leaves := make([][]byte, len(logHashes))
+	for i, h := range logHashes {
+		leaves[i] = merkleLeaf(h)
+	}
+	return leaves
+}
+
+// MerkleRoot returns the hex Merkle root over log hashes, in order
+func MerkleRoot(logHashes []string) string {
+	return hex.EncodeToString(merkleRoot(merkleLeaves(logHashes)))
+}
+
+// MerklePath returns the hex audit path proving logHashes[index] is in the tree
+func MerklePath(logHashes []string, index int) ([]string, error) {
+	if index < 0 || index >= len(logHashes) {
+		return nil, fmt.Errorf("leaf %d out of range (tree size %d)", index, len(logHashes))
+	}
+	path := merklePath(merkleLeaves(logHashes), index)
+	out := make([]string, len(path))
+	for i, p := range path {
+		out[i] = hex.EncodeToString(p)
+	}
+	return out, nil
+}
+
+// VerifyMerklePath checks that logHash is leaf index of a tree of size
+// leaves with the given hex root
+func VerifyMerklePath(logHash string, index, size int, path []string, root string) bool {
+	if index < 0 || index >= size {
+		return false
+	}
+	want, err := hex.DecodeString(root)
+	if err != nil {
+		return false
+	}
+
+	// RFC 9162 section 2.1.3.2
+	fn, sn := index, size-1
+	r := merkleLeaf(logHash)
+	for _, p := range path {
+		sibling, err := hex.DecodeString(p)
+		if err != nil || sn == 0 {
+			return false
+		}
+		if fn&1 == 1 || fn == sn {
+			r = merkleNode(sibling, r)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			r = merkleNode(r, sibling)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	return sn == 0 && bytes.Equal(r, want)
+}
+
+// InclusionProof shows that one log belongs to a pipeline run: the Merkle
+// path from the log hash to the root, and the signed run_summary block that
+// commits to that root
+type InclusionProof struct {
+	RunID     string   `json:"runId"`
+	LogHash   string   `json:"logHash"`
+	LeafIndex int      `json:"leafIndex"`
+	TreeSize  int      `json:"treeSize"`
+	Path      []string `json:"path"`
+	Root      string   `json:"root"`
+	Summary   *Block   `json:"summary"`
+}
+
+// RunLogHashes returns the log hashes of a run's step blocks in ledger order
+func (l *Ledger) RunLogHashes(runID string) ([]string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	hashes := []string{}
+	err := l.eachBlock(func(blk *Block) bool {
+		if blk.Type == BlockTypeStep && blk.RunID == runID {
+			hashes = append(hashes, blk.LogHash)
+		}
+		return true
+	})
+	return hashes, err
+}
+
+// RunSummary returns the run_summary block of a run, or nil
+func (l *Ledger) RunSummary(runID string) (*Block, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var summary *Block
+	err := l.eachBlock(func(blk *Block) bool {
+		if blk.Type == BlockTypeRunSummary && blk.RunID == runID {
+			summary = blk
+			return false
+		}
+		return true
+	})
+	return summary, err
+}
+
+// AppendRunSummary seals a run: it appends a run_summary block carrying the
+// Merkle root over the log hashes of every step block of the run so far
+func (l *Ledger) AppendRunSummary(runID, pipelineHash, outcome string, priv ed25519.PrivateKey, pub ed25519.PublicKey) (*Block, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	blk, err := NewRunSummaryBlock(l.nextIndex(), l.lastHash(), runID, l.state.OpenRuns[runID])
+	if err != nil {
+		return nil, err
+	}
+	blk.PipelineHash = pipelineHash
+	blk.Outcome = outcome
+	if err := l.appendLocked(blk, priv, pub); err != nil {
+		return nil, err
+	}
+	return blk, nil
+}
+
+// ProveLog builds an inclusion proof for a log of a sealed run
+func (l *Ledger) ProveLog(runID, logHash string) (*InclusionProof, error) {
+	// the tree covers the run's step blocks written before its summary
+	l.mu.Lock()
+	var summary *Block
+	hashes := []string{}
+	err := l.eachBlock(func(blk *Block) bool {
+		switch {
+		case blk.Type == BlockTypeRunSummary && blk.RunID == runID:
+			summary = blk
+			return false
+		case blk.Type == BlockTypeStep && blk.RunID == runID:
+			hashes = append(hashes, blk.LogHash)
+		}
+		return true
+	})
+	l.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if summary == nil {
+		return nil, fmt.Errorf("run %s has no summary block yet", runID)
+	}
+
+	index := -1
+	for i, h := range hashes {
+		if h == logHash {
+			index = i
+			break
+		}
+	}
+	if index == -1 {
+		return nil, fmt.Errorf("log %s is not part of run %s", logHash, runID)
+	}
+	path, err := MerklePath(hashes, index)
+	if err != nil {
+		return nil, err
+	}
+	return &InclusionProof{
+		RunID:     runID,
+		LogHash:   logHash,
+		LeafIndex: index,
+		TreeSize:  len(hashes),
+		Path:      path,
+		Root:      summary.MerkleRoot,
+		Summary:   summary,
+	}, nil
+}
+
+// VerifyInclusion checks a proof on its own: the Merkle path leads to the
+// root, the summary block commits to that root for the run and carries a
+// valid server signature, pinned by trust when given
+func VerifyInclusion(proof *InclusionProof, trust *security.TrustStore) error {
+	sum := proof.Summary
+	if sum == nil || sum.Type != BlockTypeRunSummary {
+		return fmt.Errorf("proof carries no run summary block")
+	}
+	if sum.RunID != proof.RunID || sum.MerkleRoot != proof.Root || sum.LeafCount != proof.TreeSize {
+		return fmt.Errorf("summary block does not match the proof")
+	}
+	if !VerifyMerklePath(proof.LogHash, proof.LeafIndex, proof.TreeSize, proof.Path, proof.Root) {
+		return fmt.Errorf("merkle path does not lead to root %s", proof.Root)
+	}
+	if h, err := sum.ComputeHash(); err != nil || h != sum.Hash {
+		return fmt.Errorf("summary block hash mismatch")
+	}
+	ok, err := security.VerifySignatureFromHex(sum.PubKey, []byte(sum.Hash), sum.Signature)
+	if err != nil || !ok {
+		return fmt.Errorf("summary block signature verification failed")
+	}
+	if trust != nil {
+		ts, err := time.Parse(time.RFC3339, sum.Timestamp)
+		if err != nil {
+			return fmt.Errorf("invalid summary timestamp %q", sum.Timestamp)
+		}
+		if err := trust.Check(sum.PubKey, ts); err != nil {
+			return fmt.Errorf("summary block signer %s: %w", sum.PubKey, err)
+		}
+	}
+	return nil
+}
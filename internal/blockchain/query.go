@@ -0,0 +1,289 @@
+package blockchain
+
+import (
+	"sort"
+	"time"
+)
+
+// Query page sizes
+const (
+	DefaultQueryLimit = 100
+	MaxQueryLimit     = 1000
+)
+
+// blockMeta is what queries filter on, kept for every block of the ledger
+// so filtering never reads the store
+type blockMeta struct {
+	Index   int
+	AgentID string
+	Stage   string
+	Step    string
+	RunID   string
+	At      time.Time // zero when the timestamp does not parse
+}
+
+// queryIndex holds in-memory indices over every block of a ledger, built
+// on the first query and extended on append
+type queryIndex struct {
+	metas   []blockMeta
+	byHash  map[string]int // hash -> position in metas
+	byJob   map[string]int // job ID -> position of its step block
+	byAgent map[string][]int
+	byStage map[string][]int
+	byStep  map[string][]int
+	byRun   map[string][]int
+}
+
+func newQueryIndex() *queryIndex {
+	return &queryIndex{
+		byHash:  make(map[string]int),
+		byJob:   make(map[string]int),
+		byAgent: make(map[string][]int),
+		byStage: make(map[string][]int),
+		byStep:  make(map[string][]int),
+		byRun:   make(map[string][]int),
+	}
+}
+
+// add indexes the block after the last one
+func (qi *queryIndex) add(blk *Block) {
+	at, _ := time.Parse(time.RFC3339, blk.Timestamp)
+	pos := len(qi.metas)
+	qi.metas = append(qi.metas, blockMeta{
+		Index: blk.Index, AgentID: blk.AgentID, Stage: blk.Stage, Step: blk.Step, RunID: blk.RunID, At: at,
+	})
+	qi.byHash[blk.Hash] = pos
+	if blk.JobID != "" {
+		qi.byJob[blk.JobID] = pos
+	}
+	if blk.AgentID != "" {
+		qi.byAgent[blk.AgentID] = append(qi.byAgent[blk.AgentID], pos)
+	}
+	if blk.Stage != "" {
+		qi.byStage[blk.Stage] = append(qi.byStage[blk.Stage], pos)
+	}
+	if blk.Step != "" {
+		qi.byStep[blk.Step] = append(qi.byStep[blk.Step], pos)
+	}
+	if blk.RunID != "" {
+		qi.byRun[blk.RunID] = append(qi.byRun[blk.RunID], pos)
+	}
+}
+
+// BlockQuery selects ledger blocks. Zero fields do not filter.
+type BlockQuery struct {
+	From    int // lowest block index
+	To      int // highest block index, inclusive (0 = no limit)
+	AgentID string
+	Stage   string
+	Step    string
+	RunIDs  []string  // any of these runs
+	Since   time.Time // block timestamp at or after
+	Until   time.Time // block timestamp at or before
+	Offset  int       // matches to skip
+	Limit   int       // page size, see DefaultQueryLimit / MaxQueryLimit
+}
+
+// BlockPage is one page of query results, in ledger order
+type BlockPage struct {
+	Blocks     []*Block `json:"blocks"`
+	Total      int      `json:"total"` // matches across all pages
+	Offset     int      `json:"offset"`
+	Limit      int      `json:"limit"`
+	NextOffset int      `json:"nextOffset,omitempty"` // 0 on the last page
+}
+
+func (q *BlockQuery) match(m blockMeta) bool {
+	if m.Index < q.From || (q.To > 0 && m.Index > q.To) {
+		return false
+	}
+	if (q.AgentID != "" && m.AgentID != q.AgentID) || (q.Stage != "" && m.Stage != q.Stage) ||
+		(q.Step != "" && m.Step != q.Step) {
+		return false
+	}
+	if len(q.RunIDs) > 0 {
+		found := false
+		for _, run := range q.RunIDs {
+			found = found || m.RunID == run
+		}
+		if !found {
+			return false
+		}
+	}
+	if !q.Since.IsZero() && (m.At.IsZero() || m.At.Before(q.Since)) {
+		return false
+	}
+	if !q.Until.IsZero() && (m.At.IsZero() || m.At.After(q.Until)) {
+		return false
+	}
+	return true
+}
+
+// candidates returns the positions a query has to look at: the smallest
+// posting list of its field filters, or the index range
+func (qi *queryIndex) candidates(q *BlockQuery) []int {
+	var best []int
+	narrowed := false
+	pick := func(list []int) {
+		if !narrowed || len(list) < len(best) {
+			best, narrowed = list, true
+		}
+	}
+	if q.AgentID != "" {
+		pick(qi.byAgent[q.AgentID])
+	}
+	if q.Stage != "" {
+		pick(qi.byStage[q.Stage])
+	}
+	if q.Step != "" {
+		pick(qi.byStep[q.Step])
+	}
+	if len(q.RunIDs) > 0 {
+		var runs []int
+		for _, run := range q.RunIDs {
+			runs = append(runs, qi.byRun[run]...)
+		}
+		sort.Ints(runs)
+		pick(runs)
+	}
+	if narrowed {
+		return best
+	}
+
+	lo := sort.Search(len(qi.metas), func(i int) bool { return qi.metas[i].Index >= q.From })
+	hi := len(qi.metas)
+	if q.To > 0 {
+		hi = sort.Search(len(qi.metas), func(i int) bool { return qi.metas[i].Index > q.To })
+	}
+	all := make([]int, 0, max(hi-lo, 0))
+	for pos := lo; pos < hi; pos++ {
+		all = append(all, pos)
+	}
+	return all
+}
+
+// Query returns one page of the blocks matching q
+func (l *Ledger) Query(q BlockQuery) (*BlockPage, error) {
+	if q.Limit <= 0 {
+		q.Limit = DefaultQueryLimit
+	}
+	q.Limit = min(q.Limit, MaxQueryLimit)
+	q.Offset = max(q.Offset, 0)
+
+	l.mu.Lock()
+	index, err := l.indexLocked()
+	if err != nil {
+		l.mu.Unlock()
+		return nil, err
+	}
+	var matches []int
+	for _, pos := range index.candidates(&q) {
+		if m := index.metas[pos]; q.match(m) {
+			matches = append(matches, m.Index)
+		}
+	}
+	l.mu.Unlock()
+
+	page := &BlockPage{Blocks: make([]*Block, 0), Total: len(matches), Offset: q.Offset, Limit: q.Limit}
+	if q.Offset >= len(matches) {
+		return page, nil
+	}
+	end := min(q.Offset+q.Limit, len(matches))
+	for _, index := range matches[q.Offset:end] {
+		blk, err := l.Block(index)
+		if err != nil {
+			return nil, err
+		}
+		page.Blocks = append(page.Blocks, blk)
+	}
+	if end < len(matches) {
+		page.NextOffset = end
+	}
+	return page, nil
+}
+
+// Block returns the block with index, from memory when loaded and from
+// the store for the blocks before the checkpoint a ledger was opened from
+func (l *Ledger) Block(index int) (*Block, error) {
+	l.mu.Lock()
+	n := len(l.Blocks)
+	if n == 0 || index < 0 || index > l.Blocks[n-1].Index {
+		l.mu.Unlock()
+		return nil, ErrBlockNotFound
+	}
+	if pos := index - l.Blocks[0].Index; pos >= 0 && pos < n && l.Blocks[pos].Index == index {
+		blk := l.Blocks[pos]
+		l.mu.Unlock()
+		return blk, nil
+	}
+	l.mu.Unlock()
+	return l.store.Get(index)
+}
+
+// BlockByHash returns the block with hash
+func (l *Ledger) BlockByHash(hash string) (*Block, error) {
+	l.mu.Lock()
+	qi, err := l.indexLocked()
+	if err != nil {
+		l.mu.Unlock()
+		return nil, err
+	}
+	pos, ok := qi.byHash[hash]
+	var index int
+	if ok {
+		index = qi.metas[pos].Index
+	}
+	l.mu.Unlock()
+	if !ok {
+		return nil, ErrBlockNotFound
+	}
+	return l.Block(index)
+}
+
+// JobBlock returns the step block recorded for a job
+func (l *Ledger) JobBlock(jobID string) (*Block, error) {
+	l.mu.Lock()
+	qi, err := l.indexLocked()
+	if err != nil {
+		l.mu.Unlock()
+		return nil, err
+	}
+	pos, ok := qi.byJob[jobID]
+	var index int
+	if ok {
+		index = qi.metas[pos].Index
+	}
+	l.mu.Unlock()
+	if !ok {
+		return nil, ErrBlockNotFound
+	}
+	return l.Block(index)
+}
+
+// indexLocked returns the query index, building it on first use so opening
+// a ledger from a checkpoint stays cheap. For such a ledger the first query
+// reads every block before the checkpoint from the store. Caller must hold l.mu.
+func (l *Ledger) indexLocked() (*queryIndex, error) {
+	if l.index != nil {
+		return l.index, nil
+	}
+	qi := newQueryIndex()
+	if l.partial && len(l.Blocks) > 0 {
+		first := l.Blocks[0].Index
+		err := l.store.Iterate(0, func(blk *Block) bool {
+			if blk.Index >= first {
+				return false
+			}
+			qi.add(blk)
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, blk := range l.Blocks {
+		qi.add(blk)
+	}
+	l.index = qi
+	return qi, nil
+}
@@ -0,0 +1,168 @@
+package blockchain
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"time"
+)
+
+// Ledger records are framed one per line as "<crc32c hex> <block json>\n",
+// so a torn or bit-flipped line is detected instead of decoded. Lines that
+// start with "{" are legacy unframed blocks and are still read.
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// ErrBadChecksum is returned for a framed record whose checksum does not match
+var ErrBadChecksum = errors.New("record checksum mismatch")
+
+func encodeRecord(b *Block) ([]byte, error) {
+	data, err := json.Marshal(b)
+	if err != nil {
+		return nil, err
+	}
+	line := fmt.Sprintf("%08x %s\n", crc32.Checksum(data, castagnoli), data)
+	return []byte(line), nil
+}
+
+func decodeRecord(line []byte) (*Block, error) {
+	line = bytes.TrimRight(line, "\r\n")
+	data := line
+	if !bytes.HasPrefix(line, []byte("{")) {
+		if len(line) < 10 || line[8] != ' ' {
+			return nil, fmt.Errorf("malformed record")
+		}
+		sum, err := hex.DecodeString(string(line[:8]))
+		if err != nil {
+			return nil, fmt.Errorf("malformed record checksum")
+		}
+		data = line[9:]
+		want := uint32(sum[0])<<24 | uint32(sum[1])<<16 | uint32(sum[2])<<8 | uint32(sum[3])
+		if crc32.Checksum(data, castagnoli) != want {
+			return nil, ErrBadChecksum
+		}
+	}
+	var blk Block
+	if err := json.Unmarshal(data, &blk); err != nil {
+		return nil, err
+	}
+	return &blk, nil
+}
+
+// tornTail is an unreadable end of a ledger file left by an interrupted write
+type tornTail struct {
+	Offset int64  // where the torn bytes start
+	Data   []byte // the torn bytes
+	Err    error  // why they could not be read
+}
+
+// readRecords decodes the records of r (starting at file offset base) and
+// calls fn for each until fn returns false. An unreadable or unterminated last line is returned as
+// a torn tail; an unreadable line followed by more data is a corruption
+// error, since dropping it would lose the blocks after it. A complete,
+// valid last record that only misses its newline is kept.
+func readRecords(r io.Reader, base int64, fn func(*Block) bool) (*tornTail, error) {
+	return scanRecords(r, base, func(blk *Block, _ int64, _ int) bool { return fn(blk) })
+}
+
+// scanRecords is readRecords also passing the file offset and length of
+// each record
+func scanRecords(r io.Reader, base int64, fn func(blk *Block, offset int64, n int) bool) (*tornTail, error) {
+	br := bufio.NewReader(r)
+	offset := base
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(line) == 0 {
+			if err == io.EOF {
+				return nil, nil
+			}
+			return nil, err
+		}
+		if len(bytes.TrimSpace(line)) == 0 {
+			offset += int64(len(line))
+			continue
+		}
+
+		blk, decodeErr := decodeRecord(line)
+		if decodeErr == nil {
+			if !fn(blk, offset, len(line)) {
+				return nil, nil
+			}
+			if err == io.EOF && line[len(line)-1] != '\n' {
+				// valid but unterminated: keep it, only the newline is missing
+				return &tornTail{Offset: offset + int64(len(line))}, nil
+			}
+			offset += int64(len(line))
+			continue
+		}
+
+		rest, _ := io.ReadAll(br)
+		if len(bytes.TrimSpace(rest)) > 0 {
+			return nil, fmt.Errorf("failed to decode ledger entry at offset %d: %w", offset, decodeErr)
+		}
+		return &tornTail{Offset: offset, Data: append(line, rest...), Err: decodeErr}, nil
+	}
+}
+
+// Recovery describes a torn tail repaired when opening a ledger, or found
+// and left in place by a read-only open (Quarantine is then empty)
+type Recovery struct {
+	Offset     int64  `json:"offset"`               // file offset the ledger was truncated at
+	Bytes      int    `json:"bytes"`                // size of the quarantined tail
+	Quarantine string `json:"quarantine,omitempty"` // file holding the torn bytes
+	Reason     string `json:"reason"`
+}
+
+// repairTail quarantines a torn tail into a side file and truncates the
+// ledger to its last complete record, or terminates a valid last record
+// that misses its newline
+func repairTail(path string, torn *tornTail) (*Recovery, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if len(torn.Data) == 0 {
+		if _, err := f.WriteAt([]byte("\n"), torn.Offset); err != nil {
+			return nil, err
+		}
+		return nil, f.Sync()
+	}
+
+	rec := &Recovery{
+		Offset:     torn.Offset,
+		Bytes:      len(torn.Data),
+		Quarantine: fmt.Sprintf("%s.torn-%d", path, time.Now().UnixNano()),
+		Reason:     torn.Err.Error(),
+	}
+	if err := writeFileSync(rec.Quarantine, torn.Data); err != nil {
+		return nil, fmt.Errorf("quarantine torn tail: %w", err)
+	}
+	if err := f.Truncate(torn.Offset); err != nil {
+		return nil, err
+	}
+	return rec, f.Sync()
+}
+
+func writeFileSync(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,498 @@
+package blockchain
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// DefaultSegmentBytes is the size a segment grows to before a new one starts
+const DefaultSegmentBytes = 64 << 20
+
+// indexEntrySize is the size of one index entry:
+// segment uint32 | offset uint64 | length uint32 | flags uint8 | index uint64 | hash [32]byte
+const indexEntrySize = 4 + 8 + 4 + 1 + 8 + 32
+
+const entryCheckpoint = 1 << 0 // flags: the block is a checkpoint
+
+// SegmentStore is a LedgerStore keeping framed records (see record.go) in
+// numbered segment files, with a fixed-size index entry per block so a
+// block is found by index or hash without scanning
+type SegmentStore struct {
+	mu       sync.Mutex
+	dir      string
+	entries  []segEntry
+	byHash   map[[32]byte]int // hash -> position in entries
+	index    *os.File
+	seg      *os.File // current segment, opened on first append
+	segNum   uint32
+	segBytes int64
+
+	// MaxSegmentBytes starts a new segment once the current one would grow
+	// past it. A single record larger than that still fits in its own segment.
+	MaxSegmentBytes int64
+
+	// Recovered is set when opening repaired a torn segment tail
+	Recovered *Recovery
+}
+
+// segEntry locates one block in the segment files
+type segEntry struct {
+	Segment uint32
+	Offset  int64
+	Length  uint32
+	Flags   uint8
+	Index   int
+	Hash    [32]byte
+}
+
+func (e segEntry) marshal() []byte {
+	buf := make([]byte, indexEntrySize)
+	binary.LittleEndian.PutUint32(buf[0:], e.Segment)
+	binary.LittleEndian.PutUint64(buf[4:], uint64(e.Offset))
+	binary.LittleEndian.PutUint32(buf[12:], e.Length)
+	buf[16] = e.Flags
+	binary.LittleEndian.PutUint64(buf[17:], uint64(e.Index))
+	copy(buf[25:], e.Hash[:])
+	return buf
+}
+
+func unmarshalEntry(buf []byte) segEntry {
+	e := segEntry{
+		Segment: binary.LittleEndian.Uint32(buf[0:]),
+		Offset:  int64(binary.LittleEndian.Uint64(buf[4:])),
+		Length:  binary.LittleEndian.Uint32(buf[12:]),
+		Flags:   buf[16],
+		Index:   int(binary.LittleEndian.Uint64(buf[17:])),
+	}
+	copy(e.Hash[:], buf[25:])
+	return e
+}
+
+// newSegEntry builds the index entry of a block stored at offset
+func newSegEntry(blk *Block, segment uint32, offset int64, n int) (segEntry, error) {
+	e := segEntry{Segment: segment, Offset: offset, Length: uint32(n), Index: blk.Index}
+	sum, err := hex.DecodeString(blk.Hash)
+	if err != nil || len(sum) != len(e.Hash) {
+		return e, fmt.Errorf("block %d: hash is not a hex SHA-256", blk.Index)
+	}
+	copy(e.Hash[:], sum)
+	if blk.Type == BlockTypeCheckpoint {
+		e.Flags |= entryCheckpoint
+	}
+	return e, nil
+}
+
+func (s *SegmentStore) segmentPath(n uint32) string {
+	return filepath.Join(s.dir, fmt.Sprintf("segment-%06d.log", n))
+}
+
+func (s *SegmentStore) indexPath() string {
+	return filepath.Join(s.dir, "index")
+}
+
+// OpenSegmentStore opens (or creates) a segment store in dir. The index is
+// checked against the segments: a partial or dangling entry is dropped,
+// blocks missing from the index are indexed again and a torn segment tail
+// is quarantined.
+func OpenSegmentStore(dir string) (*SegmentStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s := &SegmentStore{dir: dir, byHash: make(map[[32]byte]int), MaxSegmentBytes: DefaultSegmentBytes}
+
+	segments, err := s.listSegments()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.loadIndex(); err != nil {
+		return nil, err
+	}
+	if err := s.reindex(segments); err != nil {
+		s.index.Close()
+		return nil, err
+	}
+
+	if len(segments) > 0 {
+		s.segNum = segments[len(segments)-1]
+		if info, err := os.Stat(s.segmentPath(s.segNum)); err == nil {
+			s.segBytes = info.Size()
+		}
+	}
+	return s, nil
+}
+
+// listSegments returns the numbers of the segment files, in order
+func (s *SegmentStore) listSegments() ([]uint32, error) {
+	names, err := filepath.Glob(filepath.Join(s.dir, "segment-*.log"))
+	if err != nil {
+		return nil, err
+	}
+	var nums []uint32
+	for _, name := range names {
+		var n uint32
+		if _, err := fmt.Sscanf(filepath.Base(name), "segment-%06d.log", &n); err == nil {
+			nums = append(nums, n)
+		}
+	}
+	sort.Slice(nums, func(i, j int) bool { return nums[i] < nums[j] })
+	return nums, nil
+}
+
+// loadIndex reads the index file, keeping the entries that point inside
+// their segment and whose last record still matches its hash
+func (s *SegmentStore) loadIndex() error {
+	f, err := os.OpenFile(s.indexPath(), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	s.index = f
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	sizes := make(map[uint32]int64)
+	for off := 0; off+indexEntrySize <= len(data); off += indexEntrySize {
+		e := unmarshalEntry(data[off : off+indexEntrySize])
+		size, ok := sizes[e.Segment]
+		if !ok {
+			if info, err := os.Stat(s.segmentPath(e.Segment)); err == nil {
+				size = info.Size()
+			}
+			sizes[e.Segment] = size
+		}
+		if e.Offset+int64(e.Length) > size {
+			break // the segment lost this record, re-index from the segments
+		}
+		s.add(e)
+	}
+	if n := len(s.entries); n > 0 {
+		if blk, err := s.read(s.entries[n-1]); err != nil || blk.Hash != hex.EncodeToString(s.entries[n-1].Hash[:]) {
+			s.entries, s.byHash = nil, make(map[[32]byte]int)
+		}
+	}
+	if int64(len(data)) != int64(len(s.entries))*indexEntrySize {
+		if err := f.Truncate(int64(len(s.entries)) * indexEntrySize); err != nil {
+			return err
+		}
+	}
+	_, err = f.Seek(0, io.SeekEnd)
+	return err
+}
+
+// reindex scans the segments past the last indexed record, indexing the
+// blocks found there and repairing a torn tail of the last segment
+func (s *SegmentStore) reindex(segments []uint32) error {
+	var seg uint32
+	var offset int64
+	if n := len(s.entries); n > 0 {
+		last := s.entries[n-1]
+		seg, offset = last.Segment, last.Offset+int64(last.Length)
+	} else if len(segments) > 0 {
+		seg = segments[0]
+	}
+
+	var added []segEntry
+	for i, num := range segments {
+		if num < seg {
+			continue
+		}
+		start := int64(0)
+		if num == seg {
+			start = offset
+		}
+		path := s.segmentPath(num)
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		var entryErr error
+		if _, err := f.Seek(start, io.SeekStart); err != nil {
+			f.Close()
+			return err
+		}
+		torn, err := scanRecords(f, start, func(blk *Block, off int64, n int) bool {
+			e, err := newSegEntry(blk, num, off, n)
+			if err != nil {
+				entryErr = err
+				return false
+			}
+			s.add(e)
+			added = append(added, e)
+			return true
+		})
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if entryErr != nil {
+			return fmt.Errorf("%s: %w", path, entryErr)
+		}
+		if torn != nil {
+			if i != len(segments)-1 && len(torn.Data) > 0 {
+				return fmt.Errorf("%s: torn record at offset %d before later segments", path, torn.Offset)
+			}
+			rec, err := repairTail(path, torn)
+			if err != nil {
+				return fmt.Errorf("repair torn segment tail: %w", err)
+			}
+			if rec != nil {
+				s.Recovered = rec
+			}
+		}
+	}
+
+	if len(added) == 0 {
+		return nil
+	}
+	for _, e := range added {
+		if _, err := s.index.Write(e.marshal()); err != nil {
+			return err
+		}
+	}
+	return s.index.Sync()
+}
+
+// add indexes an entry in memory
+func (s *SegmentStore) add(e segEntry) {
+	s.byHash[e.Hash] = len(s.entries)
+	s.entries = append(s.entries, e)
+}
+
+// read decodes the record an entry points at
+func (s *SegmentStore) read(e segEntry) (*Block, error) {
+	f, err := os.Open(s.segmentPath(e.Segment))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readEntry(f, e)
+}
+
+func readEntry(f *os.File, e segEntry) (*Block, error) {
+	buf := make([]byte, e.Length)
+	if _, err := f.ReadAt(buf, e.Offset); err != nil {
+		return nil, err
+	}
+	return decodeRecord(buf)
+}
+
+// Append writes the record to the current segment and then its index
+// entry, fsyncing both. If either write fails both are truncated back.
+func (s *SegmentStore) Append(b *Block) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	line, err := encodeRecord(b)
+	if err != nil {
+		return fmt.Errorf("encode block: %w", err)
+	}
+	if s.segBytes > 0 && s.segBytes+int64(len(line)) > s.MaxSegmentBytes {
+		if s.seg != nil {
+			s.seg.Close()
+			s.seg = nil
+		}
+		s.segNum++
+		s.segBytes = 0
+	}
+	if s.seg == nil {
+		if err := s.openSegment(); err != nil {
+			return err
+		}
+	}
+
+	offset := s.segBytes
+	e, err := newSegEntry(b, s.segNum, offset, len(line))
+	if err != nil {
+		return err
+	}
+	if _, err := s.seg.Write(line); err != nil {
+		s.seg.Truncate(offset)
+		return fmt.Errorf("write segment: %w", err)
+	}
+	if err := s.seg.Sync(); err != nil {
+		s.seg.Truncate(offset)
+		return fmt.Errorf("sync segment: %w", err)
+	}
+
+	indexSize := int64(len(s.entries)) * indexEntrySize
+	if _, err := s.index.Write(e.marshal()); err != nil {
+		s.index.Truncate(indexSize)
+		s.seg.Truncate(offset)
+		return fmt.Errorf("write index: %w", err)
+	}
+	if err := s.index.Sync(); err != nil {
+		s.index.Truncate(indexSize)
+		s.seg.Truncate(offset)
+		return fmt.Errorf("sync index: %w", err)
+	}
+
+	s.add(e)
+	s.segBytes += int64(len(line))
+	return nil
+}
+
+// openSegment opens the current segment for appending, creating it durably
+func (s *SegmentStore) openSegment() error {
+	path := s.segmentPath(s.segNum)
+	_, statErr := os.Stat(path)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("open segment: %w", err)
+	}
+	if os.IsNotExist(statErr) {
+		if err := syncDir(path); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	s.seg = f
+	return nil
+}
+
+// position returns where the block with index is in entries: directly when
+// indexes are contiguous, by binary search otherwise
+func (s *SegmentStore) position(index int) (int, bool) {
+	if len(s.entries) == 0 {
+		return 0, false
+	}
+	if pos := index - s.entries[0].Index; pos >= 0 && pos < len(s.entries) && s.entries[pos].Index == index {
+		return pos, true
+	}
+	pos := sort.Search(len(s.entries), func(i int) bool { return s.entries[i].Index >= index })
+	return pos, pos < len(s.entries) && s.entries[pos].Index == index
+}
+
+// Iterate reads the blocks with Index >= from through the index
+func (s *SegmentStore) Iterate(from int, fn func(*Block) bool) error {
+	s.mu.Lock()
+	entries := s.entries
+	s.mu.Unlock()
+
+	start := sort.Search(len(entries), func(i int) bool { return entries[i].Index >= from })
+	var f *os.File
+	defer func() {
+		if f != nil {
+			f.Close()
+		}
+	}()
+	for i := start; i < len(entries); i++ {
+		e := entries[i]
+		if f == nil || entries[i-1].Segment != e.Segment {
+			if f != nil {
+				f.Close()
+			}
+			var err error
+			if f, err = os.Open(s.segmentPath(e.Segment)); err != nil {
+				return err
+			}
+		}
+		blk, err := readEntry(f, e)
+		if err != nil {
+			return fmt.Errorf("read block %d: %w", e.Index, err)
+		}
+		if !fn(blk) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// Get returns the block with index
+func (s *SegmentStore) Get(index int) (*Block, error) {
+	s.mu.Lock()
+	pos, ok := s.position(index)
+	var e segEntry
+	if ok {
+		e = s.entries[pos]
+	}
+	s.mu.Unlock()
+	if !ok {
+		return nil, ErrBlockNotFound
+	}
+	return s.read(e)
+}
+
+// GetByHash returns the block with hash
+func (s *SegmentStore) GetByHash(hash string) (*Block, error) {
+	sum, err := hex.DecodeString(hash)
+	if err != nil || len(sum) != 32 {
+		return nil, ErrBlockNotFound
+	}
+	var key [32]byte
+	copy(key[:], sum)
+
+	s.mu.Lock()
+	pos, ok := s.byHash[key]
+	var e segEntry
+	if ok {
+		e = s.entries[pos]
+	}
+	s.mu.Unlock()
+	if !ok {
+		return nil, ErrBlockNotFound
+	}
+	return s.read(e)
+}
+
+// Head returns the last block
+func (s *SegmentStore) Head() (*Block, error) {
+	s.mu.Lock()
+	n := len(s.entries)
+	var e segEntry
+	if n > 0 {
+		e = s.entries[n-1]
+	}
+	s.mu.Unlock()
+	if n == 0 {
+		return nil, nil
+	}
+	return s.read(e)
+}
+
+// LastCheckpoint returns the last block flagged as a checkpoint
+func (s *SegmentStore) LastCheckpoint() (*Block, error) {
+	s.mu.Lock()
+	var e segEntry
+	found := false
+	for i := len(s.entries) - 1; i >= 0; i-- {
+		if s.entries[i].Flags&entryCheckpoint != 0 {
+			e, found = s.entries[i], true
+			break
+		}
+	}
+	s.mu.Unlock()
+	if !found {
+		return nil, nil
+	}
+	return s.read(e)
+}
+
+// Len returns the number of blocks in the store
+func (s *SegmentStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.entries)
+}
+
+// Recovery returns the torn tail repaired when opening, if any
+func (s *SegmentStore) Recovery() *Recovery {
+	return s.Recovered
+}
+
+// Close closes the index and the current segment
+func (s *SegmentStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.seg != nil {
+		s.seg.Close()
+		s.seg = nil
+	}
+	return s.index.Close()
+}
@@ -0,0 +1,26 @@
+package blockchain
+
+import "errors"
+
+// ErrBlockNotFound is returned by LedgerStore lookups that match no block
+var ErrBlockNotFound = errors.New("block not found")
+
+// LedgerStore persists ledger blocks. Ledger keeps linkage, signing and
+// verification on top of any implementation; a store only has to append
+// durably and read back what it appended, in order.
+type LedgerStore interface {
+	// Append durably stores a signed block after the current head
+	Append(b *Block) error
+	// Iterate calls fn for every block with Index >= from, in order,
+	// until fn returns false
+	Iterate(from int, fn func(*Block) bool) error
+	// Get returns the block with the given index
+	Get(index int) (*Block, error)
+	// GetByHash returns the block with the given hash
+	GetByHash(hash string) (*Block, error)
+	// Head returns the last block, or nil when the store is empty
+	Head() (*Block, error)
+	// LastCheckpoint returns the last checkpoint block, or nil
+	LastCheckpoint() (*Block, error)
+	Close() error
+}
@@ -1,61 +1,293 @@
 package blockchain
 
 import (
+	"blockci-q/internal/security"
 	"crypto/ed25519"
 	"encoding/hex"
 	"fmt"
+	"time"
 )
 
-// VerifyChain validates all blocks: recompute hash, prev linkage, index sanity and signature.
-func (l *Ledger) VerifyChain() error {
+// FaultKind classifies a verification problem
+type FaultKind string
+
+const (
+	FaultIndexGap            FaultKind = "index_gap"
+	FaultHashMismatch        FaultKind = "hash_mismatch"
+	FaultBrokenLink          FaultKind = "broken_link"
+	FaultMissingSignature    FaultKind = "missing_signature"
+	FaultBadSignature        FaultKind = "bad_signature"
+	FaultUnknownSigner       FaultKind = "unknown_signer"
+	FaultSignerNotValid      FaultKind = "signer_not_valid"
+	FaultSignerLineage       FaultKind = "signer_lineage"
+	FaultBadRotation         FaultKind = "bad_rotation"
+	FaultUnknownType         FaultKind = "unknown_block_type"
+	FaultMerkleRoot          FaultKind = "merkle_root_mismatch"
+	FaultBadCheckpoint       FaultKind = "bad_checkpoint"
+	FaultBadAgentSignature   FaultKind = "bad_agent_signature"
+	FaultBadTimestamp        FaultKind = "bad_timestamp"
+	FaultTimestampRegression FaultKind = "timestamp_regression"
+)
+
+// Fault is one problem found in one block
+type Fault struct {
+	Position int       `json:"position"` // position of the block in the ledger
+	Index    int       `json:"index"`    // index recorded in the block
+	Kind     FaultKind `json:"kind"`
+	Message  string    `json:"message"`
+}
+
+func (f Fault) Error() string {
+	return f.Message
+}
+
+// VerifyReport lists every fault of a ledger instead of stopping at the first
+type VerifyReport struct {
+	OK      bool    `json:"ok"`
+	Verdict string  `json:"verdict"` // "valid" or "invalid"
+	From    int     `json:"from"`    // index of the first verified block (a checkpoint unless 0)
+	Blocks  int     `json:"blocks"`  // number of verified blocks
+	Faults  []Fault `json:"faults"`
+	// FirstDivergence is the position of the first faulty block: the chain
+	// can be trusted up to (not including) it. -1 when the chain is valid.
+	FirstDivergence int `json:"firstDivergence"`
+	// TornTail is an unreadable end of the file found by a read-only open.
+	// It is not part of the chain (the blocks before it are verified), but
+	// a writer opening the ledger will quarantine it.
+	TornTail *Recovery `json:"tornTail,omitempty"`
+}
+
+// VerifyOptions configures Verify
+type VerifyOptions struct {
+	// Trust pins the server keys allowed to sign blocks, each at the block
+	// timestamp. When nil, any key verifying its block's signature is
+	// accepted, which a rewritten and re-signed block would pass.
+	Trust *security.TrustStore
+
+	// Incremental starts at the last checkpoint instead of block 0. The
+	// checkpoint must be signed by a pinned key; the blocks before it are
+	// taken as verified. A ledger opened from a checkpoint is always
+	// verified from there; use AuditLedger for a full audit.
+	Incremental bool
+}
+
+// Verify walks the whole ledger and collects every fault: index gaps, hash
+// mismatches, broken links, missing/bad/unknown signatures, bad agent
+// signatures, timestamp regressions and run summaries whose Merkle root
+// does not match the run's step blocks.
+//
+// Signers follow the key lineage: after a key_rotation block every block
+// must be signed by the announced key, which is trusted through the
+// rotation rather than the trust store. The trust store only has to pin
+// the keys that signed before the first rotation.
+//
+// Checkpoints must match the state of the chain before them: the current
+// signer and the log hashes of every run not sealed yet.
+func (l *Ledger) Verify(opts VerifyOptions) *VerifyReport {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
-	for i, blk := range l.Blocks {
-		// index sanity
-		if blk.Index != i {
-			return fmt.Errorf("index mismatch at %d: block.Index=%d", i, blk.Index)
+	start := 0
+	if opts.Incremental {
+		for i := len(l.Blocks) - 1; i > 0; i-- {
+			if l.Blocks[i].Type == BlockTypeCheckpoint {
+				start = i
+				break
+			}
+		}
+	}
+	blocks := l.Blocks[start:]
+	resume := start > 0 || l.partial
+
+	report := &VerifyReport{Blocks: len(blocks), Faults: []Fault{}, FirstDivergence: -1, TornTail: l.Torn}
+	if len(blocks) > 0 {
+		report.From = blocks[0].Index
+	}
+	add := func(pos int, blk *Block, kind FaultKind, format string, args ...interface{}) {
+		report.Faults = append(report.Faults, Fault{
+			Position: pos,
+			Index:    blk.Index,
+			Kind:     kind,
+			Message:  fmt.Sprintf(format, args...),
+		})
+		if report.FirstDivergence == -1 {
+			report.FirstDivergence = pos
+		}
+	}
+
+	var prevTime time.Time
+	state := newChainState()
+	for i, blk := range blocks {
+		pos := start + i
+		fault := func(kind FaultKind, format string, args ...interface{}) {
+			add(pos, blk, kind, format, args...)
+		}
+
+		// resuming: the checkpoint seeds the state and is only trusted
+		// through the trust store, not through the lineage it claims
+		if i == 0 && resume {
+			if blk.Type != BlockTypeCheckpoint || blk.Checkpoint == nil || blk.Version < 2 {
+				fault(FaultBadCheckpoint, "verification must resume at a checkpoint, index %d is not one", blk.Index)
+			} else {
+				state = blk.Checkpoint.clone()
+			}
+		}
+
+		// index sanity: each block continues the previous one
+		expectedIndex := 0
+		if i > 0 {
+			expectedIndex = blocks[i-1].Index + 1
+		} else if resume {
+			expectedIndex = blk.Index
+		}
+		if blk.Index != expectedIndex {
+			add(pos, blk, FaultIndexGap, "index mismatch at %d: block.Index=%d, expected %d", pos, blk.Index, expectedIndex)
 		}
 
 		// recompute hash and compare
 		expected, err := blk.ComputeHash()
 		if err != nil {
-			return fmt.Errorf("cannot compute hash for index %d: %w", blk.Index, err)
+			fault(FaultHashMismatch, "cannot compute hash for index %d: %v", blk.Index, err)
+		} else if blk.Hash != expected {
+			fault(FaultHashMismatch, "hash mismatch at index %d", blk.Index)
 		}
-		if blk.Hash != expected {
-			return fmt.Errorf("hash mismatch at index %d", blk.Index)
+
+		// prevHash linkage (the first block may carry any prevHash)
+		if i > 0 && blk.PrevHash != blocks[i-1].Hash {
+			fault(FaultBrokenLink, "prevHash mismatch at index %d", blk.Index)
 		}
 
-		// prevHash linkage
-		if i > 0 {
-			if blk.PrevHash != l.Blocks[i-1].Hash {
-				return fmt.Errorf("prevHash mismatch at index %d", blk.Index)
-			}
+		// timestamps never go backwards
+		ts, err := time.Parse(time.RFC3339, blk.Timestamp)
+		if err != nil {
+			fault(FaultBadTimestamp, "invalid timestamp %q at index %d", blk.Timestamp, blk.Index)
 		} else {
-			// first block should have empty prevHash (or some genesis rule)
-			// no-op, allow empty prevHash
+			if ts.Before(prevTime) {
+				fault(FaultTimestampRegression, "timestamp at index %d is before the previous block", blk.Index)
+			}
+			prevTime = ts
 		}
 
-		// require signature and pubKey
-		if blk.Signature == "" || blk.PubKey == "" {
-			return fmt.Errorf("missing signature or pubKey at index %d", blk.Index)
+		lineage := state.Signer
+		if i == 0 && resume {
+			lineage = ""
 		}
+		if lineage != "" && blk.PubKey != lineage {
+			fault(FaultSignerLineage, "block %d signed by %s, but the key was rotated to %s", blk.Index, blk.PubKey, lineage)
+		}
+		verifySignatures(blk, opts, lineage != "", fault)
 
+		switch blk.Type {
+		case BlockTypeStep:
+			if blk.RunID != "" {
+				state.OpenRuns[blk.RunID] = append(state.OpenRuns[blk.RunID], blk.LogHash)
+			}
+		case BlockTypeRunSummary:
+			logs := state.OpenRuns[blk.RunID]
+			if blk.Version < 2 || blk.LeafCount != len(logs) || blk.MerkleRoot != MerkleRoot(logs) {
+				fault(FaultMerkleRoot, "run summary at index %d does not match the %d step blocks of run %s", blk.Index, len(logs), blk.RunID)
+			}
+			delete(state.OpenRuns, blk.RunID)
+		case BlockTypeKeyRotation:
+			if next, ok := checkRotation(blk, fault); ok {
+				state.Signer = next
+			}
+		case BlockTypeCheckpoint:
+			if i > 0 || !resume {
+				if blk.Version < 2 || !state.equal(blk.Checkpoint) {
+					fault(FaultBadCheckpoint, "checkpoint at index %d does not match the chain before it", blk.Index)
+				}
+			}
+		default:
+			fault(FaultUnknownType, "unknown block type %q at index %d", blk.Type, blk.Index)
+		}
+	}
+
+	report.OK = len(report.Faults) == 0
+	report.Verdict = "valid"
+	if !report.OK {
+		report.Verdict = "invalid"
+	}
+	return report
+}
+
+// verifySignatures checks the server signature over the block hash and, when
+// recorded, the agent signature over the result envelope. Signers reached
+// through a key rotation skip the trust store.
+func verifySignatures(blk *Block, opts VerifyOptions, rotated bool, add func(FaultKind, string, ...interface{})) {
+	// require signature and pubKey
+	if blk.Signature == "" || blk.PubKey == "" {
+		add(FaultMissingSignature, "missing signature or pubKey at index %d", blk.Index)
+	} else {
 		// decode pubkey and signature
-		pubBytes, err := hex.DecodeString(blk.PubKey)
-		if err != nil {
-			return fmt.Errorf("invalid pubKey encoding at index %d: %w", blk.Index, err)
+		pubBytes, pubErr := hex.DecodeString(blk.PubKey)
+		sigBytes, sigErr := hex.DecodeString(blk.Signature)
+		switch {
+		case pubErr != nil || len(pubBytes) != ed25519.PublicKeySize:
+			add(FaultBadSignature, "invalid pubKey encoding at index %d", blk.Index)
+		case sigErr != nil:
+			add(FaultBadSignature, "invalid signature encoding at index %d: %v", blk.Index, sigErr)
+		case !ed25519.Verify(ed25519.PublicKey(pubBytes), []byte(blk.Hash), sigBytes):
+			// verify signature over the block hash
+			add(FaultBadSignature, "signature verification failed at index %d", blk.Index)
 		}
-		sigBytes, err := hex.DecodeString(blk.Signature)
-		if err != nil {
-			return fmt.Errorf("invalid signature encoding at index %d: %w", blk.Index, err)
+
+		if opts.Trust != nil && !rotated {
+			checkSigner(blk, opts.Trust, add)
 		}
+	}
 
-		// verify signature over the block hash
-		if !ed25519.Verify(ed25519.PublicKey(pubBytes), []byte(blk.Hash), sigBytes) {
-			return fmt.Errorf("signature verification failed at index %d", blk.Index)
+	// verify the agent signature over the result envelope, when recorded
+	if blk.AgentSig != "" {
+		ok, err := security.VerifySignatureFromHex(blk.AgentPubKey, blk.ResultEnvelope().Canonical(), blk.AgentSig)
+		if err != nil || !ok {
+			add(FaultBadAgentSignature, "agent signature verification failed at index %d", blk.Index)
 		}
 	}
+}
+
+// checkRotation validates a key_rotation block and returns the announced
+// key. Only version 2+ blocks hash their type, so a rotation claimed by a
+// legacy block is rejected.
+func checkRotation(blk *Block, add func(FaultKind, string, ...interface{})) (string, bool) {
+	if blk.Version < 2 {
+		add(FaultBadRotation, "key rotation at index %d in a version %d block", blk.Index, blk.Version)
+		return "", false
+	}
+	newPub, err := hex.DecodeString(blk.NewPubKey)
+	if err != nil || len(newPub) != ed25519.PublicKeySize {
+		add(FaultBadRotation, "key rotation at index %d announces an invalid key", blk.Index)
+		return "", false
+	}
+	if blk.NewPubKey == blk.PubKey {
+		add(FaultBadRotation, "key rotation at index %d announces the signing key itself", blk.Index)
+		return "", false
+	}
+	return blk.NewPubKey, true
+}
 
+// checkSigner fails a block signed by a key that is not pinned, or pinned
+// but not valid at the block timestamp
+func checkSigner(blk *Block, trust *security.TrustStore, add func(FaultKind, string, ...interface{})) {
+	if !trust.Contains(blk.PubKey) {
+		add(FaultUnknownSigner, "block %d signed by untrusted key %s", blk.Index, blk.PubKey)
+		return
+	}
+	ts, err := time.Parse(time.RFC3339, blk.Timestamp)
+	if err != nil {
+		return // reported as bad_timestamp
+	}
+	if err := trust.Check(blk.PubKey, ts); err != nil {
+		add(FaultSignerNotValid, "block %d signed by key %s: %v", blk.Index, blk.PubKey, err)
+	}
+}
+
+// VerifyChain validates all blocks and returns the first fault, if any.
+// Use Verify for the full report.
+func (l *Ledger) VerifyChain() error {
+	report := l.Verify(VerifyOptions{})
+	if !report.OK {
+		return report.Faults[0]
+	}
 	return nil
 }
@@ -3,7 +3,10 @@ package core
 import (
 	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"os/exec"
+	"sync"
 	"time"
 )
 
@@ -27,5 +30,57 @@ func (e *Executor) RunStep(step Step, timeout time.Duration) (string , error) {
 	cmd.Stderr = &out
 
 	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return out.String(), fmt.Errorf("%w after %s", ErrStepTimeout, timeout)
+	}
 	return out.String(), err
 } 
+
+
+// ExitCode extracts the exit code of a finished step: 0 on success, the
+// process exit code when it ran, -1 when it could not run or was killed.
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
+
+// StepOutput is the result of one step run by RunSteps
+type StepOutput struct {
+	Step       Step
+	Output     string
+	Err        error
+	StartedAt  time.Time
+	FinishedAt time.Time
+}
+
+// RunSteps runs steps concurrently, at most maxParallel at a time
+// (0 = no limit). Outputs are returned in the order of steps.
+func (e *Executor) RunSteps(steps []Step, maxParallel int, timeout time.Duration) []StepOutput {
+	if maxParallel <= 0 || maxParallel > len(steps) {
+		maxParallel = len(steps)
+	}
+
+	outputs := make([]StepOutput, len(steps))
+	sem := make(chan struct{}, maxParallel)
+	var wg sync.WaitGroup
+
+	for i, step := range steps {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, step Step) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			started := time.Now().UTC()
+			output, err := e.RunStep(step, timeout)
+			outputs[i] = StepOutput{Step: step, Output: output, Err: err, StartedAt: started, FinishedAt: time.Now().UTC()}
+		}(i, step)
+	}
+	wg.Wait()
+	return outputs
+}
@@ -0,0 +1,132 @@
+package core
+
+import (
+	"blockci-q/pkg/utils"
+	"errors"
+	"fmt"
+)
+
+// Run lifecycle rules shared by cancel, retry and failure handling. The
+// server keeps the run state; these decide what happens to it.
+
+// ErrUnknownStage is returned for a stage name a pipeline does not define
+var ErrUnknownStage = errors.New("unknown stage")
+
+// ErrLogNotUploaded is returned for a result whose log never reached the
+// server and whose agent gave no reason for it
+var ErrLogNotUploaded = errors.New("log was not uploaded")
+
+// ResultLog returns the log hash a job result is recorded against and
+// whether the log counts as lost. uploaded is the hash of the log the
+// server received, if any; logError is the agent's reason for not
+// delivering it. A reported logError always means a lost log, even when an
+// upload arrived: the agent could not confirm it and reports the empty log.
+func ResultLog(uploaded string, hasUpload bool, logError string) (hash string, lost bool, err error) {
+	if logError != "" {
+		return utils.HashString(""), true, nil
+	}
+	if !hasUpload {
+		return "", false, ErrLogNotUploaded
+	}
+	return uploaded, false, nil
+}
+
+// StageStates summarises the step states of every stage of a pipeline.
+// state returns the state of one step.
+func StageStates(pipeline *Pipeline, state func(StepRef) State) map[string]State {
+	stages := make(map[string]State, len(pipeline.Stages))
+	for _, stage := range pipeline.Stages {
+		counts := make(map[State]int)
+		for _, step := range stage.Steps {
+			counts[state(StepRef{Stage: stage.Name, Step: step.Name})]++
+		}
+		switch {
+		case counts[StateFailed] > 0:
+			stages[stage.Name] = StateFailed
+		case counts[StateTimedOut] > 0:
+			stages[stage.Name] = StateTimedOut
+		case counts[StateRunning] > 0:
+			stages[stage.Name] = StateRunning
+		case counts[StateQueued] > 0:
+			stages[stage.Name] = StateQueued
+		case counts[StateSucceeded] == len(stage.Steps):
+			stages[stage.Name] = StateSucceeded
+		case counts[StateCancelled] > 0:
+			stages[stage.Name] = StateCancelled
+		case counts[StateSkipped] > 0:
+			stages[stage.Name] = StateSkipped
+		default:
+			stages[stage.Name] = StatePending
+		}
+	}
+	return stages
+}
+
+// CarriedStages returns the stages a retry from fromStage takes over from
+// the previous run instead of running them again: every stage declared
+// before fromStage. Each must have succeeded in that run (stages holds its
+// stage states) or have been carried into it. No fromStage carries nothing.
+func CarriedStages(pipeline *Pipeline, stages map[string]State, carried map[string]bool, fromStage string) (map[string]bool, error) {
+	taken := make(map[string]bool)
+	if fromStage == "" {
+		return taken, nil
+	}
+	if findStage(pipeline, fromStage) == nil {
+		return nil, fmt.Errorf("%w %q", ErrUnknownStage, fromStage)
+	}
+	for _, stage := range pipeline.Stages {
+		if stage.Name == fromStage {
+			break
+		}
+		if stages[stage.Name] != StateSucceeded && !carried[stage.Name] {
+			return nil, fmt.Errorf("stage %q did not succeed, retry from it instead", stage.Name)
+		}
+		taken[stage.Name] = true
+	}
+	return taken, nil
+}
+
+// Carry marks the steps of the carried stages as succeeded, so the steps
+// after them are released first, and returns them
+func (p *Progress) Carry(carried map[string]bool) []StepRef {
+	var refs []StepRef
+	for _, stage := range p.pipeline.Stages {
+		if !carried[stage.Name] {
+			continue
+		}
+		for _, step := range stage.Steps {
+			ref := StepRef{Stage: stage.Name, Step: step.Name}
+			p.Restore(ref, StateSucceeded)
+			refs = append(refs, ref)
+		}
+	}
+	return refs
+}
+
+// StoppedState is the state a step moves to when its run is stopped:
+// queued steps are cancelled and pending ones move to pendingTo (cancelled
+// on cancel, skipped on failure). Running steps keep running and still
+// report; finished ones keep their state.
+func StoppedState(step, pendingTo State) State {
+	switch step {
+	case StateQueued:
+		return StateCancelled
+	case StatePending:
+		return pendingTo
+	}
+	return step
+}
+
+// Settled reports whether a run can be sealed: it is terminal and none of
+// its steps is still running
+func Settled(run State, steps map[string]State) bool {
+	if !run.Terminal() {
+		return false
+	}
+	for _, st := range steps {
+		if st == StateRunning {
+			return false
+		}
+	}
+	return true
+}
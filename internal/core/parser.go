@@ -2,20 +2,31 @@ package core
 
 import (
 	"os"
-	"gopkg.in/yaml.v3"
 )
 
-// ParsePipeline parses YAML content into a Pipeline object
+// ParsePipeline parses YAML content into a Pipeline object.
+// Documents of older schema versions are upgraded to PipelineVersion, then
+// checked like ValidatePipeline does; problems are returned as
+// ValidationErrors.
 func ParsePipeline(data []byte) (*Pipeline, error) {
-    var pipeline Pipeline
-    err := yaml.Unmarshal(data, &pipeline)
-    if err != nil {
-        return nil, err
-    }
-    return &pipeline, nil
+	doc, _, errs := loadDocument(data)
+	if errs == nil {
+		errs = validateDocument(doc)
+	}
+	if errs != nil {
+		return nil, errs
+	}
+	var pipeline Pipeline
+	err := doc.Decode(&pipeline)
+	if err != nil {
+		return nil, err
+	}
+	if err := NewScheduler().Validate(&pipeline); err != nil {
+		return nil, err
+	}
+	return &pipeline, nil
 }
 
-
 // LoadPipeline reads pipeline.yaml and returns a Pipeline object
 func LoadPipeline(path string) (*Pipeline, error) {
 	data, err := os.ReadFile(path)
@@ -23,11 +34,5 @@ func LoadPipeline(path string) (*Pipeline, error) {
 		return nil, err
 	}
 
-	var pipeline Pipeline
-	err = yaml.Unmarshal(data, &pipeline)
-	if err != nil {
-		return nil , err
-	}
-
-	return &pipeline, nil
-}
\ No newline at end of file
+	return ParsePipeline(data)
+}
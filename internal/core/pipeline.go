@@ -1,19 +1,34 @@
 package core
 
-// Pipeline represents the full CI/CD pipeline
+// Pipeline represents the full CI/CD pipeline.
+// Name groups runs of the same pipeline for run numbering (e.g. build#42).
+// Version is the schema version; parsed pipelines are always upgraded to
+// PipelineVersion, see schema.go.
 type Pipeline struct {
-	Agent  string  `yaml:"agent"`
-	Stages []Stage `yaml:"stages"`
+	Version int     `yaml:"version,omitempty"`
+	Name    string  `yaml:"name,omitempty"`
+	Agent   string  `yaml:"agent"`
+	Stages  []Stage `yaml:"stages"`
 }
 
-// Stage is an ordered sequence of steps
+// Stage is an ordered sequence of steps.
+// Needs lists the stages that must finish first; when it is omitted the
+// stage depends on the stage declared right before it.
+// Parallel drops the implicit ordering between steps so they can run
+// concurrently, at most MaxParallel at a time (0 = no limit).
 type Stage struct {
-	Name  string `yaml:"name"`
-	Steps []Step `yaml:"steps"`
+	Name        string   `yaml:"name"`
+	Needs       []string `yaml:"needs,omitempty"`
+	Parallel    bool     `yaml:"parallel,omitempty"`
+	MaxParallel int      `yaml:"max_parallel,omitempty"`
+	Steps       []Step   `yaml:"steps"`
 }
 
-// Step is a single command in a stage
+// Step is a single command in a stage.
+// Needs refers to sibling steps of the same stage, with the same
+// defaulting rule as Stage.Needs.
 type Step struct {
-	Name string `yaml:"name"`
-	Run  string `yaml:"run"`
+	Name  string   `yaml:"name"`
+	Run   string   `yaml:"run"`
+	Needs []string `yaml:"needs,omitempty"`
 }
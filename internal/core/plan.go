@@ -0,0 +1,173 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+)
+
+// PlanStage is one stage of a resolved execution plan
+type PlanStage struct {
+	Name        string     `json:"name"`
+	Needs       []string   `json:"needs,omitempty"`
+	Parallel    bool       `json:"parallel,omitempty"`
+	MaxParallel int        `json:"maxParallel,omitempty"`
+	Steps       []PlanStep `json:"steps"`
+}
+
+// PlanStep is one step of a resolved execution plan
+type PlanStep struct {
+	Name  string   `json:"name"`
+	Run   string   `json:"run"`
+	Needs []string `json:"needs,omitempty"`
+}
+
+// Plan resolves the stage and step graphs of a pipeline: stages and the
+// steps inside them in execution order, with their resolved dependencies
+// (including the implicit ones).
+func (s *Scheduler) Plan(pipeline *Pipeline) ([]PlanStage, error) {
+	graph, err := s.StageGraph(pipeline)
+	if err != nil {
+		return nil, err
+	}
+	stages := make(map[string]Stage, len(pipeline.Stages))
+	for _, st := range pipeline.Stages {
+		stages[st.Name] = st
+	}
+
+	plan := make([]PlanStage, 0, len(pipeline.Stages))
+	for _, name := range graph.Order() {
+		stage := stages[name]
+		steps, err := s.StepGraph(stage)
+		if err != nil {
+			return nil, err
+		}
+		runs := make(map[string]string, len(stage.Steps))
+		for _, st := range stage.Steps {
+			runs[st.Name] = st.Run
+		}
+		ps := PlanStage{Name: name, Needs: graph.Needs(name), Parallel: stage.Parallel, MaxParallel: stage.MaxParallel}
+		for _, step := range steps.Order() {
+			ps.Steps = append(ps.Steps, PlanStep{Name: step, Run: runs[step], Needs: steps.Needs(step)})
+		}
+		plan = append(plan, ps)
+	}
+	return plan, nil
+}
+
+// Filter returns a copy of the pipeline reduced to the given stages (all of
+// their steps) and steps ("stage/step", or "step" for every stage with a
+// step of that name). Dependencies are resolved first, so the kept stages
+// and steps keep their order; a dependency on a dropped one is replaced by
+// its nearest kept ancestors (A -> B -> C filtered to A and C: C needs A).
+// No filter returns the pipeline unchanged.
+func (s *Scheduler) Filter(pipeline *Pipeline, stageNames, stepNames []string) (*Pipeline, error) {
+	if len(stageNames) == 0 && len(stepNames) == 0 {
+		return pipeline, nil
+	}
+	graph, err := s.StageGraph(pipeline)
+	if err != nil {
+		return nil, err
+	}
+
+	wholeStage := make(map[string]bool)
+	for _, name := range stageNames {
+		if findStage(pipeline, name) == nil {
+			return nil, fmt.Errorf("unknown stage %q", name)
+		}
+		wholeStage[name] = true
+	}
+	keepStep := make(map[StepRef]bool)
+	for _, name := range stepNames {
+		stage, step, scoped := strings.Cut(name, "/")
+		if !scoped {
+			stage, step = "", name
+		}
+		found := false
+		for _, st := range pipeline.Stages {
+			if scoped && st.Name != stage {
+				continue
+			}
+			for _, sp := range st.Steps {
+				if sp.Name == step {
+					keepStep[StepRef{Stage: st.Name, Step: sp.Name}] = true
+					found = true
+				}
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown step %q", name)
+		}
+	}
+
+	keepStage := make(map[string]bool)
+	for _, st := range pipeline.Stages {
+		if wholeStage[st.Name] {
+			keepStage[st.Name] = true
+		}
+		for _, sp := range st.Steps {
+			if keepStep[StepRef{Stage: st.Name, Step: sp.Name}] {
+				keepStage[st.Name] = true
+			}
+		}
+	}
+
+	filtered := &Pipeline{Name: pipeline.Name, Agent: pipeline.Agent}
+	for _, st := range pipeline.Stages {
+		if !keepStage[st.Name] {
+			continue
+		}
+		steps, err := s.StepGraph(st)
+		if err != nil {
+			return nil, err
+		}
+		stage := st
+		stage.Needs = nearestKept(graph.Needs(st.Name), graph.Needs, keepStage)
+		stage.Steps = nil
+		keep := make(map[string]bool)
+		for _, sp := range st.Steps {
+			keep[sp.Name] = wholeStage[st.Name] || keepStep[StepRef{Stage: st.Name, Step: sp.Name}]
+		}
+		for _, sp := range st.Steps {
+			if !keep[sp.Name] {
+				continue
+			}
+			sp.Needs = nearestKept(steps.Needs(sp.Name), steps.Needs, keep)
+			stage.Steps = append(stage.Steps, sp)
+		}
+		filtered.Stages = append(filtered.Stages, stage)
+	}
+	return filtered, nil
+}
+
+// nearestKept returns the kept names among names, replacing each dropped
+// one by its own nearest kept dependencies. The result is non-nil so the
+// implicit "previous one" dependency rule does not apply again.
+func nearestKept(names []string, needs func(string) []string, keep map[string]bool) []string {
+	kept := []string{}
+	seen := make(map[string]bool)
+	var walk func(names []string)
+	walk = func(names []string) {
+		for _, n := range names {
+			if seen[n] {
+				continue
+			}
+			seen[n] = true
+			if keep[n] {
+				kept = append(kept, n)
+			} else {
+				walk(needs(n))
+			}
+		}
+	}
+	walk(names)
+	return kept
+}
+
+func findStage(pipeline *Pipeline, name string) *Stage {
+	for i := range pipeline.Stages {
+		if pipeline.Stages[i].Name == name {
+			return &pipeline.Stages[i]
+		}
+	}
+	return nil
+}
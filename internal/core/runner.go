@@ -6,11 +6,16 @@ import (
 	"blockci-q/pkg/utils"
 	"crypto/ed25519"
 	"fmt"
+	"strings"
+	"sync"
 	"time"
 )
 
 // Runner ties together Parser + Scheduler + Executor + storage
-// (agent no longer appends to ledger)
+// (agent no longer appends to ledger). PrivKey is the agent's persistent
+// key used to sign job results; it is set by the agent after loading it.
+// OnStep, when set, is called by RunPipeline after each step finished and
+// its log was saved; steps of different stages may report concurrently.
 type Runner struct {
 	Scheduler  *Scheduler
 	Executor   *Executor
@@ -18,62 +23,208 @@ type Runner struct {
 	PrivKey    ed25519.PrivateKey
 	PubKey     ed25519.PublicKey
 	AgentID    string
+	OnStep     func(stage string, step Step, res StepResult)
 }
 
 func NewRunner() *Runner {
-	pub, priv, err := security.GenerateKeyPair()
-	if err != nil {
-		panic(err)
-	}
-
 	return &Runner{
 		Scheduler:  NewScheduler(),
 		Executor:   NewExecutor(),
 		LogStorage: storage.NewLogStorage("./logs"),
-		PrivKey:    priv,
-		PubKey:     pub,
 		AgentID:    "agent-1",
 	}
 }
 
-// RunPipeline executes all stages sequentially
+// RunPipeline executes the stage graph: a stage starts as soon as every stage
+// it needs has finished, so independent stages run concurrently.
 // Returns: map[stepID] → logPath
 func (r *Runner) RunPipeline(pipeline *Pipeline) (map[string]string, error) {
 	fmt.Printf("Starting pipeline on agent: %s\n", pipeline.Agent)
 
+	graph, err := r.Scheduler.StageGraph(pipeline)
+	if err != nil {
+		return nil, err
+	}
+	stages := make(map[string]Stage, len(pipeline.Stages))
+	for _, st := range pipeline.Stages {
+		stages[st.Name] = st
+	}
+
+	var mu sync.Mutex
 	results := make(map[string]string)
 
-	for i, stage := range pipeline.Stages {
-		fmt.Printf("\n==> Stage %d: %s\n", i+1, stage.Name)
+	type stageResult struct {
+		name string
+		err  error
+	}
+	finished := make(chan stageResult)
+	done := make(map[string]bool)
+	started := make(map[string]bool)
+	running := 0
+	var firstErr error
+
+	for {
+		// stop releasing new stages once something failed
+		if firstErr == nil {
+			for _, name := range graph.Ready(done, started) {
+				started[name] = true
+				running++
+				go func(stage Stage) {
+					finished <- stageResult{name: stage.Name, err: r.runStage(stage, results, &mu)}
+				}(stages[name])
+			}
+		}
+		if running == 0 {
+			break
+		}
+		res := <-finished
+		running--
+		if res.err != nil {
+			if firstErr == nil {
+				firstErr = res.err
+			}
+			continue
+		}
+		done[res.name] = true
+	}
+
+	if firstErr != nil {
+		return results, firstErr // stop pipeline on failure
+	}
+	fmt.Println("\nPipeline finished successfully (agent-side)")
+	return results, nil
+}
+
+// runStage executes the steps of one stage following its step graph.
+// Steps of a parallel stage that are ready together run concurrently;
+// the stage fails if any of them fails.
+func (r *Runner) runStage(stage Stage, results map[string]string, mu *sync.Mutex) error {
+	fmt.Printf("\n==> Stage: %s\n", stage.Name)
+
+	graph, err := r.Scheduler.StepGraph(stage)
+	if err != nil {
+		return err
+	}
+	steps := make(map[string]Step, len(stage.Steps))
+	for _, st := range stage.Steps {
+		steps[st.Name] = st
+	}
+
+	done := make(map[string]bool)
+	for {
+		ready := graph.Ready(done, nil)
+		if len(ready) == 0 {
+			return nil
+		}
+		if !stage.Parallel {
+			ready = ready[:1]
+		}
 
-		steps := r.Scheduler.GetNextSteps(pipeline, i)
-		for _, step := range steps {
-			stepID := fmt.Sprintf("%s/%s", stage.Name, step.Name)
-			fmt.Printf("Running step: %s\n", stepID)
+		batch := make([]Step, len(ready))
+		for i, name := range ready {
+			batch[i] = steps[name]
+			fmt.Printf("Running step: %s/%s\n", stage.Name, name)
+		}
 
-			// Run the actual step
-			output, err := r.Executor.RunStep(step, 5*time.Minute)
-			fmt.Println("Output:\n", output)
+		// Run the actual steps
+		var failed []string
+		var firstErr error
+		for _, out := range r.Executor.RunSteps(batch, stage.MaxParallel, 5*time.Minute) {
+			stepID := fmt.Sprintf("%s/%s", stage.Name, out.Step.Name)
+			fmt.Printf("Output (%s):\n %s\n", stepID, out.Output)
 
-			// Save log locally
-			logPath, logErr := r.LogStorage.SaveLog(stage.Name, step.Name, output)
+			// Save log locally, one file per step
+			logPath, logErr := r.LogStorage.SaveLog(stage.Name, out.Step.Name, out.Output)
 			if logErr != nil {
 				fmt.Printf("⚠️ Failed to save logs: %v\n", logErr)
 			} else {
 				fmt.Printf("Log saved at: %s\n", logPath)
+				mu.Lock()
 				results[stepID] = logPath
+				mu.Unlock()
+			}
+			if r.OnStep != nil {
+				r.OnStep(stage.Name, out.Step, StepResult{
+					LogPath:    logPath,
+					Output:     out.Output,
+					State:      StateForError(out.Err),
+					ExitCode:   ExitCode(out.Err),
+					StartedAt:  out.StartedAt,
+					FinishedAt: out.FinishedAt,
+					Err:        out.Err,
+				})
 			}
 
-			if err != nil {
-				fmt.Printf("❌ Step failed: %v\n", err)
-				return results, err // stop pipeline on failure
+			if out.Err != nil {
+				fmt.Printf("❌ Step %s failed: %v\n", stepID, out.Err)
+				failed = append(failed, out.Step.Name)
+				if firstErr == nil {
+					firstErr = out.Err
+				}
+				continue
 			}
-			fmt.Println("  ✔ Step completed successfully")
+			fmt.Printf("  ✔ Step %s completed successfully\n", stepID)
+			done[out.Step.Name] = true
+		}
+
+		if len(failed) > 0 {
+			return fmt.Errorf("stage %s: step(s) failed: %s: %w", stage.Name, strings.Join(failed, ", "), firstErr)
 		}
 	}
+}
 
-	fmt.Println("\nPipeline finished successfully (agent-side)")
-	return results, nil
+// StepResult describes one executed step
+type StepResult struct {
+	LogPath    string
+	Output     string
+	State      State
+	ExitCode   int
+	StartedAt  time.Time
+	FinishedAt time.Time
+	Err        error
+}
+
+// RunStep executes a single step (one job) and saves its log.
+// The log of a failed step is saved too.
+func (r *Runner) RunStep(stage string, step Step) StepResult {
+	fmt.Printf("Running step: %s/%s\n", stage, step.Name)
+
+	res := StepResult{StartedAt: time.Now().UTC()}
+	res.Output, res.Err = r.Executor.RunStep(step, 5*time.Minute)
+	res.FinishedAt = time.Now().UTC()
+	res.State = StateForError(res.Err)
+	res.ExitCode = ExitCode(res.Err)
+	fmt.Println("Output:\n", res.Output)
+
+	logPath, logErr := r.LogStorage.SaveLog(stage, step.Name, res.Output)
+	if logErr != nil {
+		fmt.Printf("⚠️ Failed to save logs: %v\n", logErr)
+	} else {
+		fmt.Printf("Log saved at: %s\n", logPath)
+		res.LogPath = logPath
+	}
+
+	if res.Err != nil {
+		fmt.Printf("❌ Step failed: %v\n", res.Err)
+	} else {
+		fmt.Println("  ✔ Step completed successfully")
+	}
+	return res
+}
+
+// SignResult builds the result envelope of a finished job and signs it
+// with the agent key. Returns the envelope and the hex signature.
+func (r *Runner) SignResult(jobID, logHash string, res StepResult) (security.ResultEnvelope, string, error) {
+	if len(r.PrivKey) == 0 {
+		return security.ResultEnvelope{}, "", fmt.Errorf("agent private key is not loaded")
+	}
+	env := security.ResultEnvelope{
+		JobID:     jobID,
+		LogHash:   logHash,
+		ExitCode:  res.ExitCode,
+		Timestamp: res.FinishedAt.Format(time.RFC3339Nano),
+	}
+	return env, security.SignResult(r.PrivKey, env), nil
 }
 
 func ComputeLogHash(path string)(string, error){
@@ -1,17 +1,312 @@
 package core
 
- // Schedular decides execution order of stages and jobs
- type Scheduler struct {}
+import (
+	"fmt"
+	"strings"
+)
 
- // NewScheduler creates a new scheduler
- func NewScheduler () *Scheduler{
+// Scheduler decides execution order of stages and steps.
+// Stages and steps form dependency graphs built from their `needs:` lists.
+type Scheduler struct{}
+
+// NewScheduler creates a new scheduler
+func NewScheduler() *Scheduler {
 	return &Scheduler{}
- }
+}
 
- // GetNextJobs returns jobs for the current stage
- func (s *Scheduler) GetNextSteps(pipeline *Pipeline, stageIndex int) []Step {
+// GetNextSteps returns the steps of the stage at stageIndex
+func (s *Scheduler) GetNextSteps(pipeline *Pipeline, stageIndex int) []Step {
 	if stageIndex >= len(pipeline.Stages) {
 		return nil
 	}
 	return pipeline.Stages[stageIndex].Steps
- }
\ No newline at end of file
+}
+
+// Graph is a dependency graph over named nodes (stages, or steps of a stage).
+type Graph struct {
+	order []string            // declaration order
+	needs map[string][]string // node -> nodes it depends on
+}
+
+// CycleError reports a dependency cycle; Path starts and ends with the
+// same node
+type CycleError struct {
+	Kind string // "stage" or "step"
+	Path []string
+}
+
+func (e *CycleError) Error() string {
+	return fmt.Sprintf("%s dependency cycle: %s", e.Kind, strings.Join(e.Path, " -> "))
+}
+
+// newGraph validates the dependencies and rejects unknown names and cycles.
+// kind is only used in error messages ("stage", "step").
+func newGraph(kind string, names []string, needs map[string][]string) (*Graph, error) {
+	g := &Graph{order: names, needs: needs}
+
+	known := make(map[string]bool, len(names))
+	for _, n := range names {
+		if known[n] {
+			return nil, fmt.Errorf("duplicate %s name %q", kind, n)
+		}
+		known[n] = true
+	}
+	for _, n := range names {
+		for _, dep := range needs[n] {
+			if !known[dep] {
+				return nil, fmt.Errorf("%s %q needs unknown %s %q", kind, n, kind, dep)
+			}
+		}
+	}
+
+	// DFS cycle detection: 1 = visiting, 2 = done
+	state := make(map[string]int, len(names))
+	var path []string
+	var visit func(n string) error
+	visit = func(n string) error {
+		switch state[n] {
+		case 1:
+			start := 0
+			for i, p := range path {
+				if p == n {
+					start = i
+				}
+			}
+			return &CycleError{Kind: kind, Path: append(append([]string{}, path[start:]...), n)}
+		case 2:
+			return nil
+		}
+		state[n] = 1
+		path = append(path, n)
+		for _, dep := range needs[n] {
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		state[n] = 2
+		return nil
+	}
+	for _, n := range names {
+		if err := visit(n); err != nil {
+			return nil, err
+		}
+	}
+	return g, nil
+}
+
+// Needs returns the direct dependencies of a node
+func (g *Graph) Needs(name string) []string {
+	return g.needs[name]
+}
+
+// Ready returns the nodes that have not started yet and whose dependencies
+// are all done, in declaration order.
+func (g *Graph) Ready(done, started map[string]bool) []string {
+	var ready []string
+	for _, n := range g.order {
+		if done[n] || started[n] {
+			continue
+		}
+		ok := true
+		for _, dep := range g.needs[n] {
+			if !done[dep] {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			ready = append(ready, n)
+		}
+	}
+	return ready
+}
+
+// Order returns a topological order of the graph, stable w.r.t. declaration order
+func (g *Graph) Order() []string {
+	done := make(map[string]bool, len(g.order))
+	order := make([]string, 0, len(g.order))
+	for len(order) < len(g.order) {
+		ready := g.Ready(done, nil)
+		for _, n := range ready {
+			done[n] = true
+		}
+		order = append(order, ready...)
+	}
+	return order
+}
+
+// resolveNeeds applies the default rule: a node without an explicit `needs:`
+// depends on the node declared right before it, unless linear is false.
+func resolveNeeds(names []string, explicit [][]string, linear bool) map[string][]string {
+	needs := make(map[string][]string, len(names))
+	for i, n := range names {
+		switch {
+		case explicit[i] != nil:
+			needs[n] = explicit[i]
+		case linear && i > 0:
+			needs[n] = []string{names[i-1]}
+		}
+	}
+	return needs
+}
+
+// StageGraph builds the stage dependency graph of a pipeline
+func (s *Scheduler) StageGraph(pipeline *Pipeline) (*Graph, error) {
+	names := make([]string, len(pipeline.Stages))
+	explicit := make([][]string, len(pipeline.Stages))
+	for i, st := range pipeline.Stages {
+		names[i] = st.Name
+		explicit[i] = st.Needs
+	}
+	return newGraph("stage", names, resolveNeeds(names, explicit, true))
+}
+
+// StepGraph builds the step dependency graph inside one stage.
+// Steps of a parallel stage only depend on what they explicitly need.
+func (s *Scheduler) StepGraph(stage Stage) (*Graph, error) {
+	names := make([]string, len(stage.Steps))
+	explicit := make([][]string, len(stage.Steps))
+	for i, st := range stage.Steps {
+		names[i] = st.Name
+		explicit[i] = st.Needs
+	}
+	g, err := newGraph("step", names, resolveNeeds(names, explicit, !stage.Parallel))
+	if err != nil {
+		return nil, fmt.Errorf("stage %q: %w", stage.Name, err)
+	}
+	return g, nil
+}
+
+// Validate builds every graph of the pipeline and reports the first problem
+func (s *Scheduler) Validate(pipeline *Pipeline) error {
+	if _, err := s.StageGraph(pipeline); err != nil {
+		return err
+	}
+	for _, st := range pipeline.Stages {
+		if st.MaxParallel < 0 {
+			return fmt.Errorf("stage %q: max_parallel must not be negative", st.Name)
+		}
+		if _, err := s.StepGraph(st); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StepRef identifies a step inside a pipeline
+type StepRef struct {
+	Stage string
+	Step  string
+}
+
+// Key returns the "stage:step" key used for step status maps
+func (r StepRef) Key() string {
+	return fmt.Sprintf("%s:%s", r.Stage, r.Step)
+}
+
+// Progress tracks which steps of a pipeline finished and releases the ones
+// whose stage and step dependencies are satisfied.
+type Progress struct {
+	pipeline  *Pipeline
+	stages    *Graph
+	steps     map[string]*Graph
+	limits    map[string]int // stage -> max_parallel (0 = no limit)
+	stageDone map[string]bool
+	stepDone  map[string]map[string]bool
+	released  map[string]map[string]bool
+}
+
+// Track builds a Progress for the pipeline
+func (s *Scheduler) Track(pipeline *Pipeline) (*Progress, error) {
+	stages, err := s.StageGraph(pipeline)
+	if err != nil {
+		return nil, err
+	}
+	p := &Progress{
+		pipeline:  pipeline,
+		stages:    stages,
+		steps:     make(map[string]*Graph),
+		limits:    make(map[string]int),
+		stageDone: make(map[string]bool),
+		stepDone:  make(map[string]map[string]bool),
+		released:  make(map[string]map[string]bool),
+	}
+	for _, st := range pipeline.Stages {
+		g, err := s.StepGraph(st)
+		if err != nil {
+			return nil, err
+		}
+		p.steps[st.Name] = g
+		p.limits[st.Name] = st.MaxParallel
+		p.stepDone[st.Name] = make(map[string]bool)
+		p.released[st.Name] = make(map[string]bool)
+	}
+	return p, nil
+}
+
+// Next returns the steps that became runnable since the last call.
+// Returned steps are considered released and are not returned again.
+// A stage never has more than its max_parallel steps in flight.
+func (p *Progress) Next() []StepRef {
+	var refs []StepRef
+	for changed := true; changed; {
+		changed = false
+		for _, stage := range p.stages.Ready(p.stageDone, nil) {
+			ready := p.steps[stage].Ready(p.stepDone[stage], p.released[stage])
+			if limit := p.limits[stage]; limit > 0 {
+				inFlight := len(p.released[stage]) - len(p.stepDone[stage])
+				if free := limit - inFlight; free < len(ready) {
+					ready = ready[:max(free, 0)]
+				}
+			}
+			for _, step := range ready {
+				p.released[stage][step] = true
+				refs = append(refs, StepRef{Stage: stage, Step: step})
+			}
+			if !p.stageDone[stage] && len(p.stepDone[stage]) == len(p.steps[stage].order) {
+				// only possible here for stages without steps
+				p.stageDone[stage] = true
+				changed = true
+			}
+		}
+	}
+	return refs
+}
+
+// Complete marks a released step as finished
+func (p *Progress) Complete(ref StepRef) {
+	done, ok := p.stepDone[ref.Stage]
+	if !ok {
+		return
+	}
+	done[ref.Step] = true
+	if len(done) == len(p.steps[ref.Stage].order) {
+		p.stageDone[ref.Stage] = true
+	}
+}
+
+// Restore replays a step state recorded before a restart: succeeded steps
+// count as complete, queued and running ones as released.
+func (p *Progress) Restore(ref StepRef, state State) {
+	if _, ok := p.released[ref.Stage]; !ok {
+		return
+	}
+	switch state {
+	case StateSucceeded:
+		p.released[ref.Stage][ref.Step] = true
+		p.Complete(ref)
+	case StateQueued, StateRunning:
+		p.released[ref.Stage][ref.Step] = true
+	}
+}
+
+// StageDone reports whether every step of the stage finished
+func (p *Progress) StageDone(stage string) bool {
+	return p.stageDone[stage]
+}
+
+// Done reports whether every stage finished
+func (p *Progress) Done() bool {
+	return len(p.stageDone) == len(p.stages.order)
+}
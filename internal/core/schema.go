@@ -0,0 +1,129 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+)
+
+// PipelineVersion is the current pipeline schema version. Documents without
+// a `version:` key were written before it existed and are version 0.
+const PipelineVersion = 1
+
+// pipelineSchema is one registered version of the pipeline document.
+// upgrade rewrites a document of that version in place into the next
+// version; the current version has none and decodes into Pipeline.
+type pipelineSchema struct {
+	upgrade func(root *yaml.Node) error
+}
+
+// pipelineSchemas is the registry of readable versions. A new version adds
+// an entry here, the upgrade of the previous one, and bumps PipelineVersion.
+var pipelineSchemas = map[int]pipelineSchema{
+	0: {upgrade: func(root *yaml.Node) error { // same shape, unversioned
+		setVersion(root, 1)
+		return nil
+	}},
+	1: {},
+}
+
+// loadDocument parses a pipeline document and upgrades it to the current
+// version. Upgrades keep the positions of the original nodes, so problems
+// found later still point into the file as written. It returns the
+// document node and the version the document was written in.
+func loadDocument(data []byte) (*yaml.Node, int, ValidationErrors) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, 0, ValidationErrors{syntaxError(err)}
+	}
+	if len(doc.Content) == 0 {
+		return nil, 0, ValidationErrors{{Line: 1, Column: 1, Message: "pipeline is empty"}}
+	}
+	root := resolve(doc.Content[0])
+	if root.Kind != yaml.MappingNode {
+		return &doc, PipelineVersion, nil // reported by the validator
+	}
+
+	from, errs := documentVersion(root)
+	if errs != nil {
+		return nil, from, errs
+	}
+	for v := from; v < PipelineVersion; v++ {
+		if err := pipelineSchemas[v].upgrade(root); err != nil {
+			return nil, from, ValidationErrors{{Line: root.Line, Column: root.Column, Message: fmt.Sprintf("upgrade from version %d: %v", v, err)}}
+		}
+	}
+	return &doc, from, nil
+}
+
+// documentVersion reads the `version:` of a pipeline mapping (0 when absent)
+func documentVersion(root *yaml.Node) (int, ValidationErrors) {
+	value := mappingValue(root, "version")
+	if value == nil {
+		return 0, nil
+	}
+	var version int
+	if err := resolve(value).Decode(&version); err != nil {
+		return 0, ValidationErrors{{Line: value.Line, Column: value.Column, Path: "version", Message: "must be an integer"}}
+	}
+	if _, ok := pipelineSchemas[version]; !ok {
+		return version, ValidationErrors{{Line: value.Line, Column: value.Column, Path: "version",
+			Message: fmt.Sprintf("unsupported pipeline version %d (this build reads versions up to %d)", version, PipelineVersion)}}
+	}
+	return version, nil
+}
+
+// setVersion sets the `version:` of a pipeline mapping, adding it as the
+// first key (above the comment of the old first key) when missing
+func setVersion(root *yaml.Node, version int) {
+	value := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: strconv.Itoa(version)}
+	if old := mappingValue(root, "version"); old != nil {
+		value.Line, value.Column = old.Line, old.Column
+		*old = *value
+		return
+	}
+	key := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "version"}
+	if len(root.Content) > 0 {
+		key.HeadComment, root.Content[0].HeadComment = root.Content[0].HeadComment, ""
+	}
+	root.Content = append([]*yaml.Node{key, value}, root.Content...)
+}
+
+// mappingValue returns the value of the first key in a mapping node
+func mappingValue(n *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return n.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// MigratePipeline rewrites a pipeline document in the current schema
+// version, keeping its comments. A document that already is current is
+// returned unchanged. It also returns the version the document had.
+func MigratePipeline(data []byte) ([]byte, int, error) {
+	doc, from, errs := loadDocument(data)
+	if errs == nil {
+		errs = validateDocument(doc)
+	}
+	if errs != nil {
+		return nil, from, errs
+	}
+	if from == PipelineVersion {
+		return data, from, nil
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(doc); err != nil {
+		return nil, from, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, from, err
+	}
+	return buf.Bytes(), from, nil
+}
@@ -0,0 +1,88 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+)
+
+// State is the lifecycle state of a step (job) or of a whole pipeline
+type State string
+
+const (
+	StatePending   State = "pending"   // waiting on dependencies
+	StateQueued    State = "queued"    // released, waiting for an agent
+	StateRunning   State = "running"   // picked up by an agent
+	StateSucceeded State = "succeeded" // finished with exit code 0
+	StateFailed    State = "failed"    // finished with an error
+	StateCancelled State = "cancelled" // dropped before it finished
+	StateTimedOut  State = "timed_out" // killed after its timeout
+	StateSkipped   State = "skipped"   // never ran because a dependency failed
+)
+
+// Terminal reports whether no further transition is possible
+func (s State) Terminal() bool {
+	switch s {
+	case StateSucceeded, StateFailed, StateCancelled, StateTimedOut, StateSkipped:
+		return true
+	}
+	return false
+}
+
+// StateMachine lists the allowed transitions between states
+type StateMachine struct {
+	name        string
+	transitions map[State][]State
+}
+
+// StepStates is the state machine of a single step (job)
+var StepStates = StateMachine{
+	name: "step",
+	transitions: map[State][]State{
+		StatePending: {StateQueued, StateCancelled, StateSkipped},
+		StateQueued:  {StateRunning, StateCancelled},
+		StateRunning: {StateSucceeded, StateFailed, StateTimedOut, StateCancelled},
+	},
+}
+
+// PipelineStates is the state machine of a pipeline run
+var PipelineStates = StateMachine{
+	name: "pipeline",
+	transitions: map[State][]State{
+		StatePending: {StateQueued, StateSucceeded, StateCancelled},
+		StateQueued:  {StateRunning, StateCancelled},
+		StateRunning: {StateSucceeded, StateFailed, StateTimedOut, StateCancelled},
+	},
+}
+
+// CanTransition reports whether from -> to is allowed
+func (m StateMachine) CanTransition(from, to State) bool {
+	for _, next := range m.transitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Transition returns an error when from -> to is not allowed
+func (m StateMachine) Transition(from, to State) error {
+	if !m.CanTransition(from, to) {
+		return fmt.Errorf("invalid %s transition %s -> %s", m.name, from, to)
+	}
+	return nil
+}
+
+// ErrStepTimeout is returned by the executor when a step exceeds its timeout
+var ErrStepTimeout = errors.New("step timed out")
+
+// StateForError maps the error of a finished step to its terminal state
+func StateForError(err error) State {
+	switch {
+	case err == nil:
+		return StateSucceeded
+	case errors.Is(err, ErrStepTimeout):
+		return StateTimedOut
+	default:
+		return StateFailed
+	}
+}
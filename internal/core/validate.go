@@ -0,0 +1,335 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ValidationError is one problem in a pipeline definition, positioned at
+// the YAML node it was found at (Column is 0 for YAML syntax errors)
+type ValidationError struct {
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	Path    string `json:"path,omitempty"` // e.g. stages[1].steps[0].run
+	Message string `json:"message"`
+}
+
+func (e *ValidationError) Error() string {
+	pos := fmt.Sprintf("line %d, column %d", e.Line, e.Column)
+	if e.Column == 0 {
+		pos = fmt.Sprintf("line %d", e.Line)
+	}
+	if e.Path == "" {
+		return pos + ": " + e.Message
+	}
+	return pos + ": " + e.Path + ": " + e.Message
+}
+
+// ValidationErrors is every problem found in a pipeline, in file order
+type ValidationErrors []*ValidationError
+
+func (errs ValidationErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, e := range errs {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Allowed keys of each pipeline level
+var (
+	pipelineKeys = []string{"version", "name", "agent", "stages"}
+	stageKeys    = []string{"name", "needs", "parallel", "max_parallel", "steps"}
+	stepKeys     = []string{"name", "run", "needs"}
+)
+
+// ValidatePipeline checks a pipeline definition against the schema and
+// returns every problem (nil when it is valid): YAML syntax, unsupported
+// versions, unknown or duplicate keys, wrong types, missing names and
+// commands, duplicate stage or step names, unknown or cyclic `needs:`.
+// Older schema versions are upgraded first, see loadDocument.
+func ValidatePipeline(data []byte) ValidationErrors {
+	doc, _, errs := loadDocument(data)
+	if errs != nil {
+		return errs
+	}
+	return validateDocument(doc)
+}
+
+// validateDocument validates a document of the current schema version
+func validateDocument(doc *yaml.Node) ValidationErrors {
+	v := &validator{}
+	v.pipeline(doc.Content[0])
+	sort.SliceStable(v.errs, func(i, j int) bool {
+		if v.errs[i].Line != v.errs[j].Line {
+			return v.errs[i].Line < v.errs[j].Line
+		}
+		return v.errs[i].Column < v.errs[j].Column
+	})
+	return v.errs
+}
+
+// syntaxError positions a yaml.v3 error ("yaml: line 3: ...") at its line
+func syntaxError(err error) *ValidationError {
+	msg := strings.TrimPrefix(err.Error(), "yaml: ")
+	var line int
+	if n, _ := fmt.Sscanf(msg, "line %d:", &line); n == 1 {
+		msg = strings.TrimSpace(msg[strings.Index(msg, ":")+1:])
+	}
+	return &ValidationError{Line: line, Message: msg}
+}
+
+type validator struct {
+	errs ValidationErrors
+}
+
+func (v *validator) add(n *yaml.Node, path, format string, args ...interface{}) {
+	v.errs = append(v.errs, &ValidationError{Line: n.Line, Column: n.Column, Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+// graphNode is a named stage or step with the nodes its errors point at
+type graphNode struct {
+	path     string
+	name     string
+	nameNode *yaml.Node
+	needs    []string
+	needNode []*yaml.Node // one per entry of needs
+	explicit bool         // needs: was given
+}
+
+func (v *validator) pipeline(root *yaml.Node) {
+	fields := v.mapping(root, "", "pipeline", pipelineKeys)
+	if fields == nil {
+		return
+	}
+	v.str(fields["name"], "name")
+	v.str(fields["agent"], "agent")
+
+	stages := fields["stages"]
+	if stages == nil {
+		v.add(root, "", "stages is required")
+		return
+	}
+	items := v.sequence(stages, "stages")
+	if items != nil && len(items) == 0 {
+		v.add(stages, "stages", "pipeline has no stages")
+	}
+
+	var nodes []graphNode
+	for i, item := range items {
+		if n, ok := v.stage(item, fmt.Sprintf("stages[%d]", i)); ok {
+			nodes = append(nodes, n)
+		}
+	}
+	v.graph("stage", nodes, true)
+}
+
+// stage validates one stage; ok is false when its name is unusable
+func (v *validator) stage(n *yaml.Node, path string) (graphNode, bool) {
+	fields := v.mapping(n, path, "stage", stageKeys)
+	if fields == nil {
+		return graphNode{}, false
+	}
+	st, ok := v.named(n, fields, path)
+	parallel := false
+	if p := fields["parallel"]; p != nil && resolve(p).Decode(&parallel) != nil {
+		v.add(p, path+".parallel", "must be true or false")
+	}
+	if mp := fields["max_parallel"]; mp != nil {
+		var limit int
+		if err := resolve(mp).Decode(&limit); err != nil {
+			v.add(mp, path+".max_parallel", "must be an integer")
+		} else if limit < 0 {
+			v.add(mp, path+".max_parallel", "must not be negative")
+		}
+	}
+
+	var steps []graphNode
+	items := v.sequence(fields["steps"], path+".steps")
+	for i, item := range items {
+		if s, ok := v.step(item, fmt.Sprintf("%s.steps[%d]", path, i)); ok {
+			steps = append(steps, s)
+		}
+	}
+	v.graph("step", steps, !parallel)
+	return st, ok
+}
+
+// step validates one step; ok is false when its name is unusable
+func (v *validator) step(n *yaml.Node, path string) (graphNode, bool) {
+	fields := v.mapping(n, path, "step", stepKeys)
+	if fields == nil {
+		return graphNode{}, false
+	}
+	st, ok := v.named(n, fields, path)
+	if run := fields["run"]; run == nil {
+		v.add(n, path, "run is required")
+	} else if cmd, isStr := v.str(run, path+".run"); isStr && strings.TrimSpace(cmd) == "" {
+		v.add(run, path+".run", "must not be empty")
+	}
+	return st, ok
+}
+
+// named reads the name and needs of a stage or step
+func (v *validator) named(n *yaml.Node, fields map[string]*yaml.Node, path string) (graphNode, bool) {
+	st := graphNode{path: path, nameNode: fields["name"]}
+	ok := false
+	if st.nameNode == nil {
+		v.add(n, path, "name is required")
+	} else if st.name, ok = v.str(st.nameNode, path+".name"); ok && strings.TrimSpace(st.name) == "" {
+		v.add(st.nameNode, path+".name", "must not be empty")
+		ok = false
+	}
+	if needs := fields["needs"]; needs != nil {
+		st.explicit = true
+		for i, item := range v.sequence(needs, path+".needs") {
+			if name, isStr := v.str(item, fmt.Sprintf("%s.needs[%d]", path, i)); isStr {
+				st.needs = append(st.needs, name)
+				st.needNode = append(st.needNode, item)
+			} else {
+				ok = false
+			}
+		}
+	}
+	return st, ok
+}
+
+// graph checks the names and needs of sibling stages or steps: duplicates,
+// unknown or self references and cycles. Cycles are looked for among the
+// remaining valid references.
+func (v *validator) graph(kind string, nodes []graphNode, linear bool) {
+	first := make(map[string]*yaml.Node, len(nodes))
+	var unique []graphNode
+	for _, n := range nodes {
+		if prev, dup := first[n.name]; dup {
+			v.add(n.nameNode, n.path+".name", "duplicate %s name %q (first defined on line %d)", kind, n.name, prev.Line)
+			continue
+		}
+		first[n.name] = n.nameNode
+		unique = append(unique, n)
+	}
+
+	names := make([]string, len(unique))
+	explicit := make([][]string, len(unique))
+	byName := make(map[string]graphNode, len(unique))
+	for i, n := range unique {
+		names[i] = n.name
+		byName[n.name] = n
+		if n.explicit {
+			explicit[i] = []string{}
+		}
+	}
+	for _, n := range nodes {
+		for i, dep := range n.needs {
+			switch {
+			case dep == n.name:
+				v.add(n.needNode[i], fmt.Sprintf("%s.needs[%d]", n.path, i), "%s %q needs itself", kind, n.name)
+			case first[dep] == nil:
+				v.add(n.needNode[i], fmt.Sprintf("%s.needs[%d]", n.path, i), "%s %q needs unknown %s %q", kind, n.name, kind, dep)
+			case first[n.name] == n.nameNode:
+				j := indexOf(names, n.name)
+				explicit[j] = append(explicit[j], dep)
+			}
+		}
+	}
+
+	_, err := newGraph(kind, names, resolveNeeds(names, explicit, linear))
+	var cycle *CycleError
+	if errors.As(err, &cycle) {
+		start := byName[cycle.Path[0]]
+		at, atPath := start.nameNode, start.path+".name"
+		if len(start.needNode) > 0 {
+			at, atPath = start.needNode[0], start.path+".needs"
+		}
+		v.add(at, atPath, "%s", cycle.Error())
+	}
+}
+
+// mapping returns the values of a mapping node by key, reporting unknown
+// and duplicate keys; nil when n is not a mapping
+func (v *validator) mapping(n *yaml.Node, path, what string, allowed []string) map[string]*yaml.Node {
+	n = resolve(n)
+	if n.Kind != yaml.MappingNode {
+		v.add(n, path, "%s must be a mapping", what)
+		return nil
+	}
+	fields := make(map[string]*yaml.Node)
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key, value := n.Content[i], n.Content[i+1]
+		keyPath := joinPath(path, key.Value)
+		switch {
+		case !contains(allowed, key.Value):
+			v.add(key, keyPath, "unknown key %q in %s (allowed: %s)", key.Value, what, strings.Join(allowed, ", "))
+		case fields[key.Value] != nil:
+			v.add(key, keyPath, "duplicate key %q (first defined on line %d)", key.Value, fields[key.Value].Line)
+		default:
+			fields[key.Value] = value
+		}
+	}
+	return fields
+}
+
+// sequence returns the items of a sequence node; nil for a missing or null
+// node, reported when n is anything else
+func (v *validator) sequence(n *yaml.Node, path string) []*yaml.Node {
+	if n == nil || isNull(n) {
+		return nil
+	}
+	n = resolve(n)
+	if n.Kind != yaml.SequenceNode {
+		v.add(n, path, "must be a list")
+		return nil
+	}
+	return n.Content
+}
+
+// str returns the value of a string scalar; a missing or null node is an
+// empty string, anything other than a scalar is reported
+func (v *validator) str(n *yaml.Node, path string) (string, bool) {
+	if n == nil || isNull(n) {
+		return "", true
+	}
+	n = resolve(n)
+	if n.Kind != yaml.ScalarNode {
+		v.add(n, path, "must be a string")
+		return "", false
+	}
+	return n.Value, true
+}
+
+// resolve follows an alias to the node it refers to
+func resolve(n *yaml.Node) *yaml.Node {
+	for n.Kind == yaml.AliasNode && n.Alias != nil {
+		n = n.Alias
+	}
+	return n
+}
+
+func isNull(n *yaml.Node) bool {
+	n = resolve(n)
+	return n.Kind == yaml.ScalarNode && n.Tag == "!!null"
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func contains(list []string, s string) bool {
+	return indexOf(list, s) >= 0
+}
+
+func indexOf(list []string, s string) int {
+	for i, item := range list {
+		if item == s {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,52 @@
+package security
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+)
+
+// ResultEnvelope is what an agent signs when it reports a job result.
+// The server re-verifies it against the agent's trusted key and the ledger
+// keeps every field, so the agent signature can be checked later as well.
+type ResultEnvelope struct {
+	JobID     string `json:"jobId"`
+	LogHash   string `json:"logHash"`
+	ExitCode  int    `json:"exitCode"`
+	Timestamp string `json:"timestamp"` // RFC3339 end of the job
+}
+
+// Canonical returns the bytes that are signed
+func (e ResultEnvelope) Canonical() []byte {
+	// fixed field order, no optional fields: marshalling cannot fail
+	data, _ := json.Marshal(e)
+	return data
+}
+
+// SignResult signs a result envelope and returns the hex signature
+func SignResult(priv ed25519.PrivateKey, env ResultEnvelope) string {
+	return SignData(priv, env.Canonical())
+}
+
+// VerifyResult checks a hex signature over a result envelope
+func VerifyResult(pub ed25519.PublicKey, env ResultEnvelope, sigHex string) bool {
+	ok, err := VerifySignature(pub, env.Canonical(), sigHex)
+	return err == nil && ok
+}
+
+// RegistrationMessage is what an agent signs to answer a registration
+// challenge. It binds the nonce to the agent ID so it cannot be replayed
+// for another agent.
+func RegistrationMessage(agentID, nonce string) []byte {
+	return []byte("blockci-register:" + agentID + ":" + nonce)
+}
+
+// RandomToken returns n random bytes, hex-encoded (nonces, session tokens)
+func RandomToken(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
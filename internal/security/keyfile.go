@@ -0,0 +1,148 @@
+package security
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+var (
+	// ErrPassphraseRequired is returned when loading an encrypted key without a passphrase
+	ErrPassphraseRequired = errors.New("key file is encrypted, passphrase required")
+	// ErrWrongPassphrase is returned when an encrypted key cannot be opened
+	ErrWrongPassphrase = errors.New("wrong passphrase or corrupted key file")
+)
+
+// scrypt cost parameters for new key files; stored in the file so they can
+// be raised later without breaking existing keys
+const (
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+// keyFileAAD binds the ciphertext to this file format
+var keyFileAAD = []byte("blockci-encrypted-key-v1")
+
+// encryptedKeyFile is the on-disk form of a passphrase-protected private
+// key: scrypt derives an AES-256-GCM key from the passphrase
+type encryptedKeyFile struct {
+	KDF        string `json:"kdf"`
+	N          int    `json:"n"`
+	R          int    `json:"r"`
+	P          int    `json:"p"`
+	Salt       []byte `json:"salt"`
+	Cipher     string `json:"cipher"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// PassphraseFunc supplies a passphrase when one is needed. It returns nil
+// when no passphrase is available.
+type PassphraseFunc func() ([]byte, error)
+
+// EncryptPrivateKey seals a private key with a passphrase
+func EncryptPrivateKey(priv ed25519.PrivateKey, passphrase []byte) ([]byte, error) {
+	if len(passphrase) == 0 {
+		return nil, errors.New("empty passphrase")
+	}
+	file := encryptedKeyFile{KDF: "scrypt", N: scryptN, R: scryptR, P: scryptP, Cipher: "aes-256-gcm"}
+	file.Salt = make([]byte, 16)
+	if _, err := rand.Read(file.Salt); err != nil {
+		return nil, err
+	}
+	aead, err := file.aead(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	file.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(file.Nonce); err != nil {
+		return nil, err
+	}
+	file.Ciphertext = aead.Seal(nil, file.Nonce, priv, keyFileAAD)
+	return json.MarshalIndent(file, "", "  ")
+}
+
+// DecryptPrivateKey opens a key sealed by EncryptPrivateKey
+func DecryptPrivateKey(data, passphrase []byte) (ed25519.PrivateKey, error) {
+	var file encryptedKeyFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid encrypted key file: %w", err)
+	}
+	if file.KDF != "scrypt" || file.Cipher != "aes-256-gcm" {
+		return nil, fmt.Errorf("unsupported key encryption %s/%s", file.KDF, file.Cipher)
+	}
+	aead, err := file.aead(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if len(file.Nonce) != aead.NonceSize() {
+		return nil, ErrWrongPassphrase
+	}
+	keyBytes, err := aead.Open(nil, file.Nonce, file.Ciphertext, keyFileAAD)
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	if len(keyBytes) != ed25519.PrivateKeySize {
+		return nil, errors.New("invalid private key size")
+	}
+	return ed25519.PrivateKey(keyBytes), nil
+}
+
+func (f *encryptedKeyFile) aead(passphrase []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key(passphrase, f.Salt, f.N, f.R, f.P, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// IsEncryptedKey reports whether key file data is passphrase-protected
+// (plaintext key files hold hex)
+func IsEncryptedKey(data []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(data), []byte("{"))
+}
+
+// SaveEncryptedPrivateKey writes a passphrase-protected private key file
+func SaveEncryptedPrivateKey(priv ed25519.PrivateKey, path string, passphrase []byte) error {
+	data, err := EncryptPrivateKey(priv, passphrase)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+// LoadPrivateKeyFile loads a private key file, plaintext hex or encrypted.
+// passphrase is only called for encrypted files.
+func LoadPrivateKeyFile(path string, passphrase PassphraseFunc) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if !IsEncryptedKey(data) {
+		return LoadPrivateKey(path)
+	}
+	if passphrase == nil {
+		return nil, ErrPassphraseRequired
+	}
+	pass, err := passphrase()
+	if err != nil {
+		return nil, err
+	}
+	if len(pass) == 0 {
+		return nil, ErrPassphraseRequired
+	}
+	return DecryptPrivateKey(data, pass)
+}
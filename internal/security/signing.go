@@ -3,9 +3,12 @@ package security
 import (
 	"crypto/ed25519"
 	"crypto/rand"
+	"encoding/base64"
 	"encoding/hex"
 	"errors"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
 // Generate key pair creates a new ed25519 key pair (public+private)
@@ -85,4 +88,63 @@ func VerifySignatureFromHex(pubHex string,data []byte, sigHex string )(bool, err
 		return  false, errors.New("invalid public key size")
 	}
 	return VerifySignature(ed25519.PublicKey(pubBytes), data, sigHex)
-}
\ No newline at end of file
+}
+
+// DecodePublicKey parses a public key written as hex or base64
+// (tools/genkeys.go prints base64, key files hold hex)
+func DecodePublicKey(s string) (ed25519.PublicKey, error) {
+	keyBytes, err := decodeKey(s, ed25519.PublicKeySize)
+	if err != nil {
+		return nil, err
+	}
+	return ed25519.PublicKey(keyBytes), nil
+}
+
+// DecodePrivateKey parses a private key written as hex or base64
+func DecodePrivateKey(s string) (ed25519.PrivateKey, error) {
+	keyBytes, err := decodeKey(s, ed25519.PrivateKeySize)
+	if err != nil {
+		return nil, err
+	}
+	return ed25519.PrivateKey(keyBytes), nil
+}
+
+func decodeKey(s string, size int) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	if len(s) == 2*size {
+		if keyBytes, err := hex.DecodeString(s); err == nil {
+			return keyBytes, nil
+		}
+	}
+	keyBytes, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.New("key is neither hex nor base64")
+	}
+	if len(keyBytes) != size {
+		return nil, errors.New("invalid key size")
+	}
+	return keyBytes, nil
+}
+
+// EnsureKeyPair loads a key pair from hex files, generating and saving a new
+// one when the files do not exist yet. created reports a new pair.
+func EnsureKeyPair(pubPath, privPath string) (pub ed25519.PublicKey, priv ed25519.PrivateKey, created bool, err error) {
+	if _, err := os.Stat(privPath); os.IsNotExist(err) {
+		pub, priv, err := GenerateKeyPair()
+		if err != nil {
+			return nil, nil, false, err
+		}
+		if err := os.MkdirAll(filepath.Dir(privPath), 0700); err != nil {
+			return nil, nil, false, err
+		}
+		if err := SaveKeyPair(pub, priv, pubPath, privPath); err != nil {
+			return nil, nil, false, err
+		}
+		return pub, priv, true, nil
+	}
+	priv, err = LoadPrivateKey(privPath)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	return priv.Public().(ed25519.PublicKey), priv, false, nil
+}
@@ -0,0 +1,138 @@
+package security
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrUntrustedKey is returned for a key that is not in the trust store
+	ErrUntrustedKey = errors.New("key is not trusted")
+	// ErrKeyNotValid is returned for a trusted key used outside its validity window
+	ErrKeyNotValid = errors.New("key is outside its validity window")
+)
+
+// TrustedKey is an accepted signing key. A zero NotBefore or NotAfter
+// leaves that side of the validity window open.
+type TrustedKey struct {
+	Name      string
+	PubKey    ed25519.PublicKey
+	NotBefore time.Time
+	NotAfter  time.Time
+}
+
+// ValidAt reports whether the key may sign at t
+func (k TrustedKey) ValidAt(t time.Time) bool {
+	if !k.NotBefore.IsZero() && t.Before(k.NotBefore) {
+		return false
+	}
+	if !k.NotAfter.IsZero() && t.After(k.NotAfter) {
+		return false
+	}
+	return true
+}
+
+// TrustStore holds the pinned keys allowed to sign ledger blocks
+type TrustStore struct {
+	keys map[string][]TrustedKey // hex pubkey -> validity windows
+}
+
+// NewTrustStore creates an empty trust store
+func NewTrustStore() *TrustStore {
+	return &TrustStore{keys: make(map[string][]TrustedKey)}
+}
+
+// Add pins a key. Adding the same key again adds another validity window.
+func (ts *TrustStore) Add(key TrustedKey) {
+	id := hex.EncodeToString(key.PubKey)
+	ts.keys[id] = append(ts.keys[id], key)
+}
+
+// LoadDir pins every *.pub file in dir. The first line holds the key (hex
+// or base64); optional "not_before: <RFC3339>" and "not_after: <RFC3339>"
+// lines after it set its validity window. A missing directory is not an
+// error.
+func (ts *TrustStore) LoadDir(dir string) (int, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.pub"))
+	if err != nil {
+		return 0, err
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return 0, err
+		}
+		key, err := parseKeyFile(string(data))
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", file, err)
+		}
+		key.Name = strings.TrimSuffix(filepath.Base(file), ".pub")
+		ts.Add(key)
+	}
+	return len(files), nil
+}
+
+// parseKeyFile reads a trusted key file: the key, then its window
+func parseKeyFile(data string) (TrustedKey, error) {
+	lines := strings.Split(strings.TrimSpace(data), "\n")
+	pub, err := DecodePublicKey(strings.TrimSpace(lines[0]))
+	if err != nil {
+		return TrustedKey{}, err
+	}
+	key := TrustedKey{PubKey: pub}
+	for _, line := range lines[1:] {
+		field, value, ok := strings.Cut(line, ":")
+		if !ok {
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+			return TrustedKey{}, fmt.Errorf("invalid line %q", line)
+		}
+		at, err := time.Parse(time.RFC3339, strings.TrimSpace(value))
+		if err != nil {
+			return TrustedKey{}, fmt.Errorf("%s: %w", strings.TrimSpace(field), err)
+		}
+		switch strings.TrimSpace(field) {
+		case "not_before":
+			key.NotBefore = at
+		case "not_after":
+			key.NotAfter = at
+		default:
+			return TrustedKey{}, fmt.Errorf("unknown field %q", strings.TrimSpace(field))
+		}
+	}
+	if !key.NotAfter.IsZero() && key.NotAfter.Before(key.NotBefore) {
+		return TrustedKey{}, fmt.Errorf("not_after is before not_before")
+	}
+	return key, nil
+}
+
+// Len returns the number of distinct pinned keys
+func (ts *TrustStore) Len() int {
+	return len(ts.keys)
+}
+
+// Contains reports whether the hex key is pinned, whatever its windows
+func (ts *TrustStore) Contains(pubHex string) bool {
+	return len(ts.keys[pubHex]) > 0
+}
+
+// Check returns nil when the hex key is pinned and valid at t
+func (ts *TrustStore) Check(pubHex string, at time.Time) error {
+	windows := ts.keys[pubHex]
+	if len(windows) == 0 {
+		return ErrUntrustedKey
+	}
+	for _, k := range windows {
+		if k.ValidAt(at) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w at %s", ErrKeyNotValid, at.Format(time.RFC3339))
+}
@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// ContentStore keeps blobs (job logs) addressed by their SHA-256:
+// <BaseDir>/<first 2 hex chars>/<hash>. Writing the same content twice
+// stores it once.
+type ContentStore struct {
+	BaseDir string
+}
+
+// NewContentStore creates a content-addressed store rooted at baseDir
+func NewContentStore(baseDir string) *ContentStore {
+	return &ContentStore{BaseDir: baseDir}
+}
+
+// Path returns where the blob with the given hash lives
+func (cs *ContentStore) Path(hash string) string {
+	if len(hash) < 2 {
+		return filepath.Join(cs.BaseDir, hash)
+	}
+	return filepath.Join(cs.BaseDir, hash[:2], hash)
+}
+
+// Has reports whether a blob is stored
+func (cs *ContentStore) Has(hash string) bool {
+	_, err := os.Stat(cs.Path(hash))
+	return err == nil
+}
+
+// Open opens a stored blob for reading
+func (cs *ContentStore) Open(hash string) (*os.File, error) {
+	return os.Open(cs.Path(hash))
+}
+
+// Put streams r into the store, hashing it on the way, and returns the
+// SHA-256 it is stored under together with its path and size.
+func (cs *ContentStore) Put(r io.Reader) (hash, path string, size int64, err error) {
+	if err := os.MkdirAll(cs.BaseDir, 0775); err != nil {
+		return "", "", 0, err
+	}
+	tmp, err := os.CreateTemp(cs.BaseDir, "upload-*")
+	if err != nil {
+		return "", "", 0, err
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+
+	h := sha256.New()
+	size, err = io.Copy(io.MultiWriter(tmp, h), r)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", "", 0, fmt.Errorf("store blob: %w", err)
+	}
+
+	hash = hex.EncodeToString(h.Sum(nil))
+	path = cs.Path(hash)
+	if cs.Has(hash) {
+		return hash, path, size, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0775); err != nil {
+		return "", "", 0, err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", "", 0, err
+	}
+	return hash, path, size, nil
+}
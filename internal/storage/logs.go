@@ -1,10 +1,12 @@
 package storage
 
 import (
-	"path/filepath"
 	"fmt"
 	"os"
+	"path/filepath"
 	"time"
+
+	"github.com/google/uuid"
 )
 
 // LogStorage manages saving logs to files
@@ -13,28 +15,30 @@ type LogStorage struct {
 }
 
 // New LogStorage creates a new log storage handler
-func NewLogStorage(baseDir string) *LogStorage{
+func NewLogStorage(baseDir string) *LogStorage {
 	return &LogStorage{BaseDir: baseDir}
 }
 
 // SaveLog  saves logs for a given stage/step
-func (ls *LogStorage) SaveLog(stage, step string , output string) (string, error){
-	
+func (ls *LogStorage) SaveLog(stage, step string, output string) (string, error) {
+
 	//Ensure base Directory exists
 	err := os.MkdirAll(ls.BaseDir, 0775)
 	if err != nil {
 		return "", err
 	}
 
-	// Filename with timestamp for uniqueness
+	// Filename with timestamp and a random suffix: parallel runs, retries
+	// and repeated runs of the same step within a second must not collide
 	timestamp := time.Now().Format("20060102_150405")
-	filename := fmt.Sprintf("%s_%s_%s.log", stage,step,timestamp)
+	suffix := uuid.New().String()[:8]
+	filename := fmt.Sprintf("%s_%s_%s_%s.log", sanitize(stage), sanitize(step), timestamp, suffix)
 	filePath := filepath.Join(ls.BaseDir, filename)
 
 	// Write output to a file
 	err = os.WriteFile(filePath, []byte(output), 0644)
 	if err != nil {
-		return "" , err
+		return "", err
 	}
 
 	return filePath, nil
@@ -53,4 +57,4 @@ func sanitize(name string) string {
 		return "step"
 	}
 	return clean
-}
\ No newline at end of file
+}
@@ -0,0 +1,247 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// StateStore persists server state as keyed records grouped by kind
+// (e.g. "pipeline", "agent"). Implementations must be safe for concurrent use.
+type StateStore interface {
+	// Put creates or replaces the record kind/key with the JSON encoding of value
+	Put(kind, key string, value interface{}) error
+	// Delete removes the record kind/key (no-op if missing)
+	Delete(kind, key string) error
+	// Load returns every record: kind -> key -> JSON value
+	Load() (map[string]map[string]json.RawMessage, error)
+	// Snapshot compacts the store
+	Snapshot() error
+	Close() error
+}
+
+// walEntry is one line of the write-ahead log
+type walEntry struct {
+	Op    string          `json:"op"` // put, delete
+	Kind  string          `json:"kind"`
+	Key   string          `json:"key"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// FileStateStore is a StateStore kept in a directory:
+//
+//	snapshot.json  full state as of the last snapshot
+//	wal.jsonl      operations applied since that snapshot, one per line
+//
+// Every operation is fsynced to the WAL before it is acknowledged. The WAL is
+// folded into a new snapshot every SnapshotEvery operations.
+type FileStateStore struct {
+	mu            sync.Mutex
+	dir           string
+	wal           *os.File
+	state         map[string]map[string]json.RawMessage
+	ops           int
+	SnapshotEvery int
+}
+
+const (
+	snapshotFile = "snapshot.json"
+	walFile      = "wal.jsonl"
+)
+
+// OpenFileStateStore loads the snapshot, replays the WAL and opens it for appends.
+func OpenFileStateStore(dir string) (*FileStateStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	st := &FileStateStore{
+		dir:           dir,
+		state:         make(map[string]map[string]json.RawMessage),
+		SnapshotEvery: 1000,
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, snapshotFile))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &st.state); err != nil {
+			return nil, fmt.Errorf("decode state snapshot: %w", err)
+		}
+	}
+
+	if err := st.replay(); err != nil {
+		return nil, err
+	}
+
+	st.wal, err = os.OpenFile(filepath.Join(dir, walFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// replay applies the WAL on top of the snapshot. A torn last line (crash
+// mid-write) is ignored since that operation was never acknowledged.
+func (st *FileStateStore) replay() error {
+	data, err := os.ReadFile(filepath.Join(st.dir, walFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	lines := bytes.Split(data, []byte("\n"))
+	offset := 0
+	for i, line := range lines {
+		start := offset
+		offset += len(line) + 1
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var e walEntry
+		if err := json.Unmarshal(line, &e); err != nil {
+			// only the unterminated last line can be a torn write
+			// and is cut off so later appends start on a clean line
+			if i == len(lines)-1 {
+				fmt.Printf("⚠️ dropping torn state WAL entry at line %d\n", i+1)
+				return os.Truncate(filepath.Join(st.dir, walFile), int64(start))
+			}
+			return fmt.Errorf("decode state WAL line %d: %w", i+1, err)
+		}
+		st.apply(e)
+		st.ops++
+	}
+
+	// a complete entry missing only its newline: terminate it
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		f, err := os.OpenFile(filepath.Join(st.dir, walFile), os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = f.Write([]byte("\n"))
+		return err
+	}
+	return nil
+}
+
+func (st *FileStateStore) apply(e walEntry) {
+	switch e.Op {
+	case "put":
+		if st.state[e.Kind] == nil {
+			st.state[e.Kind] = make(map[string]json.RawMessage)
+		}
+		st.state[e.Kind][e.Key] = e.Value
+	case "delete":
+		delete(st.state[e.Kind], e.Key)
+	}
+}
+
+func (st *FileStateStore) write(e walEntry) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err := st.wal.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("write state WAL: %w", err)
+	}
+	if err := st.wal.Sync(); err != nil {
+		return fmt.Errorf("sync state WAL: %w", err)
+	}
+	st.apply(e)
+
+	st.ops++
+	if st.SnapshotEvery > 0 && st.ops >= st.SnapshotEvery {
+		return st.snapshotLocked()
+	}
+	return nil
+}
+
+// Put creates or replaces the record kind/key
+func (st *FileStateStore) Put(kind, key string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("encode %s %s: %w", kind, key, err)
+	}
+	return st.write(walEntry{Op: "put", Kind: kind, Key: key, Value: data})
+}
+
+// Delete removes the record kind/key
+func (st *FileStateStore) Delete(kind, key string) error {
+	return st.write(walEntry{Op: "delete", Kind: kind, Key: key})
+}
+
+// Load returns a copy of every record
+func (st *FileStateStore) Load() (map[string]map[string]json.RawMessage, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	out := make(map[string]map[string]json.RawMessage, len(st.state))
+	for kind, records := range st.state {
+		out[kind] = make(map[string]json.RawMessage, len(records))
+		for key, value := range records {
+			out[kind][key] = value
+		}
+	}
+	return out, nil
+}
+
+// Snapshot writes the full state to snapshot.json and truncates the WAL
+func (st *FileStateStore) Snapshot() error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.snapshotLocked()
+}
+
+func (st *FileStateStore) snapshotLocked() error {
+	data, err := json.Marshal(st.state)
+	if err != nil {
+		return err
+	}
+
+	// write + fsync a temp file, then atomically rename over the old snapshot
+	tmp := filepath.Join(st.dir, snapshotFile+".tmp")
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(st.dir, snapshotFile)); err != nil {
+		return err
+	}
+
+	// the snapshot now holds everything in the WAL
+	if err := st.wal.Truncate(0); err != nil {
+		return fmt.Errorf("truncate state WAL: %w", err)
+	}
+	st.ops = 0
+	return nil
+}
+
+// Close snapshots the state and closes the WAL
+func (st *FileStateStore) Close() error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if err := st.snapshotLocked(); err != nil {
+		return err
+	}
+	return st.wal.Close()
+}
@@ -0,0 +1,107 @@
+package config
+
+import (
+	"blockci-q/internal/security"
+	"fmt"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// AgentEntry is an agent the server trusts
+type AgentEntry struct {
+	PubKey string `yaml:"pubkey"` // Ed25519 public key, base64 or hex
+}
+
+// SigningKeyEntry is a server key accepted on ledger blocks
+type SigningKeyEntry struct {
+	Name      string    `yaml:"name"`
+	PubKey    string    `yaml:"pubkey"`     // Ed25519 public key, base64 or hex
+	NotBefore time.Time `yaml:"not_before"` // optional, RFC3339
+	NotAfter  time.Time `yaml:"not_after"`  // optional, RFC3339
+}
+
+// ServerConfig is configs/server.yaml
+type ServerConfig struct {
+	Agents          map[string]AgentEntry `yaml:"agents"`           // agentID -> trusted key
+	SessionTTL      string                `yaml:"session_ttl"`      // agent session lifetime, e.g. "15m"
+	SigningKeys     []SigningKeyEntry     `yaml:"signing_keys"`     // pinned ledger signing keys
+	TrustedKeysDir  string                `yaml:"trusted_keys_dir"` // directory of extra *.pub signing keys
+	CheckpointEvery int                   `yaml:"checkpoint_every"` // blocks between ledger checkpoints (default 1000, -1 disables)
+	LedgerBackend   string                `yaml:"ledger_backend"`   // "jsonl" (default) or "segment"
+	LedgerPath      string                `yaml:"ledger_path"`      // ledger file or segment directory
+	APIToken        string                `yaml:"api_token"`        // bearer token required from clients (CLI, dashboards); empty = open
+}
+
+// TrustStore builds the ledger signer trust store from signing_keys and
+// the *.pub files in trusted_keys_dir
+func (cfg *ServerConfig) TrustStore() (*security.TrustStore, error) {
+	ts := security.NewTrustStore()
+	for i, entry := range cfg.SigningKeys {
+		pub, err := security.DecodePublicKey(entry.PubKey)
+		if err != nil {
+			return nil, fmt.Errorf("signing key %d (%s): %w", i, entry.Name, err)
+		}
+		if !entry.NotAfter.IsZero() && entry.NotAfter.Before(entry.NotBefore) {
+			return nil, fmt.Errorf("signing key %d (%s): not_after is before not_before", i, entry.Name)
+		}
+		ts.Add(security.TrustedKey{Name: entry.Name, PubKey: pub, NotBefore: entry.NotBefore, NotAfter: entry.NotAfter})
+	}
+	if cfg.TrustedKeysDir != "" {
+		if _, err := ts.LoadDir(cfg.TrustedKeysDir); err != nil {
+			return nil, err
+		}
+	}
+	return ts, nil
+}
+
+// AgentConfig is configs/agent.yaml
+type AgentConfig struct {
+	AgentID    string `yaml:"agent_id"`
+	Name       string `yaml:"name"`
+	ServerURL  string `yaml:"server_url"`
+	PrivateKey string `yaml:"private_key"` // Ed25519 private key, base64 or hex
+}
+
+// CLIConfig is the blockci CLI config file (default ~/.blockci.yaml)
+type CLIConfig struct {
+	Server string `yaml:"server"` // server URL, e.g. http://localhost:8080
+	Format string `yaml:"format"` // output format: table or json
+	Token  string `yaml:"token"`  // api_token of the server
+}
+
+// LoadServerConfig reads a server config file
+func LoadServerConfig(path string) (*ServerConfig, error) {
+	var cfg ServerConfig
+	if err := load(path, &cfg); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// LoadAgentConfig reads an agent config file
+func LoadAgentConfig(path string) (*AgentConfig, error) {
+	var cfg AgentConfig
+	if err := load(path, &cfg); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// LoadCLIConfig reads a CLI config file
+func LoadCLIConfig(path string) (*CLIConfig, error) {
+	var cfg CLIConfig
+	if err := load(path, &cfg); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+func load(path string, out interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return yaml.Unmarshal(data, out)
+}
@@ -4,9 +4,12 @@ import (
 	"blockci-q/internal/blockchain"
 	"blockci-q/internal/security"
 	"blockci-q/pkg/utils"
+	"encoding/hex"
+	"encoding/json"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 )
 
 // helper to create a dummy log file for hashing
@@ -126,3 +129,256 @@ func TestLedgerPersistence(t *testing.T) {
 		t.Errorf("reloaded ledger verification failed: %v", err)
 	}
 }
+
+// ✅ Test that a block written before versioning still verifies
+func TestLegacyBlockStillVerifies(t *testing.T) {
+	legacy := `{"index":17,"timestamp":"2025-09-26T19:13:52Z","stage":"Build","step":"Compile","logPath":"logs/Build_Compile_20250927_004352.log","logHash":"9064c7ea24474ebe7f059a667b087c9d1a580a71d7850aa86086e1dcde7ee00e","prevHash":"3f14e6e31201ad28c662c1036fc81b1caac5f0e7911bfbfc9f87f682d60dbc31","hash":"88bdca07926418a79f45cce99e4975dcb497e32a02d58d0e2ce2c7e9d2ff84bf","agentId":"agent-1","signature":"ae4639eb5c3ce77adfbbe7d67a58f0a2cb68718bbd0a45539dd2bb770d9243124a4a2fa98e9d3444f3991a25a91ddc9129c6dc8fc02c3302950a0d0a5601060f","pubKey":"348845f9e80ecd3acc2a955e3d16edd82e1bce3cb60c4fa06312d995d4fdbb13"}`
+
+	var blk blockchain.Block
+	if err := json.Unmarshal([]byte(legacy), &blk); err != nil {
+		t.Fatalf("decode failed: %v", err)
+	}
+	h, err := blk.ComputeHash()
+	if err != nil {
+		t.Fatalf("compute hash failed: %v", err)
+	}
+	if h != blk.Hash {
+		t.Errorf("legacy hash mismatch: got %s, want %s", h, blk.Hash)
+	}
+	if ok, _ := security.VerifySignatureFromHex(blk.PubKey, []byte(blk.Hash), blk.Signature); !ok {
+		t.Errorf("legacy signature no longer verifies")
+	}
+}
+
+// ✅ Test that run/job binding fields are covered by the hash
+func TestBlockV2BindsRunFields(t *testing.T) {
+	b, _ := blockchain.NewBlock(0, "Build", "Compile", "build.log", "abc", "", "agent-1")
+	b.RunID = "run-1"
+	b.JobID = "run-1-job-1"
+	b.ExitCode = 0
+	before, _ := b.ComputeHash()
+
+	b.ExitCode = 1
+	after, _ := b.ComputeHash()
+	if before == after {
+		t.Errorf("exit code is not part of the block hash")
+	}
+
+	b.ExitCode = 0
+	b.JobID = "run-1-job-2"
+	if h, _ := b.ComputeHash(); h == before {
+		t.Errorf("job ID is not part of the block hash")
+	}
+}
+
+// ✅ Test that deep verification catches edited, deleted and orphaned logs
+func TestDeepVerifyLogs(t *testing.T) {
+	ledger, _ := blockchain.OpenLedger(filepath.Join(t.TempDir(), "ledger.jsonl"))
+	pub, priv, _ := security.GenerateKeyPair()
+
+	logDir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(logDir, name)
+		os.WriteFile(path, []byte(content), 0644)
+		return path
+	}
+	log1 := write("build.log", "build output")
+	log2 := write("test.log", "test output")
+	h1, _ := utils.HashFile(log1)
+	h2, _ := utils.HashFile(log2)
+
+	b1, _ := blockchain.NewBlock(0, "Build", "Compile", log1, h1, "", "agent-1")
+	_ = ledger.AppendBlocks(b1, priv, pub)
+	b2, _ := blockchain.NewBlock(1, "Test", "Unit", log2, h2, b1.Hash, "agent-1")
+	_ = ledger.AppendBlocks(b2, priv, pub)
+
+	if report := ledger.DeepVerify(blockchain.DeepOptions{LogDir: logDir}); !report.OK {
+		t.Fatalf("expected clean report, got %+v", report)
+	}
+
+	os.WriteFile(log1, []byte("edited output"), 0644)
+	os.Remove(log2)
+	write("stray.log", "not in the ledger")
+
+	report := ledger.DeepVerify(blockchain.DeepOptions{LogDir: logDir})
+	if report.OK || report.Modified != 1 || report.Missing != 1 || len(report.Orphans) != 1 {
+		t.Errorf("unexpected report: %+v", report)
+	}
+	if report.Blocks[0].Status != blockchain.LogModified || report.Blocks[1].Status != blockchain.LogMissing {
+		t.Errorf("unexpected per-block statuses: %+v", report.Blocks)
+	}
+}
+
+// ✅ Test that the verify report collects every fault instead of the first
+func TestVerifyReportCollectsAllFaults(t *testing.T) {
+	ledger, _ := blockchain.OpenLedger(filepath.Join(t.TempDir(), "ledger.jsonl"))
+	pub, priv, _ := security.GenerateKeyPair()
+
+	prev := ""
+	for i, stage := range []string{"Build", "Test", "Deploy"} {
+		b, _ := blockchain.NewBlock(i, stage, "step", "", "", prev, "agent-1")
+		if err := ledger.AppendBlocks(b, priv, pub); err != nil {
+			t.Fatalf("append failed: %v", err)
+		}
+		prev = b.Hash
+	}
+	if report := ledger.Verify(blockchain.VerifyOptions{}); !report.OK || report.Verdict != "valid" {
+		t.Fatalf("expected valid ledger, got %+v", report)
+	}
+
+	ledger.Blocks[1].LogHash = "fake"                                     // hash mismatch at position 1
+	ledger.Blocks[2].Signature = security.SignData(priv, []byte("other")) // bad signature at position 2
+
+	other, _, _ := security.GenerateKeyPair()
+	trust := security.NewTrustStore()
+	trust.Add(security.TrustedKey{Name: "other", PubKey: other})
+	report := ledger.Verify(blockchain.VerifyOptions{Trust: trust})
+	kinds := make(map[blockchain.FaultKind]int)
+	for _, f := range report.Faults {
+		kinds[f.Kind]++
+	}
+	if report.OK || report.Verdict != "invalid" {
+		t.Fatalf("expected invalid verdict")
+	}
+	if kinds[blockchain.FaultHashMismatch] != 1 || kinds[blockchain.FaultBadSignature] != 1 || kinds[blockchain.FaultUnknownSigner] != 3 {
+		t.Errorf("unexpected faults: %+v", report.Faults)
+	}
+	if report.FirstDivergence != 0 {
+		t.Errorf("expected divergence at 0 (untrusted signer), got %d", report.FirstDivergence)
+	}
+}
+
+// ✅ Test that a rewritten block re-signed with another key fails against the trust store
+func TestVerifyRejectsUntrustedSigner(t *testing.T) {
+	ledger, _ := blockchain.OpenLedger(filepath.Join(t.TempDir(), "ledger.jsonl"))
+	pub, priv, _ := security.GenerateKeyPair()
+	for i := 0; i < 2; i++ {
+		b, _ := blockchain.NewBlock(i, "Build", "step", "", "", ledger.LastHash(), "agent-1")
+		if err := ledger.AppendBlocks(b, priv, pub); err != nil {
+			t.Fatalf("append failed: %v", err)
+		}
+	}
+
+	trust := security.NewTrustStore()
+	trust.Add(security.TrustedKey{Name: "server", PubKey: pub})
+	if report := ledger.Verify(blockchain.VerifyOptions{Trust: trust}); !report.OK {
+		t.Fatalf("expected valid ledger, got %+v", report.Faults)
+	}
+
+	// attacker rewrites the last block and re-signs it with their own key
+	attackerPub, attackerPriv, _ := security.GenerateKeyPair()
+	blk := ledger.Blocks[1]
+	blk.Stage = "Deploy"
+	blk.Hash, _ = blk.ComputeHash()
+	blk.PubKey = hex.EncodeToString(attackerPub)
+	blk.Signature = security.SignData(attackerPriv, []byte(blk.Hash))
+
+	if err := ledger.VerifyChain(); err != nil {
+		t.Fatalf("self-signed rewrite should pass without a trust store: %v", err)
+	}
+	report := ledger.Verify(blockchain.VerifyOptions{Trust: trust})
+	if report.OK || report.FirstDivergence != 1 || report.Faults[0].Kind != blockchain.FaultUnknownSigner {
+		t.Errorf("expected unknown_signer at position 1, got %+v", report.Faults)
+	}
+}
+
+// ✅ Test that a trusted key only verifies blocks inside its validity window
+func TestVerifyRejectsSignerOutsideWindow(t *testing.T) {
+	ledger, _ := blockchain.OpenLedger(filepath.Join(t.TempDir(), "ledger.jsonl"))
+	pub, priv, _ := security.GenerateKeyPair()
+	b, _ := blockchain.NewBlock(0, "Build", "step", "", "", "", "agent-1")
+	if err := ledger.AppendBlocks(b, priv, pub); err != nil {
+		t.Fatalf("append failed: %v", err)
+	}
+
+	expired := security.NewTrustStore()
+	expired.Add(security.TrustedKey{Name: "old", PubKey: pub, NotAfter: time.Now().Add(-time.Hour)})
+	report := ledger.Verify(blockchain.VerifyOptions{Trust: expired})
+	if report.OK || report.Faults[0].Kind != blockchain.FaultSignerNotValid {
+		t.Errorf("expected signer_not_valid, got %+v", report.Faults)
+	}
+
+	current := security.NewTrustStore()
+	current.Add(security.TrustedKey{Name: "current", PubKey: pub, NotBefore: time.Now().Add(-time.Hour)})
+	if report := ledger.Verify(blockchain.VerifyOptions{Trust: current}); !report.OK {
+		t.Errorf("expected valid ledger, got %+v", report.Faults)
+	}
+}
+
+// ✅ Test that verification follows the key lineage across a key rotation
+func TestKeyRotationLineage(t *testing.T) {
+	ledger, _ := blockchain.OpenLedger(filepath.Join(t.TempDir(), "ledger.jsonl"))
+	oldPub, oldPriv, _ := security.GenerateKeyPair()
+	newPub, newPriv, _ := security.GenerateKeyPair()
+
+	b, _ := blockchain.NewBlock(0, "Build", "step", "", "", "", "agent-1")
+	if err := ledger.AppendBlocks(b, oldPriv, oldPub); err != nil {
+		t.Fatalf("append failed: %v", err)
+	}
+	if _, err := ledger.RotateKey(oldPriv, oldPub, newPub); err != nil {
+		t.Fatalf("rotate failed: %v", err)
+	}
+
+	// the old key is retired: it can no longer sign
+	b, _ = blockchain.NewBlock(2, "Test", "step", "", "", ledger.LastHash(), "agent-1")
+	if err := ledger.AppendBlocks(b, oldPriv, oldPub); err == nil {
+		t.Fatal("expected append with the retired key to fail")
+	}
+	if err := ledger.AppendBlocks(b, newPriv, newPub); err != nil {
+		t.Fatalf("append with rotated key failed: %v", err)
+	}
+
+	// only the original key is pinned; the new one is trusted via the rotation
+	trust := security.NewTrustStore()
+	trust.Add(security.TrustedKey{Name: "old", PubKey: oldPub})
+	if report := ledger.Verify(blockchain.VerifyOptions{Trust: trust}); !report.OK {
+		t.Fatalf("expected valid lineage, got %+v", report.Faults)
+	}
+
+	// a later block re-signed with the old key breaks the lineage
+	blk := ledger.Blocks[2]
+	blk.PubKey = hex.EncodeToString(oldPub)
+	blk.Signature = security.SignData(oldPriv, []byte(blk.Hash))
+	report := ledger.Verify(blockchain.VerifyOptions{Trust: trust})
+	if report.OK || report.FirstDivergence != 2 || report.Faults[0].Kind != blockchain.FaultSignerLineage {
+		t.Errorf("expected signer_lineage at position 2, got %+v", report.Faults)
+	}
+}
+
+// ✅ Test that a legacy block cannot claim a key rotation
+func TestLegacyBlockCannotRotate(t *testing.T) {
+	ledger, _ := blockchain.OpenLedger(filepath.Join(t.TempDir(), "ledger.jsonl"))
+	pub, priv, _ := security.GenerateKeyPair()
+	attackerPub, _, _ := security.GenerateKeyPair()
+
+	b, _ := blockchain.NewBlock(0, "Build", "step", "", "", "", "agent-1")
+	b.Version = blockchain.BlockVersionLegacy
+	if err := ledger.AppendBlocks(b, priv, pub); err != nil {
+		t.Fatalf("append failed: %v", err)
+	}
+	// type and newPubKey are not part of the v1 hash
+	ledger.Blocks[0].Type = blockchain.BlockTypeKeyRotation
+	ledger.Blocks[0].NewPubKey = hex.EncodeToString(attackerPub)
+
+	report := ledger.Verify(blockchain.VerifyOptions{})
+	if report.OK || report.Faults[0].Kind != blockchain.FaultBadRotation {
+		t.Errorf("expected bad_rotation, got %+v", report.Faults)
+	}
+}
+
+// ✅ Test that a block of an unknown type gets its own fault kind
+func TestUnknownBlockType(t *testing.T) {
+	ledger, _ := blockchain.OpenLedger(filepath.Join(t.TempDir(), "ledger.jsonl"))
+	pub, priv, _ := security.GenerateKeyPair()
+
+	b, _ := blockchain.NewBlock(0, "Build", "step", "", "", "", "agent-1")
+	b.Type = "mystery"
+	if err := ledger.AppendBlocks(b, priv, pub); err != nil {
+		t.Fatalf("append failed: %v", err)
+	}
+
+	report := ledger.Verify(blockchain.VerifyOptions{})
+	if report.OK || len(report.Faults) != 1 || report.Faults[0].Kind != blockchain.FaultUnknownType {
+		t.Errorf("expected unknown_block_type only, got %+v", report.Faults)
+	}
+}
@@ -0,0 +1,65 @@
+package tests
+
+import (
+	"blockci-q/internal/storage"
+	"blockci-q/pkg/utils"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// ✅ Test that logs are stored under the hash the store computes itself
+func TestContentStorePut(t *testing.T) {
+	cs := storage.NewContentStore(t.TempDir())
+
+	hash, path, size, err := cs.Put(strings.NewReader("build output"))
+	if err != nil {
+		t.Fatalf("put failed: %v", err)
+	}
+	if hash != utils.HashString("build output") || size != int64(len("build output")) {
+		t.Errorf("unexpected hash/size: %s %d", hash, size)
+	}
+	if path != cs.Path(hash) || !cs.Has(hash) {
+		t.Errorf("blob not stored at its content address")
+	}
+	if data, _ := os.ReadFile(path); string(data) != "build output" {
+		t.Errorf("unexpected blob content %q", data)
+	}
+
+	// same content, same address
+	hash2, _, _, err := cs.Put(strings.NewReader("build output"))
+	if err != nil || hash2 != hash {
+		t.Errorf("duplicate put returned %s, %v", hash2, err)
+	}
+}
+
+// ✅ Test that saved logs of the same step never overwrite each other
+func TestSaveLogUniqueNames(t *testing.T) {
+	dir := t.TempDir()
+	ls := storage.NewLogStorage(dir)
+
+	first, err := ls.SaveLog("Build", "Compile", "first")
+	if err != nil {
+		t.Fatalf("save failed: %v", err)
+	}
+	second, err := ls.SaveLog("Build", "Compile", "second")
+	if err != nil {
+		t.Fatalf("save failed: %v", err)
+	}
+	if first == second {
+		t.Fatalf("two saves of the same step share %s", first)
+	}
+	if data, _ := os.ReadFile(first); string(data) != "first" {
+		t.Errorf("first log overwritten: %q", data)
+	}
+
+	// names are sanitized and stay inside the base directory
+	path, err := ls.SaveLog("../Deploy", "ship/it", "out")
+	if err != nil {
+		t.Fatalf("save failed: %v", err)
+	}
+	if filepath.Dir(path) != dir || !strings.HasPrefix(filepath.Base(path), "Deploy_shipit_") {
+		t.Errorf("unexpected log path %s", path)
+	}
+}
@@ -0,0 +1,178 @@
+package tests
+
+import (
+	"blockci-q/internal/blockchain"
+	"blockci-q/internal/security"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// appendSteps appends n step blocks of a run
+func appendSteps(t *testing.T, ledger *blockchain.Ledger, runID string, n int, priv ed25519.PrivateKey, pub ed25519.PublicKey) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		b, _ := blockchain.NewBlock(ledger.NextIndex(), "Stage", fmt.Sprintf("step-%d", i), "", fmt.Sprintf("%s-log-%d", runID, i), ledger.LastHash(), "agent-1")
+		b.RunID = runID
+		if err := ledger.AppendBlocks(b, priv, pub); err != nil {
+			t.Fatalf("append failed: %v", err)
+		}
+	}
+}
+
+// ✅ Test periodic checkpoints, opening from the last one and incremental verification
+func TestLedgerCheckpoints(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ledger.jsonl")
+	ledger, _ := blockchain.OpenLedger(path)
+	ledger.CheckpointEvery = 3
+	pub, priv, _ := security.GenerateKeyPair()
+	trust := security.NewTrustStore()
+	trust.Add(security.TrustedKey{Name: "server", PubKey: pub})
+	opts := blockchain.VerifyOptions{Trust: trust}
+
+	appendSteps(t, ledger, "run-1", 4, priv, pub)
+	if _, err := ledger.AppendRunSummary("run-1", "", "succeeded", priv, pub); err != nil {
+		t.Fatalf("seal failed: %v", err)
+	}
+	appendSteps(t, ledger, "run-2", 2, priv, pub) // run-2 stays open across the checkpoint
+
+	checkpoints := 0
+	for _, blk := range ledger.Blocks {
+		if blk.Type == blockchain.BlockTypeCheckpoint {
+			checkpoints++
+		}
+	}
+	if checkpoints != 2 {
+		t.Fatalf("expected 2 checkpoints, got %d", checkpoints)
+	}
+	if report := ledger.Verify(opts); !report.OK || report.From != 0 {
+		t.Fatalf("expected full verification from 0, got %+v", report)
+	}
+	incremental := opts
+	incremental.Incremental = true
+	if report := ledger.Verify(incremental); !report.OK || report.From != 7 || report.Blocks != 2 {
+		t.Fatalf("expected incremental verification of 2 blocks from 7, got %+v", report)
+	}
+
+	// reopen from the last checkpoint and keep appending
+	tail, err := blockchain.OpenLedgerFromCheckpoint(path)
+	if err != nil {
+		t.Fatalf("open from checkpoint failed: %v", err)
+	}
+	if !tail.Partial() || tail.Blocks[0].Index != 7 || len(tail.Blocks) != 2 {
+		t.Fatalf("expected 2 blocks from checkpoint 7, got %d from %d", len(tail.Blocks), tail.Blocks[0].Index)
+	}
+	appendSteps(t, tail, "run-2", 1, priv, pub)
+	summary, err := tail.AppendRunSummary("run-2", "", "succeeded", priv, pub)
+	if err != nil || summary.LeafCount != 3 {
+		t.Fatalf("expected run-2 sealed over 3 logs across the checkpoint, got %+v, %v", summary, err)
+	}
+	if report := tail.Verify(opts); !report.OK || report.From != 7 {
+		t.Fatalf("expected valid partial ledger, got %+v", report)
+	}
+	if report, err := blockchain.AuditLedger(path, opts); err != nil || !report.OK || report.From != 0 {
+		t.Fatalf("expected valid full audit, got %+v, %v", report, err)
+	}
+
+	// history before the checkpoint is still reachable
+	proof, err := tail.ProveLog("run-1", "run-1-log-2")
+	if err != nil {
+		t.Fatalf("prove failed: %v", err)
+	}
+	if err := blockchain.VerifyInclusion(proof, trust); err != nil {
+		t.Errorf("valid proof rejected: %v", err)
+	}
+}
+
+// ✅ Test that a checkpoint lying about the chain state fails the full audit
+func TestForgedCheckpoint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ledger.jsonl")
+	ledger, _ := blockchain.OpenLedger(path)
+	pub, priv, _ := security.GenerateKeyPair()
+
+	appendSteps(t, ledger, "run-1", 2, priv, pub)
+	cp, err := ledger.Checkpoint(priv, pub)
+	if err != nil {
+		t.Fatalf("checkpoint failed: %v", err)
+	}
+
+	// re-signed by the server key, but hides a log of the open run
+	cp.Checkpoint.OpenRuns["run-1"] = cp.Checkpoint.OpenRuns["run-1"][:1]
+	cp.Hash, _ = cp.ComputeHash()
+	cp.Signature = security.SignData(priv, []byte(cp.Hash))
+
+	report := ledger.Verify(blockchain.VerifyOptions{})
+	if report.OK || report.Faults[0].Kind != blockchain.FaultBadCheckpoint {
+		t.Errorf("expected bad_checkpoint, got %+v", report.Faults)
+	}
+
+	// resuming at a checkpoint signed by an unpinned key is refused
+	other, _, _ := security.GenerateKeyPair()
+	trust := security.NewTrustStore()
+	trust.Add(security.TrustedKey{Name: "other", PubKey: other})
+	report = ledger.Verify(blockchain.VerifyOptions{Trust: trust, Incremental: true})
+	if report.OK || report.Faults[0].Kind != blockchain.FaultUnknownSigner {
+		t.Errorf("expected unknown_signer at the checkpoint, got %+v", report.Faults)
+	}
+}
+
+// ✅ Test that a checkpoint index that cannot be written does not fail the append
+func TestCheckpointIndexFailureKeepsAppend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ledger.jsonl")
+	ledger, _ := blockchain.OpenLedger(path)
+	ledger.CheckpointEvery = 2
+	pub, priv, _ := security.GenerateKeyPair()
+
+	// a directory where the sidecar should be makes every mark write fail
+	if err := os.Mkdir(path+".checkpoints", 0755); err != nil {
+		t.Fatal(err)
+	}
+	appendSteps(t, ledger, "run-1", 3, priv, pub)
+
+	reopened, err := blockchain.OpenLedgerFromCheckpoint(path)
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	if reopened.NextIndex() != ledger.NextIndex() || reopened.LastHash() != ledger.LastHash() {
+		t.Fatalf("reopened ledger ends at %d, expected %d", reopened.NextIndex(), ledger.NextIndex())
+	}
+}
+
+// checkpointlessStore refuses to store checkpoint blocks
+type checkpointlessStore struct {
+	*blockchain.FileStore
+}
+
+func (s checkpointlessStore) Append(b *blockchain.Block) error {
+	if b.Type == blockchain.BlockTypeCheckpoint {
+		return errors.New("disk full")
+	}
+	return s.FileStore.Append(b)
+}
+
+// ✅ Test that a failed automatic checkpoint is reported and retried, not lost silently
+func TestCheckpointErrorReported(t *testing.T) {
+	fs, _ := blockchain.OpenFileStore(filepath.Join(t.TempDir(), "ledger.jsonl"))
+	ledger, err := blockchain.NewLedger(checkpointlessStore{fs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ledger.CheckpointEvery = 2
+	var failed []int
+	ledger.OnCheckpointError = func(index int, err error) {
+		if err == nil {
+			t.Error("expected an error")
+		}
+		failed = append(failed, index)
+	}
+	pub, priv, _ := security.GenerateKeyPair()
+	appendSteps(t, ledger, "run-1", 3, priv, pub)
+
+	if len(ledger.Blocks) != 3 || !reflect.DeepEqual(failed, []int{2, 3}) {
+		t.Fatalf("expected 3 blocks and failed checkpoints at 2 and 3, got %d blocks, %v", len(ledger.Blocks), failed)
+	}
+}
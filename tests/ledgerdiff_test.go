@@ -0,0 +1,46 @@
+package tests
+
+import (
+	"blockci-q/internal/blockchain"
+	"blockci-q/internal/security"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// ✅ Test diffing two ledger files: identical, extended and rewritten in place
+func TestDiffLedgers(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "ledger.jsonl")
+	ledger, _ := blockchain.OpenLedger(path)
+	pub, priv, _ := security.GenerateKeyPair()
+	appendSteps(t, ledger, "run-1", 3, priv, pub)
+
+	data, _ := os.ReadFile(path)
+	copyPath := filepath.Join(dir, "copy.jsonl")
+	os.WriteFile(copyPath, data, 0644)
+	other, _ := blockchain.OpenLedger(copyPath)
+	if diff := blockchain.DiffLedgers(ledger, other); !diff.Identical() || diff.Common != 3 {
+		t.Fatalf("expected identical ledgers, got %+v", diff)
+	}
+
+	appendSteps(t, ledger, "run-1", 1, priv, pub)
+	diff := blockchain.DiffLedgers(ledger, other)
+	if diff.Identical() || diff.Divergence != 3 || diff.Left == nil || diff.Right != nil {
+		t.Fatalf("expected left to extend right at 3, got %+v", diff)
+	}
+
+	// rewrite block 1 as an unframed line, keeping its stored hash
+	lines := strings.Split(string(data), "\n")
+	lines[1] = strings.Replace(lines[1][9:], `"stage":"Stage"`, `"stage":"Evil"`, 1)
+	os.WriteFile(copyPath, []byte(strings.Join(lines, "\n")), 0644)
+	other, err := blockchain.OpenLedger(copyPath)
+	if err != nil {
+		t.Fatalf("open rewritten copy failed: %v", err)
+	}
+	diff = blockchain.DiffLedgers(ledger, other)
+	if diff.Divergence != 1 || diff.Common != 1 || len(diff.Fields) != 1 || diff.Fields[0] != "stage" {
+		t.Fatalf("expected divergence at 1 in stage, got %+v", diff)
+	}
+}
@@ -0,0 +1,127 @@
+package tests
+
+import (
+	"blockci-q/internal/blockchain"
+	"blockci-q/internal/security"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// ✅ Test that linkage, checkpoints and verification work the same on every store backend
+func TestLedgerStoreBackends(t *testing.T) {
+	backends := map[string]func(dir string) (blockchain.LedgerStore, error){
+		"jsonl": func(dir string) (blockchain.LedgerStore, error) {
+			return blockchain.OpenFileStore(filepath.Join(dir, "ledger.jsonl"))
+		},
+		"segment": func(dir string) (blockchain.LedgerStore, error) {
+			s, err := blockchain.OpenSegmentStore(filepath.Join(dir, "ledger"))
+			if err == nil {
+				s.MaxSegmentBytes = 1024 // force several segments
+			}
+			return s, err
+		},
+	}
+	pub, priv, _ := security.GenerateKeyPair()
+	trust := security.NewTrustStore()
+	trust.Add(security.TrustedKey{Name: "server", PubKey: pub})
+	opts := blockchain.VerifyOptions{Trust: trust}
+
+	for name, open := range backends {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			store, err := open(dir)
+			if err != nil {
+				t.Fatalf("open store failed: %v", err)
+			}
+			ledger, _ := blockchain.NewLedger(store)
+			ledger.CheckpointEvery = 4
+			appendSteps(t, ledger, "run-1", 10, priv, pub)
+			if _, err := ledger.AppendRunSummary("run-1", "", "succeeded", priv, pub); err != nil {
+				t.Fatalf("seal failed: %v", err)
+			}
+			store.Close()
+
+			store, err = open(dir)
+			if err != nil {
+				t.Fatalf("reopen store failed: %v", err)
+			}
+			defer store.Close()
+			tail, err := blockchain.NewLedgerFromCheckpoint(store)
+			if err != nil || !tail.Partial() {
+				t.Fatalf("expected ledger opened from a checkpoint, got %v", err)
+			}
+			if report, err := tail.Audit(opts); err != nil || !report.OK || report.Blocks != 13 {
+				t.Fatalf("expected valid audit of 13 blocks, got %+v, %v", report, err)
+			}
+
+			head, _ := store.Head()
+			if head == nil || head.Type != blockchain.BlockTypeRunSummary || head.Hash != tail.LastHash() {
+				t.Fatalf("expected the run summary as head, got %+v", head)
+			}
+			blk, err := store.Get(5)
+			if err != nil || blk.Index != 5 {
+				t.Fatalf("expected block 5, got %+v, %v", blk, err)
+			}
+			byHash, err := store.GetByHash(blk.Hash)
+			if err != nil || byHash.Index != 5 {
+				t.Fatalf("expected block 5 by hash, got %+v, %v", byHash, err)
+			}
+			if _, err := store.Get(99); !errors.Is(err, blockchain.ErrBlockNotFound) {
+				t.Fatalf("expected ErrBlockNotFound, got %v", err)
+			}
+			proof, err := tail.ProveLog("run-1", "run-1-log-1")
+			if err != nil || blockchain.VerifyInclusion(proof, trust) != nil {
+				t.Fatalf("expected a valid proof across the checkpoint, got %v", err)
+			}
+		})
+	}
+}
+
+// ✅ Test that the segment store rebuilds a damaged index and quarantines a torn segment tail
+func TestSegmentStoreRecovery(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "ledger")
+	store, _ := blockchain.OpenSegmentStore(dir)
+	store.MaxSegmentBytes = 1024
+	ledger, _ := blockchain.NewLedger(store)
+	pub, priv, _ := security.GenerateKeyPair()
+	appendSteps(t, ledger, "run-1", 8, priv, pub)
+	store.Close()
+
+	segments, _ := filepath.Glob(filepath.Join(dir, "segment-*.log"))
+	if len(segments) < 2 {
+		t.Fatalf("expected several segments, got %d", len(segments))
+	}
+
+	// crash mid index entry and mid segment record
+	index := filepath.Join(dir, "index")
+	info, _ := os.Stat(index)
+	os.Truncate(index, info.Size()-70)
+	f, _ := os.OpenFile(segments[len(segments)-1], os.O_WRONLY|os.O_APPEND, 0644)
+	f.WriteString(`1234abcd {"index":8,"hash`)
+	f.Close()
+
+	store, err := blockchain.OpenSegmentStore(dir)
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	defer store.Close()
+	if store.Recovered == nil || store.Recovered.Quarantine == "" {
+		t.Fatalf("expected the torn tail to be quarantined")
+	}
+	if store.Len() != 8 {
+		t.Fatalf("expected 8 blocks after re-indexing, got %d", store.Len())
+	}
+	reopened, err := blockchain.NewLedger(store)
+	if err != nil || len(reopened.Blocks) != 8 || reopened.Recovered == nil {
+		t.Fatalf("expected 8 blocks and a recovery, got %d, %v", len(reopened.Blocks), err)
+	}
+	if err := reopened.VerifyChain(); err != nil {
+		t.Fatalf("expected a valid chain, got %v", err)
+	}
+	appendSteps(t, reopened, "run-1", 1, priv, pub)
+	if blk, err := store.Get(8); err != nil || blk.Hash != reopened.LastHash() {
+		t.Fatalf("expected appended block 8, got %+v, %v", blk, err)
+	}
+}
@@ -0,0 +1,148 @@
+package tests
+
+import (
+	"blockci-q/internal/core"
+	"blockci-q/pkg/utils"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+const lifecycleYAML = `
+name: chain
+stages:
+  - name: Build
+    steps:
+      - name: Compile
+        run: echo compile
+  - name: Test
+    steps:
+      - name: Unit
+        run: echo unit
+      - name: Race
+        run: echo race
+  - name: Deploy
+    steps:
+      - name: Ship
+        run: echo ship
+`
+
+// ✅ Test the from_stage rules: earlier stages must have succeeded or been carried
+func TestCarriedStages(t *testing.T) {
+	pipeline, err := core.ParsePipeline([]byte(lifecycleYAML))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	stages := map[string]core.State{"Build": core.StateSucceeded, "Test": core.StateFailed, "Deploy": core.StateSkipped}
+
+	carried, err := core.CarriedStages(pipeline, stages, nil, "Test")
+	if err != nil || !reflect.DeepEqual(carried, map[string]bool{"Build": true}) {
+		t.Fatalf("expected Build carried, got %v, %v", carried, err)
+	}
+	if _, err := core.CarriedStages(pipeline, stages, nil, "Deploy"); err == nil {
+		t.Fatal("expected retry from Deploy to be refused while Test failed")
+	}
+	if _, err := core.CarriedStages(pipeline, stages, nil, "Nope"); !errors.Is(err, core.ErrUnknownStage) {
+		t.Fatalf("expected ErrUnknownStage, got %v", err)
+	}
+	if carried, err := core.CarriedStages(pipeline, stages, nil, ""); err != nil || len(carried) != 0 {
+		t.Fatalf("expected nothing carried without from_stage, got %v, %v", carried, err)
+	}
+
+	// a retry of a retry: Build was carried (skipped) into the failed run
+	stages = map[string]core.State{"Build": core.StateSkipped, "Test": core.StateSucceeded, "Deploy": core.StateFailed}
+	carried, err = core.CarriedStages(pipeline, stages, map[string]bool{"Build": true}, "Deploy")
+	if err != nil || !reflect.DeepEqual(carried, map[string]bool{"Build": true, "Test": true}) {
+		t.Fatalf("expected Build and Test carried, got %v, %v", carried, err)
+	}
+	if _, err := core.CarriedStages(pipeline, stages, nil, "Deploy"); err == nil {
+		t.Fatal("expected a skipped stage that was not carried to be refused")
+	}
+}
+
+// ✅ Test that carried stages count as done and the next stage is released first
+func TestProgressCarry(t *testing.T) {
+	pipeline, _ := core.ParsePipeline([]byte(lifecycleYAML))
+	progress, err := core.NewScheduler().Track(pipeline)
+	if err != nil {
+		t.Fatalf("track: %v", err)
+	}
+	refs := progress.Carry(map[string]bool{"Build": true})
+	if !reflect.DeepEqual(refs, []core.StepRef{{Stage: "Build", Step: "Compile"}}) {
+		t.Fatalf("unexpected carried steps: %v", refs)
+	}
+	if !progress.StageDone("Build") {
+		t.Fatal("expected Build done")
+	}
+	if next := progress.Next(); len(next) != 1 || next[0] != (core.StepRef{Stage: "Test", Step: "Unit"}) {
+		t.Fatalf("expected Test/Unit released first, got %v", next)
+	}
+}
+
+// ✅ Test that cancelling keeps running steps and seals only once they report
+func TestStopRunWithRunningSteps(t *testing.T) {
+	pipeline, _ := core.ParsePipeline([]byte(lifecycleYAML))
+	steps := map[string]core.State{
+		"Build:Compile": core.StateSucceeded,
+		"Test:Unit":     core.StateRunning,
+		"Test:Race":     core.StateQueued,
+		"Deploy:Ship":   core.StatePending,
+	}
+	for key, st := range steps {
+		steps[key] = core.StoppedState(st, core.StateCancelled)
+	}
+	want := map[string]core.State{
+		"Build:Compile": core.StateSucceeded,
+		"Test:Unit":     core.StateRunning,
+		"Test:Race":     core.StateCancelled,
+		"Deploy:Ship":   core.StateCancelled,
+	}
+	if !reflect.DeepEqual(steps, want) {
+		t.Fatalf("unexpected states after cancel: %v", steps)
+	}
+	stages := core.StageStates(pipeline, func(ref core.StepRef) core.State { return steps[ref.Key()] })
+	if stages["Test"] != core.StateRunning || stages["Deploy"] != core.StateCancelled {
+		t.Fatalf("unexpected stage states: %v", stages)
+	}
+	if core.Settled(core.StateCancelled, steps) {
+		t.Fatal("a run with a running step must not be sealed")
+	}
+
+	// the running step reports after the cancel
+	steps["Test:Unit"] = core.StateSucceeded
+	if !core.Settled(core.StateCancelled, steps) {
+		t.Fatal("expected the run to be sealable once nothing runs")
+	}
+	if core.Settled(core.StateRunning, steps) {
+		t.Fatal("a run that is not terminal must not be sealed")
+	}
+
+	// on failure, pending steps are skipped instead
+	if st := core.StoppedState(core.StatePending, core.StateSkipped); st != core.StateSkipped {
+		t.Fatalf("expected pending -> skipped on failure, got %s", st)
+	}
+}
+
+// ✅ Test that a reported log error records the empty log even after an upload
+func TestResultLogLostAfterUpload(t *testing.T) {
+	uploaded := utils.HashString("partial build output")
+
+	hash, lost, err := core.ResultLog(uploaded, true, "")
+	if err != nil || lost || hash != uploaded {
+		t.Fatalf("expected the uploaded log, got %q, %v, %v", hash, lost, err)
+	}
+
+	// the upload reached the server but the agent never saw it confirmed
+	hash, lost, err = core.ResultLog(uploaded, true, "upload failed: hash mismatch")
+	if err != nil || !lost || hash != utils.HashString("") {
+		t.Fatalf("expected the empty log recorded as lost, got %q, %v, %v", hash, lost, err)
+	}
+
+	hash, lost, err = core.ResultLog("", false, "server unreachable")
+	if err != nil || !lost || hash != utils.HashString("") {
+		t.Fatalf("expected a lost log without an upload, got %q, %v, %v", hash, lost, err)
+	}
+	if _, _, err := core.ResultLog("", false, ""); !errors.Is(err, core.ErrLogNotUploaded) {
+		t.Fatalf("expected ErrLogNotUploaded, got %v", err)
+	}
+}
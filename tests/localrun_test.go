@@ -0,0 +1,177 @@
+package tests
+
+import (
+	"blockci-q/internal/core"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+const localRunYAML = `
+name: demo
+stages:
+  - name: Build
+    steps:
+      - name: Echo
+        run: echo hi
+      - name: Compile
+        run: echo compiled
+  - name: Lint
+    steps:
+      - name: Vet
+        run: echo vet
+  - name: Test
+    needs: [Build]
+    steps:
+      - name: Unit
+        run: exit 3
+`
+
+// ✅ Test that the plan lists stages and steps in order with resolved needs
+func TestSchedulerPlanResolvesNeeds(t *testing.T) {
+	pipeline, err := core.ParsePipeline([]byte(localRunYAML))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	plan, err := core.NewScheduler().Plan(pipeline)
+	if err != nil {
+		t.Fatalf("plan: %v", err)
+	}
+	if len(plan) != 3 || plan[0].Name != "Build" {
+		t.Fatalf("unexpected plan: %+v", plan)
+	}
+	if !reflect.DeepEqual(plan[1].Needs, []string{"Build"}) || !reflect.DeepEqual(plan[0].Steps[1].Needs, []string{"Echo"}) {
+		t.Fatalf("implicit needs not resolved: %+v", plan)
+	}
+}
+
+// ✅ Test that --stage/--step filters keep order and drop needs on removed nodes
+func TestSchedulerFilter(t *testing.T) {
+	pipeline, err := core.ParsePipeline([]byte(localRunYAML))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	s := core.NewScheduler()
+
+	filtered, err := s.Filter(pipeline, []string{"Lint"}, []string{"Build/Compile"})
+	if err != nil {
+		t.Fatalf("filter: %v", err)
+	}
+	plan, err := s.Plan(filtered)
+	if err != nil {
+		t.Fatalf("plan: %v", err)
+	}
+	if len(plan) != 2 || plan[0].Name != "Build" || plan[1].Name != "Lint" {
+		t.Fatalf("unexpected stages: %+v", plan)
+	}
+	if len(plan[0].Steps) != 1 || plan[0].Steps[0].Name != "Compile" || len(plan[0].Steps[0].Needs) != 0 {
+		t.Fatalf("unexpected Build steps: %+v", plan[0].Steps)
+	}
+	if !reflect.DeepEqual(plan[1].Needs, []string{"Build"}) {
+		t.Fatalf("Lint should still need Build: %+v", plan[1])
+	}
+
+	// a stage declared after a dropped one must not pick up a new implicit need
+	filtered, err = s.Filter(pipeline, []string{"Test"}, nil)
+	if err != nil {
+		t.Fatalf("filter: %v", err)
+	}
+	if plan, _ := s.Plan(filtered); len(plan) != 1 || len(plan[0].Needs) != 0 {
+		t.Fatalf("unexpected plan: %+v", plan)
+	}
+
+	if _, err := s.Filter(pipeline, nil, []string{"Nope"}); err == nil {
+		t.Fatal("expected unknown step error")
+	}
+	if _, err := s.Filter(pipeline, []string{"Nope"}, nil); err == nil {
+		t.Fatal("expected unknown stage error")
+	}
+}
+
+// ✅ Test that dropping a middle stage or step keeps the dependency through it
+func TestSchedulerFilterDropsMiddle(t *testing.T) {
+	pipeline, err := core.ParsePipeline([]byte(`
+stages:
+  - name: A
+    steps:
+      - name: a
+        run: echo a
+  - name: B
+    steps:
+      - name: b1
+        run: echo b1
+      - name: b2
+        run: echo b2
+      - name: b3
+        run: echo b3
+  - name: C
+    steps:
+      - name: c
+        run: echo c
+`))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	s := core.NewScheduler()
+
+	filtered, err := s.Filter(pipeline, []string{"A", "C"}, []string{"B/b1", "B/b3"})
+	if err != nil {
+		t.Fatalf("filter: %v", err)
+	}
+	plan, err := s.Plan(filtered)
+	if err != nil {
+		t.Fatalf("plan: %v", err)
+	}
+	if len(plan) != 3 || !reflect.DeepEqual(plan[2].Needs, []string{"B"}) {
+		t.Fatalf("unexpected plan: %+v", plan)
+	}
+	if steps := plan[1].Steps; len(steps) != 2 || !reflect.DeepEqual(steps[1].Needs, []string{"b1"}) {
+		t.Fatalf("b3 should need b1 through the dropped b2: %+v", steps)
+	}
+
+	filtered, err = s.Filter(pipeline, []string{"A", "C"}, nil)
+	if err != nil {
+		t.Fatalf("filter: %v", err)
+	}
+	plan, err = s.Plan(filtered)
+	if err != nil {
+		t.Fatalf("plan: %v", err)
+	}
+	if len(plan) != 2 || plan[1].Name != "C" || !reflect.DeepEqual(plan[1].Needs, []string{"A"}) {
+		t.Fatalf("C should need A through the dropped B: %+v", plan)
+	}
+}
+
+// ✅ Test that RunPipeline reports every executed step to OnStep
+func TestRunnerOnStep(t *testing.T) {
+	pipeline, err := core.ParsePipeline([]byte(localRunYAML))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	runner := core.NewRunner()
+	runner.LogStorage.BaseDir = t.TempDir()
+
+	var mu sync.Mutex
+	states := make(map[string]core.State)
+	runner.OnStep = func(stage string, step core.Step, res core.StepResult) {
+		mu.Lock()
+		defer mu.Unlock()
+		if res.LogPath == "" || res.FinishedAt.Before(res.StartedAt) {
+			t.Errorf("%s/%s: bad result %+v", stage, step.Name, res)
+		}
+		states[stage+"/"+step.Name] = res.State
+	}
+
+	if _, err := runner.RunPipeline(pipeline); err == nil {
+		t.Fatal("expected the Unit step to fail the pipeline")
+	}
+	want := map[string]core.State{
+		"Build/Echo":    core.StateSucceeded,
+		"Build/Compile": core.StateSucceeded,
+		"Lint/Vet":      core.StateSucceeded,
+		"Test/Unit":     core.StateFailed,
+	}
+	if !reflect.DeepEqual(states, want) {
+		t.Fatalf("got %v, want %v", states, want)
+	}
+}
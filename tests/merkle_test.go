@@ -0,0 +1,102 @@
+package tests
+
+import (
+	"blockci-q/internal/blockchain"
+	"blockci-q/internal/security"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+// ✅ Test Merkle audit paths for every leaf of trees of several sizes
+func TestMerklePaths(t *testing.T) {
+	for size := 1; size <= 9; size++ {
+		logs := make([]string, size)
+		for i := range logs {
+			logs[i] = fmt.Sprintf("log-%d", i)
+		}
+		root := blockchain.MerkleRoot(logs)
+
+		for i := range logs {
+			path, err := blockchain.MerklePath(logs, i)
+			if err != nil {
+				t.Fatalf("size %d leaf %d: %v", size, i, err)
+			}
+			if !blockchain.VerifyMerklePath(logs[i], i, size, path, root) {
+				t.Errorf("size %d leaf %d: valid path rejected", size, i)
+			}
+			if blockchain.VerifyMerklePath("forged", i, size, path, root) {
+				t.Errorf("size %d leaf %d: forged log accepted", size, i)
+			}
+			if size > 1 && blockchain.VerifyMerklePath(logs[i], (i+1)%size, size, path, root) {
+				t.Errorf("size %d leaf %d: wrong index accepted", size, i)
+			}
+		}
+	}
+
+	// leaf order matters
+	if blockchain.MerkleRoot([]string{"a", "b"}) == blockchain.MerkleRoot([]string{"b", "a"}) {
+		t.Error("expected different roots for reordered logs")
+	}
+}
+
+// ✅ Test that a sealed run yields a standalone inclusion proof for one log
+func TestRunInclusionProof(t *testing.T) {
+	ledger, _ := blockchain.OpenLedger(filepath.Join(t.TempDir(), "ledger.jsonl"))
+	pub, priv, _ := security.GenerateKeyPair()
+
+	for i, logHash := range []string{"h-build", "h-other", "h-test", "h-deploy"} {
+		b, _ := blockchain.NewBlock(i, "Stage", fmt.Sprintf("step-%d", i), "", logHash, ledger.LastHash(), "agent-1")
+		b.RunID = "run-1"
+		if logHash == "h-other" {
+			b.RunID = "run-2"
+		}
+		if err := ledger.AppendBlocks(b, priv, pub); err != nil {
+			t.Fatalf("append failed: %v", err)
+		}
+	}
+	if _, err := ledger.ProveLog("run-1", "h-test"); err == nil {
+		t.Fatal("expected no proof before the run is sealed")
+	}
+
+	summary, err := ledger.AppendRunSummary("run-1", "", "succeeded", priv, pub)
+	if err != nil {
+		t.Fatalf("seal failed: %v", err)
+	}
+	if summary.LeafCount != 3 {
+		t.Errorf("expected 3 leaves, got %d", summary.LeafCount)
+	}
+
+	proof, err := ledger.ProveLog("run-1", "h-test")
+	if err != nil {
+		t.Fatalf("prove failed: %v", err)
+	}
+	trust := security.NewTrustStore()
+	trust.Add(security.TrustedKey{Name: "server", PubKey: pub})
+	if err := blockchain.VerifyInclusion(proof, trust); err != nil {
+		t.Errorf("valid proof rejected: %v", err)
+	}
+	if _, err := ledger.ProveLog("run-1", "h-other"); err == nil {
+		t.Error("expected a log of another run to have no proof")
+	}
+
+	proof.LogHash = "h-forged"
+	if err := blockchain.VerifyInclusion(proof, trust); err == nil {
+		t.Error("expected forged log to be rejected")
+	}
+
+	if report := ledger.Verify(blockchain.VerifyOptions{Trust: trust}); !report.OK {
+		t.Fatalf("expected valid ledger, got %+v", report.Faults)
+	}
+	// a step block rewritten after sealing no longer matches the root
+	ledger.Blocks[2].LogHash = "h-rewritten"
+	ledger.Blocks[2].Hash, _ = ledger.Blocks[2].ComputeHash()
+	report := ledger.Verify(blockchain.VerifyOptions{})
+	found := false
+	for _, f := range report.Faults {
+		found = found || f.Kind == blockchain.FaultMerkleRoot
+	}
+	if !found {
+		t.Errorf("expected merkle_root_mismatch, got %+v", report.Faults)
+	}
+}
@@ -0,0 +1,133 @@
+package tests
+
+import (
+	"blockci-q/internal/blockchain"
+	"blockci-q/internal/security"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// ✅ Test ledger queries by index, hash, run, step and time, with pagination
+func TestLedgerQuery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ledger.jsonl")
+	ledger, _ := blockchain.OpenLedger(path)
+	ledger.CheckpointEvery = 5
+	pub, priv, _ := security.GenerateKeyPair()
+	appendSteps(t, ledger, "run-1", 4, priv, pub)
+	appendSteps(t, ledger, "run-2", 6, priv, pub)
+
+	// reopen from the last checkpoint: the indices still cover earlier blocks
+	ledger, err := blockchain.OpenLedgerFromCheckpoint(path)
+	if err != nil || !ledger.Partial() {
+		t.Fatalf("expected ledger opened from a checkpoint, got %v", err)
+	}
+
+	page, err := ledger.Query(blockchain.BlockQuery{RunIDs: []string{"run-1"}})
+	if err != nil || page.Total != 4 || len(page.Blocks) != 4 || page.Blocks[0].Index != 0 {
+		t.Fatalf("expected the 4 blocks of run-1, got %+v, %v", page, err)
+	}
+	page, _ = ledger.Query(blockchain.BlockQuery{Step: "step-1"})
+	if page.Total != 2 || page.Blocks[0].RunID != "run-1" || page.Blocks[1].RunID != "run-2" {
+		t.Fatalf("expected step-1 of both runs, got %+v", page)
+	}
+	page, _ = ledger.Query(blockchain.BlockQuery{AgentID: "agent-1", From: 2, To: 8, Limit: 3})
+	if page.Total != 6 || len(page.Blocks) != 3 || page.Blocks[0].Index != 2 || page.NextOffset != 3 {
+		t.Fatalf("expected first page of 3 out of 6, got %+v", page)
+	}
+	page, _ = ledger.Query(blockchain.BlockQuery{AgentID: "agent-1", From: 2, To: 8, Limit: 3, Offset: 3})
+	if len(page.Blocks) != 3 || page.NextOffset != 0 {
+		t.Fatalf("expected last page of 3, got %+v", page)
+	}
+	page, _ = ledger.Query(blockchain.BlockQuery{Until: time.Now().Add(-time.Hour)})
+	if page.Total != 0 || page.Blocks == nil {
+		t.Fatalf("expected an empty page before the ledger existed, got %+v", page)
+	}
+
+	blk, err := ledger.Block(1) // before the checkpoint, read from the store
+	if err != nil || blk.Index != 1 {
+		t.Fatalf("expected block 1, got %+v, %v", blk, err)
+	}
+	byHash, err := ledger.BlockByHash(blk.Hash)
+	if err != nil || byHash.Index != 1 {
+		t.Fatalf("expected block 1 by hash, got %+v, %v", byHash, err)
+	}
+	if _, err := ledger.BlockByHash("deadbeef"); !errors.Is(err, blockchain.ErrBlockNotFound) {
+		t.Fatalf("expected ErrBlockNotFound, got %v", err)
+	}
+
+	appendSteps(t, ledger, "run-3", 1, priv, pub)
+	if page, _ := ledger.Query(blockchain.BlockQuery{RunIDs: []string{"run-3"}}); page.Total != 1 {
+		t.Fatalf("expected appended block to be indexed, got %+v", page)
+	}
+}
+
+// countingStore counts the reads that start before a given block
+type countingStore struct {
+	*blockchain.FileStore
+	before int
+	reads  int
+}
+
+func (s *countingStore) Iterate(from int, fn func(*blockchain.Block) bool) error {
+	if from < s.before {
+		s.reads++
+	}
+	return s.FileStore.Iterate(from, fn)
+}
+
+// ✅ Test that opening from a checkpoint leaves earlier blocks unread until the first query
+func TestLedgerQueryIndexIsLazy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ledger.jsonl")
+	ledger, _ := blockchain.OpenLedger(path)
+	ledger.CheckpointEvery = 5
+	pub, priv, _ := security.GenerateKeyPair()
+	appendSteps(t, ledger, "run-1", 7, priv, pub)
+
+	fs, _ := blockchain.OpenFileStore(path)
+	store := &countingStore{FileStore: fs, before: 5}
+	ledger, err := blockchain.NewLedgerFromCheckpoint(store)
+	if err != nil || !ledger.Partial() {
+		t.Fatalf("expected ledger opened from a checkpoint, got %v", err)
+	}
+	appendSteps(t, ledger, "run-1", 1, priv, pub)
+	if store.reads != 0 {
+		t.Fatalf("expected no read before the checkpoint on open, got %d", store.reads)
+	}
+
+	page, err := ledger.Query(blockchain.BlockQuery{RunIDs: []string{"run-1"}})
+	if err != nil || page.Total != 8 || store.reads != 1 {
+		t.Fatalf("expected 8 blocks from one index build, got %+v, %v, %d reads", page, err, store.reads)
+	}
+	ledger.Query(blockchain.BlockQuery{Stage: "Stage"})
+	if store.reads != 1 {
+		t.Fatalf("expected the index to be built once, got %d reads", store.reads)
+	}
+}
+
+// ✅ Test finding the block of a job, also before the checkpoint a ledger was opened from
+func TestLedgerJobBlock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ledger.jsonl")
+	ledger, _ := blockchain.OpenLedger(path)
+	ledger.CheckpointEvery = 2
+	pub, priv, _ := security.GenerateKeyPair()
+	b, _ := blockchain.NewBlock(ledger.NextIndex(), "Build", "Compile", "", "log", ledger.LastHash(), "agent-1")
+	b.JobID = "run-1-job-1"
+	if err := ledger.AppendBlocks(b, priv, pub); err != nil {
+		t.Fatalf("append failed: %v", err)
+	}
+	appendSteps(t, ledger, "run-1", 3, priv, pub)
+
+	ledger, err := blockchain.OpenLedgerFromCheckpoint(path)
+	if err != nil || !ledger.Partial() {
+		t.Fatalf("expected ledger opened from a checkpoint, got %v", err)
+	}
+	blk, err := ledger.JobBlock("run-1-job-1")
+	if err != nil || blk.Index != 0 {
+		t.Fatalf("expected block 0 for the job, got %+v, %v", blk, err)
+	}
+	if _, err := ledger.JobBlock("run-1-job-2"); !errors.Is(err, blockchain.ErrBlockNotFound) {
+		t.Fatalf("expected ErrBlockNotFound, got %v", err)
+	}
+}
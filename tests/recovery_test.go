@@ -0,0 +1,163 @@
+package tests
+
+import (
+	"blockci-q/internal/blockchain"
+	"blockci-q/internal/security"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// ✅ Test that a torn last record is quarantined and the ledger stays usable
+func TestTornTailRecovery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ledger.jsonl")
+	ledger, _ := blockchain.OpenLedger(path)
+	pub, priv, _ := security.GenerateKeyPair()
+	appendSteps(t, ledger, "run-1", 3, priv, pub)
+
+	data, _ := os.ReadFile(path)
+	if bytes.HasPrefix(data, []byte("{")) {
+		t.Fatal("expected framed records")
+	}
+	intact := len(data)
+
+	// crash in the middle of the next record
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	f.Write([]byte(`1234abcd {"index":3,"timest`))
+	f.Close()
+
+	reopened, err := blockchain.OpenLedger(path)
+	if err != nil {
+		t.Fatalf("open after torn write failed: %v", err)
+	}
+	if len(reopened.Blocks) != 3 || reopened.Recovered == nil {
+		t.Fatalf("expected 3 blocks and a recovery, got %d, %+v", len(reopened.Blocks), reopened.Recovered)
+	}
+	torn, _ := os.ReadFile(reopened.Recovered.Quarantine)
+	if string(torn) != `1234abcd {"index":3,"timest` {
+		t.Errorf("unexpected quarantined bytes %q", torn)
+	}
+	if info, _ := os.Stat(path); info.Size() != int64(intact) {
+		t.Errorf("expected ledger truncated to %d bytes, got %d", intact, info.Size())
+	}
+
+	appendSteps(t, reopened, "run-1", 1, priv, pub)
+	if report := reopened.Verify(blockchain.VerifyOptions{}); !report.OK {
+		t.Errorf("expected valid ledger after recovery, got %+v", report.Faults)
+	}
+}
+
+// ✅ Test that a read-only open reports a torn tail and leaves the file alone
+func TestReadOnlyOpenReportsTornTail(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "ledger.jsonl")
+	ledger, _ := blockchain.OpenLedger(path)
+	pub, priv, _ := security.GenerateKeyPair()
+	appendSteps(t, ledger, "run-1", 3, priv, pub)
+
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	f.Write([]byte(`1234abcd {"index":3,"timest`))
+	f.Close()
+	before, _ := os.ReadFile(path)
+
+	readOnly, err := blockchain.OpenLedgerReadOnly(path)
+	if err != nil {
+		t.Fatalf("read-only open failed: %v", err)
+	}
+	if len(readOnly.Blocks) != 3 || readOnly.Recovered != nil || readOnly.Torn == nil || readOnly.Torn.Bytes != 27 {
+		t.Fatalf("expected 3 blocks and a torn tail of 27 bytes, got %d, %+v", len(readOnly.Blocks), readOnly.Torn)
+	}
+	if report := readOnly.Verify(blockchain.VerifyOptions{}); !report.OK || report.TornTail == nil {
+		t.Errorf("expected a valid chain with the torn tail reported, got %+v", report)
+	}
+	if report, err := blockchain.AuditLedger(path, blockchain.VerifyOptions{}); err != nil || report.TornTail == nil {
+		t.Errorf("expected the audit to report the torn tail, got %+v, %v", report, err)
+	}
+	appendLate := func() error {
+		b, _ := blockchain.NewBlock(readOnly.NextIndex(), "Stage", "late", "", "late-log", readOnly.LastHash(), "agent-1")
+		return readOnly.AppendBlocks(b, priv, pub)
+	}
+	if err := appendLate(); err == nil {
+		t.Error("expected an append to a read-only ledger to fail")
+	}
+
+	after, _ := os.ReadFile(path)
+	quarantined, _ := filepath.Glob(filepath.Join(dir, "*.torn-*"))
+	if !bytes.Equal(after, before) || len(quarantined) != 0 {
+		t.Errorf("expected the file untouched, got %d bytes and quarantine files %v", len(after), quarantined)
+	}
+	if _, err := blockchain.OpenLedgerReadOnly(filepath.Join(dir, "missing.jsonl")); err == nil {
+		t.Error("expected a missing ledger not to be created")
+	}
+}
+
+// ✅ Test that recovery never drops a valid block
+func TestRecoveryKeepsValidBlocks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ledger.jsonl")
+	ledger, _ := blockchain.OpenLedger(path)
+	pub, priv, _ := security.GenerateKeyPair()
+	appendSteps(t, ledger, "run-1", 3, priv, pub)
+	data, _ := os.ReadFile(path)
+
+	// a complete last record that only lost its newline is kept
+	os.WriteFile(path, bytes.TrimSuffix(data, []byte("\n")), 0644)
+	reopened, err := blockchain.OpenLedger(path)
+	if err != nil || len(reopened.Blocks) != 3 || reopened.Recovered != nil {
+		t.Fatalf("expected 3 blocks kept, got %v, %+v", err, reopened)
+	}
+	if fixed, _ := os.ReadFile(path); !bytes.Equal(fixed, data) {
+		t.Error("expected the missing newline to be restored")
+	}
+
+	// a corrupt record followed by valid ones is an error, not a torn tail
+	corrupt := append([]byte(nil), data...)
+	second := bytes.IndexByte(corrupt, '\n') + 20
+	corrupt[second] ^= 0x01
+	os.WriteFile(path, corrupt, 0644)
+	if _, err := blockchain.OpenLedger(path); err == nil {
+		t.Fatal("expected mid-file corruption to fail the open")
+	}
+	if after, _ := os.ReadFile(path); !bytes.Equal(after, corrupt) {
+		t.Error("expected a corrupt ledger to be left untouched")
+	}
+}
+
+// ✅ Test that legacy unframed lines are still read next to framed ones
+func TestLegacyUnframedRecords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ledger.jsonl")
+	pub, priv, _ := security.GenerateKeyPair()
+
+	scratch, _ := blockchain.OpenLedger(filepath.Join(t.TempDir(), "scratch.jsonl"))
+	appendSteps(t, scratch, "run-1", 1, priv, pub)
+	legacy, _ := json.Marshal(scratch.Blocks[0])
+	os.WriteFile(path, append(legacy, '\n'), 0644)
+
+	ledger, err := blockchain.OpenLedger(path)
+	if err != nil || len(ledger.Blocks) != 1 {
+		t.Fatalf("expected legacy block read, got %v", err)
+	}
+	appendSteps(t, ledger, "run-1", 1, priv, pub)
+	reopened, err := blockchain.OpenLedger(path)
+	if err != nil || len(reopened.Blocks) != 2 {
+		t.Fatalf("expected legacy and framed blocks, got %v", err)
+	}
+}
+
+// ✅ Test that a failed append leaves the block and the ledger unchanged
+func TestFailedAppendDoesNotMutate(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "gone")
+	os.Mkdir(dir, 0755)
+	ledger, _ := blockchain.OpenLedger(filepath.Join(dir, "ledger.jsonl"))
+	pub, priv, _ := security.GenerateKeyPair()
+	os.RemoveAll(dir)
+
+	b, _ := blockchain.NewBlock(0, "Build", "step", "", "", "", "agent-1")
+	if err := ledger.AppendBlocks(b, priv, pub); err == nil {
+		t.Fatal("expected append to a removed directory to fail")
+	}
+	if b.Signature != "" || b.PubKey != "" || len(ledger.Blocks) != 0 {
+		t.Errorf("expected untouched block and ledger, got sig %q and %d blocks", b.Signature, len(ledger.Blocks))
+	}
+}
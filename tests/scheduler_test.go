@@ -0,0 +1,149 @@
+package tests
+
+import (
+	"blockci-q/internal/core"
+	"blockci-q/internal/storage"
+	"strings"
+	"testing"
+)
+
+// ✅ Test that `needs:` cycles are rejected at parse time
+func TestParsePipelineRejectsCycle(t *testing.T) {
+	yml := `
+stages:
+  - name: A
+    needs: [B]
+    steps:
+      - name: a
+        run: echo a
+  - name: B
+    needs: [A]
+    steps:
+      - name: b
+        run: echo b
+`
+	_, err := core.ParsePipeline([]byte(yml))
+	if err == nil || !strings.Contains(err.Error(), "cycle") {
+		t.Fatalf("expected cycle error, got %v", err)
+	}
+}
+
+// ✅ Test that independent stages are released together and dependents wait
+func TestProgressReleasesIndependentStages(t *testing.T) {
+	yml := `
+stages:
+  - name: Build
+    steps:
+      - name: Compile
+        run: go build ./...
+  - name: Lint
+    needs: [Build]
+    steps:
+      - name: Vet
+        run: go vet ./...
+  - name: Test
+    needs: [Build]
+    steps:
+      - name: Unit
+        run: go test ./...
+  - name: Deploy
+    needs: [Lint, Test]
+    steps:
+      - name: Ship
+        run: echo deploy
+`
+	pipeline, err := core.ParsePipeline([]byte(yml))
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	progress, err := core.NewScheduler().Track(pipeline)
+	if err != nil {
+		t.Fatalf("track failed: %v", err)
+	}
+
+	first := progress.Next()
+	if len(first) != 1 || first[0].Stage != "Build" {
+		t.Fatalf("expected only Build to be ready, got %v", first)
+	}
+	progress.Complete(first[0])
+
+	second := progress.Next()
+	if len(second) != 2 || second[0].Stage != "Lint" || second[1].Stage != "Test" {
+		t.Fatalf("expected Lint and Test to be ready, got %v", second)
+	}
+	progress.Complete(second[0])
+	if next := progress.Next(); len(next) != 0 {
+		t.Fatalf("Deploy released before Test finished: %v", next)
+	}
+	progress.Complete(second[1])
+
+	third := progress.Next()
+	if len(third) != 1 || third[0].Stage != "Deploy" {
+		t.Fatalf("expected Deploy to be ready, got %v", third)
+	}
+	progress.Complete(third[0])
+	if !progress.Done() {
+		t.Errorf("expected pipeline to be done")
+	}
+}
+
+// ✅ Test that a parallel stage runs every step, keeps one log per step and fails if one step fails
+func TestRunnerParallelStage(t *testing.T) {
+	yml := `
+stages:
+  - name: Test
+    parallel: true
+    max_parallel: 2
+    steps:
+      - name: one
+        run: echo one
+      - name: two
+        run: exit 3
+      - name: three
+        run: echo three
+`
+	pipeline, err := core.ParsePipeline([]byte(yml))
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+
+	runner := core.NewRunner()
+	runner.LogStorage = storage.NewLogStorage(t.TempDir())
+
+	results, err := runner.RunPipeline(pipeline)
+	if err == nil {
+		t.Fatalf("expected stage failure, got success")
+	}
+	for _, step := range []string{"one", "two", "three"} {
+		if _, ok := results["Test/"+step]; !ok {
+			t.Errorf("missing log for step %s", step)
+		}
+	}
+}
+
+// ✅ Test that max_parallel caps the steps released at once
+func TestProgressMaxParallel(t *testing.T) {
+	pipeline := &core.Pipeline{Stages: []core.Stage{{
+		Name:        "Test",
+		Parallel:    true,
+		MaxParallel: 2,
+		Steps: []core.Step{
+			{Name: "a", Run: "true"},
+			{Name: "b", Run: "true"},
+			{Name: "c", Run: "true"},
+		},
+	}}}
+	progress, err := core.NewScheduler().Track(pipeline)
+	if err != nil {
+		t.Fatalf("track failed: %v", err)
+	}
+
+	first := progress.Next()
+	if len(first) != 2 {
+		t.Fatalf("expected 2 steps in flight, got %v", first)
+	}
+	progress.Complete(first[0])
+	if next := progress.Next(); len(next) != 1 || next[0].Step != "c" {
+		t.Fatalf("expected step c to be released, got %v", next)
+	}
+}
@@ -0,0 +1,71 @@
+package tests
+
+import (
+	"blockci-q/internal/core"
+	"errors"
+	"strings"
+	"testing"
+)
+
+const unversionedYAML = `# demo
+name: demo
+stages:
+  - name: Build # compile everything
+    steps:
+      - name: Compile
+        run: go build ./...
+`
+
+// ✅ Test that unversioned pipelines are upgraded to the current version on parse
+func TestParsePipelineUpgradesUnversioned(t *testing.T) {
+	pipeline, err := core.ParsePipeline([]byte(unversionedYAML))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if pipeline.Version != core.PipelineVersion || pipeline.Name != "demo" || len(pipeline.Stages) != 1 {
+		t.Fatalf("unexpected pipeline: %+v", pipeline)
+	}
+
+	// problems in an upgraded document still point at the original lines
+	errs := core.ValidatePipeline([]byte(unversionedYAML + "        timeout: 5\n"))
+	if len(errs) != 1 || errs[0].Line != 8 {
+		t.Fatalf("expected one problem on line 8, got %v", errs)
+	}
+}
+
+// ✅ Test that unsupported versions are rejected at the version key
+func TestParsePipelineRejectsUnknownVersion(t *testing.T) {
+	_, err := core.ParsePipeline([]byte("name: demo\nversion: 99\nstages: []\n"))
+	var problems core.ValidationErrors
+	if !errors.As(err, &problems) || len(problems) != 1 || problems[0].Line != 2 ||
+		!strings.Contains(problems[0].Message, "unsupported pipeline version 99") {
+		t.Fatalf("expected an unsupported version problem on line 2, got %v", err)
+	}
+	if _, err := core.ParsePipeline([]byte("version: one\nstages: []\n")); err == nil {
+		t.Fatal("expected a non-integer version to be rejected")
+	}
+}
+
+// ✅ Test that migration adds the version, keeps comments and is idempotent
+func TestMigratePipeline(t *testing.T) {
+	migrated, from, err := core.MigratePipeline([]byte(unversionedYAML))
+	if err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	if from != 0 {
+		t.Fatalf("expected version 0, got %d", from)
+	}
+	out := string(migrated)
+	if !strings.HasPrefix(out, "# demo\nversion: 1\n") || !strings.Contains(out, "# compile everything") {
+		t.Fatalf("unexpected migrated document:\n%s", out)
+	}
+
+	again, from, err := core.MigratePipeline(migrated)
+	if err != nil || from != core.PipelineVersion || string(again) != out {
+		t.Fatalf("second migration changed the document (from %d, err %v):\n%s", from, err, again)
+	}
+
+	if _, _, err := core.MigratePipeline([]byte("stages:\n  - name: A\n    steps:\n      - name: a\n")); err == nil {
+		t.Fatal("expected an invalid pipeline not to be migrated")
+	}
+}
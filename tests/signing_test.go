@@ -0,0 +1,133 @@
+package tests
+
+import (
+	"blockci-q/internal/blockchain"
+	"blockci-q/internal/security"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ✅ Test that the agent signature recorded in a block is re-verified by the ledger
+func TestAgentSignedBlockVerify(t *testing.T) {
+	ledger, _ := blockchain.OpenLedger(filepath.Join(t.TempDir(), "ledger.jsonl"))
+	serverPub, serverPriv, _ := security.GenerateKeyPair()
+	agentPub, agentPriv, _ := security.GenerateKeyPair()
+
+	env := security.ResultEnvelope{
+		JobID:     "run-1-job-1",
+		LogHash:   "abc",
+		ExitCode:  0,
+		Timestamp: "2025-01-01T00:00:00Z",
+	}
+	sig := security.SignResult(agentPriv, env)
+	if !security.VerifyResult(agentPub, env, sig) {
+		t.Fatalf("envelope signature does not verify")
+	}
+
+	b, _ := blockchain.NewBlock(0, "Build", "Compile", "build.log", env.LogHash, "", "agent-1")
+	b.JobID = env.JobID
+	b.ExitCode = env.ExitCode
+	b.FinishedAt = env.Timestamp
+	b.AgentSig = sig
+	b.AgentPubKey = hex.EncodeToString(agentPub)
+	if err := ledger.AppendBlocks(b, serverPriv, serverPub); err != nil {
+		t.Fatalf("append failed: %v", err)
+	}
+	if err := ledger.VerifyChain(); err != nil {
+		t.Fatalf("verify failed: %v", err)
+	}
+
+	// a forged agent signature is caught even if the server re-signs the block
+	forged := *ledger.Blocks[0]
+	forged.AgentSig = security.SignResult(serverPriv, env)
+	forged.Hash, _ = forged.ComputeHash()
+	forged.Signature = security.SignData(serverPriv, []byte(forged.Hash))
+	ledger.Blocks[0] = &forged
+	if err := ledger.VerifyChain(); err == nil {
+		t.Errorf("expected agent signature failure")
+	}
+}
+
+// ✅ Test trust store validity windows and key directory loading
+func TestTrustStore(t *testing.T) {
+	pub, _, _ := security.GenerateKeyPair()
+	now := time.Now()
+
+	ts := security.NewTrustStore()
+	ts.Add(security.TrustedKey{Name: "k", PubKey: pub, NotBefore: now.Add(-time.Hour), NotAfter: now.Add(time.Hour)})
+	id := hex.EncodeToString(pub)
+
+	if err := ts.Check(id, now); err != nil {
+		t.Errorf("expected key valid now: %v", err)
+	}
+	if err := ts.Check(id, now.Add(2*time.Hour)); !errors.Is(err, security.ErrKeyNotValid) {
+		t.Errorf("expected ErrKeyNotValid after window, got %v", err)
+	}
+	other, _, _ := security.GenerateKeyPair()
+	if err := ts.Check(hex.EncodeToString(other), now); !errors.Is(err, security.ErrUntrustedKey) {
+		t.Errorf("expected ErrUntrustedKey, got %v", err)
+	}
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "other.pub"), []byte(hex.EncodeToString(other)), 0600)
+	if n, err := ts.LoadDir(dir); err != nil || n != 1 {
+		t.Fatalf("LoadDir = %d, %v", n, err)
+	}
+	if err := ts.Check(hex.EncodeToString(other), now); err != nil {
+		t.Errorf("expected key from dir trusted: %v", err)
+	}
+
+	// a retired key is pinned with the time it was rotated away
+	retired, _, _ := security.GenerateKeyPair()
+	pin := hex.EncodeToString(retired) + "\nnot_after: " + now.UTC().Format(time.RFC3339) + "\n"
+	os.WriteFile(filepath.Join(dir, "retired.pub"), []byte(pin), 0600)
+	if _, err := ts.LoadDir(dir); err != nil {
+		t.Fatalf("LoadDir with a window: %v", err)
+	}
+	if err := ts.Check(hex.EncodeToString(retired), now.Add(-time.Minute)); err != nil {
+		t.Errorf("expected retired key valid before rotation: %v", err)
+	}
+	if err := ts.Check(hex.EncodeToString(retired), now.Add(time.Minute)); !errors.Is(err, security.ErrKeyNotValid) {
+		t.Errorf("expected retired key rejected after rotation, got %v", err)
+	}
+}
+
+// ✅ Test passphrase-encrypted private key files
+func TestEncryptedKeyFile(t *testing.T) {
+	pub, priv, _ := security.GenerateKeyPair()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "server.priv")
+	pass := []byte("correct horse")
+
+	if err := security.SaveEncryptedPrivateKey(priv, path, pass); err != nil {
+		t.Fatalf("save failed: %v", err)
+	}
+	data, _ := os.ReadFile(path)
+	if !security.IsEncryptedKey(data) || strings.Contains(string(data), hex.EncodeToString(priv)) {
+		t.Fatal("expected key file to be encrypted")
+	}
+
+	if _, err := security.LoadPrivateKeyFile(path, nil); !errors.Is(err, security.ErrPassphraseRequired) {
+		t.Errorf("expected ErrPassphraseRequired, got %v", err)
+	}
+	wrong := func() ([]byte, error) { return []byte("wrong"), nil }
+	if _, err := security.LoadPrivateKeyFile(path, wrong); !errors.Is(err, security.ErrWrongPassphrase) {
+		t.Errorf("expected ErrWrongPassphrase, got %v", err)
+	}
+	loaded, err := security.LoadPrivateKeyFile(path, func() ([]byte, error) { return pass, nil })
+	if err != nil || !loaded.Equal(priv) {
+		t.Fatalf("expected original key back, got err %v", err)
+	}
+
+	// plaintext key files still load without a passphrase
+	plainPriv := filepath.Join(dir, "plain.priv")
+	security.SaveKeyPair(pub, priv, filepath.Join(dir, "plain.pub"), plainPriv)
+	if loaded, err := security.LoadPrivateKeyFile(plainPriv, nil); err != nil || !loaded.Equal(priv) {
+		t.Errorf("expected plaintext key to load, got err %v", err)
+	}
+}
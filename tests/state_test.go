@@ -0,0 +1,56 @@
+package tests
+
+import (
+	"blockci-q/internal/blockchain"
+	"blockci-q/internal/core"
+	"blockci-q/internal/security"
+	"path/filepath"
+	"testing"
+)
+
+// ✅ Test the step state machine
+func TestStepStateTransitions(t *testing.T) {
+	valid := [][2]core.State{
+		{core.StatePending, core.StateQueued},
+		{core.StateQueued, core.StateRunning},
+		{core.StateRunning, core.StateFailed},
+		{core.StateRunning, core.StateTimedOut},
+		{core.StatePending, core.StateSkipped},
+	}
+	for _, tr := range valid {
+		if err := core.StepStates.Transition(tr[0], tr[1]); err != nil {
+			t.Errorf("expected %s -> %s to be allowed: %v", tr[0], tr[1], err)
+		}
+	}
+
+	invalid := [][2]core.State{
+		{core.StatePending, core.StateSucceeded},
+		{core.StateSucceeded, core.StateFailed},
+		{core.StateFailed, core.StateSucceeded},
+	}
+	for _, tr := range invalid {
+		if err := core.StepStates.Transition(tr[0], tr[1]); err == nil {
+			t.Errorf("expected %s -> %s to be rejected", tr[0], tr[1])
+		}
+	}
+}
+
+// ✅ Test that a failed step cannot be rewritten as a successful one
+func TestBlockOutcomeIsTamperEvident(t *testing.T) {
+	ledger, _ := blockchain.OpenLedger(filepath.Join(t.TempDir(), "ledger.jsonl"))
+	pub, priv, _ := security.GenerateKeyPair()
+
+	b, _ := blockchain.NewBlock(0, "Build", "Compile", "build.log", "abc", "", "agent-1")
+	b.Outcome = string(core.StateFailed)
+	if err := ledger.AppendBlocks(b, priv, pub); err != nil {
+		t.Fatalf("append failed: %v", err)
+	}
+	if err := ledger.VerifyChain(); err != nil {
+		t.Fatalf("verify failed: %v", err)
+	}
+
+	ledger.Blocks[0].Outcome = string(core.StateSucceeded)
+	if err := ledger.VerifyChain(); err == nil {
+		t.Errorf("expected verification failure after rewriting the outcome")
+	}
+}
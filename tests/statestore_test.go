@@ -0,0 +1,65 @@
+package tests
+
+import (
+	"blockci-q/internal/storage"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// ✅ Test that records survive a reopen, with and without a snapshot
+func TestFileStateStoreReload(t *testing.T) {
+	dir := t.TempDir()
+	st, err := storage.OpenFileStateStore(dir)
+	if err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+	st.SnapshotEvery = 2
+
+	_ = st.Put("agent", "agent-1", map[string]string{"id": "agent-1"})
+	_ = st.Put("agent", "agent-2", map[string]string{"id": "agent-2"}) // triggers a snapshot
+	_ = st.Delete("agent", "agent-1")
+	_ = st.Put("queue", "jobs", []string{"p-1-job-1"})
+
+	// reopen without Close: state comes from snapshot + WAL
+	st2, err := storage.OpenFileStateStore(dir)
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	records, _ := st2.Load()
+	if _, ok := records["agent"]["agent-1"]; ok {
+		t.Errorf("deleted record came back")
+	}
+	if _, ok := records["agent"]["agent-2"]; !ok {
+		t.Errorf("snapshotted record lost")
+	}
+	if string(records["queue"]["jobs"]) != `["p-1-job-1"]` {
+		t.Errorf("WAL record lost, got %s", records["queue"]["jobs"])
+	}
+}
+
+// ✅ Test that a torn WAL tail is dropped and later writes still reload
+func TestFileStateStoreTornWAL(t *testing.T) {
+	dir := t.TempDir()
+	st, _ := storage.OpenFileStateStore(dir)
+	_ = st.Put("meta", "counters", map[string]int{"pipelineSeq": 3})
+
+	f, _ := os.OpenFile(filepath.Join(dir, "wal.jsonl"), os.O_WRONLY|os.O_APPEND, 0600)
+	f.WriteString(`{"op":"put","kind":"meta","ke`)
+	f.Close()
+
+	st2, err := storage.OpenFileStateStore(dir)
+	if err != nil {
+		t.Fatalf("reopen with torn tail failed: %v", err)
+	}
+	_ = st2.Put("agent", "agent-1", "x")
+
+	st3, err := storage.OpenFileStateStore(dir)
+	if err != nil {
+		t.Fatalf("reopen after recovery failed: %v", err)
+	}
+	records, _ := st3.Load()
+	if string(records["meta"]["counters"]) != `{"pipelineSeq":3}` || records["agent"]["agent-1"] == nil {
+		t.Errorf("unexpected records after recovery: %v", records)
+	}
+}
@@ -0,0 +1,94 @@
+package tests
+
+import (
+	"blockci-q/internal/core"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// ✅ Test that every schema problem is reported with its line and column
+func TestValidatePipelineReportsPositions(t *testing.T) {
+	yml := `name: demo
+agnet: agent-1
+stages:
+  - name: Build
+    steps:
+      - name: Echo
+        run: echo hi
+      - name: Echo
+        run:
+  - name: Test
+    needs: [Build, Deploy]
+    steps:
+      - name: Unit
+  - name: Build
+    steps: []
+`
+	errs := core.ValidatePipeline([]byte(yml))
+	want := []struct {
+		line, column int
+		contains     string
+	}{
+		{2, 1, `unknown key "agnet"`},
+		{8, 15, `duplicate step name "Echo" (first defined on line 6)`},
+		{9, 13, "run: must not be empty"},
+		{11, 20, `needs unknown stage "Deploy"`},
+		{13, 9, "run is required"},
+		{14, 11, `duplicate stage name "Build" (first defined on line 4)`},
+	}
+	if len(errs) != len(want) {
+		t.Fatalf("expected %d problems, got %d:\n%v", len(want), len(errs), errs)
+	}
+	for i, w := range want {
+		e := errs[i]
+		if e.Line != w.line || e.Column != w.column || !strings.Contains(e.Error(), w.contains) {
+			t.Errorf("problem %d: got %q at %d:%d, want %q at %d:%d", i, e.Error(), e.Line, e.Column, w.contains, w.line, w.column)
+		}
+	}
+}
+
+// ✅ Test that cycles, bad types and syntax errors are positioned too
+func TestValidatePipelineCyclesAndTypes(t *testing.T) {
+	yml := `stages:
+  - name: A
+    needs: [B]
+    parallel: maybe
+    steps:
+      - name: a
+        run: echo a
+  - name: B
+    needs: [A]
+    max_parallel: -1
+    steps: oops
+`
+	errs := core.ValidatePipeline([]byte(yml))
+	got := errs.Error()
+	for _, want := range []string{
+		"line 3, column 13: stages[0].needs: stage dependency cycle: A -> B -> A",
+		"line 4, column 15: stages[0].parallel: must be true or false",
+		"line 10, column 19: stages[1].max_parallel: must not be negative",
+		"line 11, column 12: stages[1].steps: must be a list",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in:\n%s", want, got)
+		}
+	}
+
+	errs = core.ValidatePipeline([]byte("stages:\n  - name: [a\n"))
+	if len(errs) != 1 || errs[0].Line == 0 {
+		t.Fatalf("expected one positioned syntax error, got %v", errs)
+	}
+	if errs := core.ValidatePipeline(nil); len(errs) != 1 {
+		t.Fatalf("expected an empty pipeline error, got %v", errs)
+	}
+}
+
+// ✅ Test that ParsePipeline returns the validation problems as ValidationErrors
+func TestParsePipelineReturnsValidationErrors(t *testing.T) {
+	_, err := core.ParsePipeline([]byte("stages:\n  - name: A\n    steps:\n      - name: a\n        run: echo a\n        timeout: 5\n"))
+	var problems core.ValidationErrors
+	if !errors.As(err, &problems) || len(problems) != 1 || problems[0].Line != 6 {
+		t.Fatalf("expected one problem on line 6, got %v", err)
+	}
+}